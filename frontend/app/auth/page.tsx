@@ -67,20 +67,18 @@ export default function Auth() {
   }, [router, state]);
 
   /**
-   * This function looks up whether a given email is registered with our backend already
-   * If it is registered, a webauthn "get" ceremony takes place.
-   * If it isn't, a webauthn "create" ceremony takes place instead.
+   * Our backend doesn't reveal whether an email is registered. Instead, a webauthn "get" ceremony takes place first:
+   * if the user has a passkey for this wallet, they're logged in.
+   * If they don't (or dismiss the prompt), a webauthn "create" ceremony takes place instead.
    * @param data form data from the authentication form.
    */
   async function registerOrAuthenticate(data: authenticationFormData) {
     setDisabledSubmit(true);
 
     try {
-      const subOrganizationId = await subOrganizationIdForEmail(data.email);
+      const organizationId = await discoveryOrganizationId(data.email);
 
-      if (subOrganizationId !== null) {
-        await authenticate(subOrganizationId);
-      } else {
+      if (!(await authenticate(organizationId))) {
         await signup(data.email);
       }
     } catch (e: any) {
@@ -93,16 +91,13 @@ export default function Auth() {
     setDisabledSubmit(false);
   }
 
-  async function subOrganizationIdForEmail(
-    email: string
-  ): Promise<string | null> {
+  // Returns the organization to stamp whoami requests for. This is our parent organization: Turnkey
+  // finds the user's sub-organization from the passkey used.
+  async function discoveryOrganizationId(email: string): Promise<string> {
     const res = await axios.get(registrationStatusUrl(email));
 
-    // If API returns a non-empty 200, this email maps to an existing user.
     if (res.status == 200) {
-      return res.data["subOrganizationId"];
-    } else if (res.status === 204) {
-      return null;
+      return res.data["organizationId"];
     } else {
       throw new Error(
         `Unexpected response from registration status endpoint: ${res.status}: ${res.data}`
@@ -110,11 +105,12 @@ export default function Auth() {
     }
   }
 
-  // In order to know whether the user is logged in for `subOrganizationId`, we make them sign
+  // In order to know which sub-organization the user is logged in for, we make them sign
   // a request for Turnkey's "whoami" endpoint.
   // The backend will then forward to Turnkey and get a response on whether the stamp was valid.
   // If this is successful, our backend will issue a logged in session.
-  async function authenticate(subOrganizationId: string) {
+  // Returns false if the user has no passkey for this wallet.
+  async function authenticate(organizationId: string): Promise<boolean> {
     const stamper = new WebauthnStamper({
       rpId: process.env.NEXT_PUBLIC_DEMO_PASSKEY_WALLET_RPID!,
     });
@@ -128,10 +124,11 @@ export default function Auth() {
     var signedRequest;
     try {
       signedRequest = await client.stampGetWhoami({
-        organizationId: subOrganizationId,
+        organizationId: organizationId,
       });
     } catch (e) {
-      throw new Error(`Error during webauthn prompt: ${e}`);
+      console.log(`No passkey used during webauthn prompt: ${e}`);
+      return false;
     }
 
     const res = await axios.post(
@@ -139,14 +136,16 @@ export default function Auth() {
       {
         signedWhoamiRequest: signedRequest,
       },
-      { withCredentials: true }
+      { withCredentials: true, validateStatus: (status) => status < 500 }
     );
 
     if (res.status === 200) {
       console.log("Successfully logged in! Redirecting you to dashboard");
       mutate(whoamiUrl());
       router.push("/dashboard");
-      return;
+      return true;
+    } else if (res.status === 401) {
+      return false;
     } else {
       throw new Error(
         `Unexpected response from authentication endpoint: ${res.status}: ${res.data}`
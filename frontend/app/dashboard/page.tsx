@@ -186,6 +186,14 @@ export default function Dashboard() {
     setDisabledSend(true);
     try {
       const constructedTx = await constructTransaction(formData);
+      const warnings: string[] = constructedTx["warnings"] || [];
+      if (
+        warnings.length > 0 &&
+        !confirm(warnings.join("\n") + "\n\nSend anyway?")
+      ) {
+        setDisabledSend(false);
+        return;
+      }
       const stamper = await getCurrentStamper(constructedTx["organizationId"]);
       await sendTransaction(stamper, constructedTx, formData);
     } catch (e: any) {
@@ -330,7 +338,7 @@ export default function Dashboard() {
                   To address
                 </h3>
                 <p className="text-sm mt-1">
-                  Enter the destination for your transaction. By default, you’ll
+                  Enter the destination address or ENS name for your transaction. By default, you’ll
                   send back to Turnkey’s faucet.
                 </p>
               </div>
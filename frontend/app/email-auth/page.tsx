@@ -28,8 +28,6 @@ type EmailAuthFormData = {
 
 type EmailAuthUserInfo = {
   organizationId: string;
-  userId: string;
-  apiKeyId: string;
 };
 
 export default function EmailAuthPage() {
@@ -62,9 +60,8 @@ export default function EmailAuthPage() {
   }, [router, state]);
 
   /**
-   * This function looks up whether a given email is registered with our backend
-   * If it is registered, it initializes email auth with Turnkey, which triggers an email.
-   * If it isn't, an error is returned.
+   * This function asks our backend to initialize email auth with Turnkey, which triggers an email
+   * if the email belongs to a verified account. The response is the same either way.
    * @param data form data from the authentication form.
    */
   async function initEmailAuth(data: InitEmailAuthFormData) {
@@ -79,9 +76,8 @@ export default function EmailAuthPage() {
         targetPublicKey: iframeStamper.publicKey(),
       });
       if (res.status == 200) {
+        // Our parent organization: Turnkey resolves the sub-organization once the credential bundle is injected
         setEmailAuthUserInfo({
-          userId: res.data["userId"],
-          apiKeyId: res.data["apiKeyId"],
           organizationId: res.data["organizationId"],
         });
         setDisabledSubmit(false);
@@ -89,8 +85,8 @@ export default function EmailAuthPage() {
         alert("unexpected status: " + res);
       }
     } catch (e: any) {
-      if (e.name === "AxiosError" && e["response"]["status"] === 403) {
-        alert("no user found for email");
+      if (e.name === "AxiosError" && e["response"]["status"] === 429) {
+        alert("too many attempts, please try again later");
         window.location.reload();
       }
       console.error(e);
@@ -29,7 +29,6 @@ type RecoverFormData = {
 // Info necessary to perform `RECOVER_USER` activities
 type RecoverUserInfo = {
   organizationId: string;
-  userId: string;
 };
 
 const generateRandomBuffer = (): ArrayBuffer => {
@@ -73,9 +72,8 @@ export default function RecoveryPage() {
   }, [router, state]);
 
   /**
-   * This function looks up whether a given email is registered with our backend
-   * If it is registered, it initializes recovery with Turnkey, which triggers an email
-   * If it isn't, an error is returned.
+   * This function asks our backend to initialize recovery with Turnkey, which triggers an email
+   * if the email belongs to a verified account. The response is the same either way.
    * @param data form data from the authentication form.
    */
   async function initRecovery(data: RecoveryFormData) {
@@ -90,8 +88,8 @@ export default function RecoveryPage() {
         targetPublicKey: iframeStamper.publicKey(),
       });
       if (res.status == 200) {
+        // Our parent organization. The actual sub-organization and user are known once the recovery credential is injected.
         setRecoverUserInfo({
-          userId: res.data["userId"],
           organizationId: res.data["organizationId"],
         });
         setDisabledSubmit(false);
@@ -99,8 +97,8 @@ export default function RecoveryPage() {
         alert("unexpected status: " + res);
       }
     } catch (e: any) {
-      if (e.name === "AxiosError" && e["response"]["status"] === 403) {
-        alert("no user found for email");
+      if (e.name === "AxiosError" && e["response"]["status"] === 429) {
+        alert("too many attempts, please try again later");
         window.location.reload();
       }
       console.error(e);
@@ -160,12 +158,17 @@ export default function RecoveryPage() {
       iframeStamper
     );
 
+    // Turnkey resolves the sub-organization and user the recovery credential belongs to
+    const whoami = await client.getWhoami({
+      organizationId: recoverUserInfo.organizationId,
+    });
+
     const signedRequest = await client.stampRecoverUser({
       type: "ACTIVITY_TYPE_RECOVER_USER",
       timestampMs: String(Date.now()),
-      organizationId: recoverUserInfo.organizationId,
+      organizationId: whoami.organizationId,
       parameters: {
-        userId: recoverUserInfo.userId,
+        userId: whoami.userId,
         authenticator: {
           authenticatorName: data.authenticatorName,
           challenge: base64UrlEncode(challenge),
@@ -2,7 +2,7 @@
 
 import { TurnkeyClient } from "@turnkey/http";
 import axios from "axios";
-import { exportWalletUrl } from "../utils/urls";
+import { exportWalletUrl, stepUpUrl } from "../utils/urls";
 import { useEffect, useState } from "react";
 import { IframeStamper } from "@turnkey/iframe-stamper";
 import { WebauthnStamper } from "@turnkey/webauthn-stamper";
@@ -63,6 +63,16 @@ export function ExportWallet(props: ExportWalletProps) {
       webauthnStamper
     );
 
+    // Exports need a recent passkey confirmation on top of the session
+    const signedWhoamiRequest = await client.stampGetWhoami({
+      organizationId: props.organizationId,
+    });
+    await axios.post(
+      stepUpUrl(),
+      { signedWhoamiRequest },
+      { withCredentials: true }
+    );
+
     const signedRequest = await client.stampExportWallet({
       type: "ACTIVITY_TYPE_EXPORT_WALLET",
       timestampMs: String(Date.now()),
@@ -73,9 +83,13 @@ export function ExportWallet(props: ExportWalletProps) {
       },
     });
 
-    const res = await axios.post(exportWalletUrl(), {
-      signedExportRequest: signedRequest,
-    });
+    const res = await axios.post(
+      exportWalletUrl(),
+      {
+        signedExportRequest: signedRequest,
+      },
+      { withCredentials: true }
+    );
 
     if (res.status === 200) {
       try {
@@ -14,6 +14,8 @@ type transfer = {
   block: number;
   source: string;
   destination: string;
+  sourceName?: string;
+  destinationName?: string;
   amount: string;
   hash: string;
 };
@@ -95,10 +97,14 @@ export function History() {
                     {transfer.amount} ETH
                   </div>
                   <div className="table-cell p-3 font-mono">
-                    {abbreviateAddress(transfer.source)}
+                    <span title={transfer.source}>
+                      {transfer.sourceName || abbreviateAddress(transfer.source)}
+                    </span>
                   </div>
                   <div className="table-cell p-3 font-mono">
-                    {abbreviateAddress(transfer.destination)}
+                    <span title={transfer.destination}>
+                      {transfer.destinationName || abbreviateAddress(transfer.destination)}
+                    </span>
                   </div>
                 </div>
               ))}
@@ -36,6 +36,10 @@ export function exportWalletUrl(): string {
   return BACKEND_API_BASE_URL + "/api/wallet/export";
 }
 
+export function stepUpUrl(): string {
+  return BACKEND_API_BASE_URL + "/api/step-up";
+}
+
 export function dropUrl(): string {
   return BACKEND_API_BASE_URL + "/api/wallet/drop";
 }
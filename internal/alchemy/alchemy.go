@@ -2,6 +2,7 @@ package alchemy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"os"
 	"sort"
 	"strconv"
-	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -19,9 +19,12 @@ type Transfer struct {
 	Type        string `json:"type"`
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
-	Amount      string `json:"amount"`
-	Hash        string `json:"hash"`
-	Block       int64  `json:"block"`
+	// Primary ENS names of the source and destination, when they have one
+	SourceName      string `json:"sourceName,omitempty"`
+	DestinationName string `json:"destinationName,omitempty"`
+	Amount          string `json:"amount"`
+	Hash            string `json:"hash"`
+	Block           int64  `json:"block"`
 }
 
 // Sample result:
@@ -68,13 +71,13 @@ type AlchemyTransaction struct {
 	BlockNum string
 }
 
-func TransactionHistory(address string) ([]*Transfer, error) {
-	deposits, err := listTransfers("", address)
+func TransactionHistory(ctx context.Context, address string) ([]*Transfer, error) {
+	deposits, err := listTransfers(ctx, "", address)
 	if err != nil {
 		return []*Transfer{}, errors.Wrapf(err, "error while listing deposits for address %s", address)
 	}
 
-	withdrawals, err := listTransfers(address, "")
+	withdrawals, err := listTransfers(ctx, address, "")
 	if err != nil {
 		return []*Transfer{}, errors.Wrapf(err, "error while listing withdrawals for address %s", address)
 	}
@@ -88,42 +91,42 @@ func TransactionHistory(address string) ([]*Transfer, error) {
 	return transfersList, nil
 }
 
-func listTransfers(from, to string) ([]*Transfer, error) {
+// Returns true if address ever sent ETH to destination
+func HasSentTo(ctx context.Context, address, destination string) (bool, error) {
+	transfers, err := listTransfers(ctx, address, destination)
+	if err != nil {
+		return false, errors.Wrapf(err, "error while listing transfers from %s to %s", address, destination)
+	}
+	return len(transfers) > 0, nil
+}
+
+func listTransfers(ctx context.Context, from, to string) ([]*Transfer, error) {
 	alchemyApiKey := os.Getenv("ALCHEMY_API_KEY")
 	if alchemyApiKey == "" {
 		log.Fatal("ALCHEMY_API_KEY is not set")
 	}
 	url := fmt.Sprintf("https://eth-sepolia.g.alchemy.com/v2/%s", alchemyApiKey)
 
-	var body string
+	filter := map[string]interface{}{
+		"category": []string{"external"},
+	}
 	if from != "" {
-		body = strings.TrimSpace(fmt.Sprintf(`{
-			"jsonrpc": "2.0",
-			"id": 0,
-			"method": "alchemy_getAssetTransfers",
-			"params": [
-			  {
-				"fromAddress": %q,
-				"category": ["external"]
-			  }
-			]
-		}`, from))
+		filter["fromAddress"] = from
 	}
 	if to != "" {
-		body = strings.TrimSpace(fmt.Sprintf(`{
-			"jsonrpc": "2.0",
-			"id": 0,
-			"method": "alchemy_getAssetTransfers",
-			"params": [
-			  {
-				"toAddress": %q,
-				"category": ["external"]
-			  }
-			]
-		}`, to))
+		filter["toAddress"] = to
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      0,
+		"method":  "alchemy_getAssetTransfers",
+		"params":  []interface{}{filter},
+	})
+	if err != nil {
+		return []*Transfer{}, errors.Wrap(err, "error while encoding tx history request")
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(body)))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return []*Transfer{}, errors.Wrap(err, "error while creating http POST request for tx history")
 	}
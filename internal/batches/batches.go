@@ -0,0 +1,383 @@
+package batches
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/ens"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/spending"
+)
+
+// Every payment is signed with its own passkey prompt: larger payouts should be split
+const MAX_ROWS = 100
+
+// Uploaded files larger than this are rejected before parsing
+const MAX_FILE_SIZE = 1 << 20
+
+const MAX_MEMO_LENGTH = 255
+
+// Columns of the CSV header. Token and memo are optional.
+const COLUMN_ADDRESS = "address"
+const COLUMN_AMOUNT = "amount"
+const COLUMN_TOKEN = "token"
+const COLUMN_MEMO = "memo"
+
+// Progress of a payment, once its transaction is tracked
+const PAYMENT_STATUS_PENDING = "pending"
+const PAYMENT_STATUS_CONFIRMED = "confirmed"
+
+// A row of an uploaded CSV file
+type Row struct {
+	Line    int
+	Address string
+	Amount  string
+	Token   string
+	Memo    string
+}
+
+// Problems are reported for every invalid row at once, so that files can be fixed in one go
+type RowError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Returned by Validate when rows are invalid
+type ValidationError struct {
+	Rows []RowError
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%d invalid row(s)", len(e.Rows))
+}
+
+// A validated row
+type Payment struct {
+	Row
+	Destination common.Address
+	// Nil for ETH
+	Token *common.Address
+	// In wei or token base units
+	Value *big.Int
+}
+
+// Returns the transaction paying a row: an ETH transfer, or a call to the token contract
+func (p *Payment) Transaction() (spending.Transaction, error) {
+	if p.Token == nil {
+		return spending.Transaction{To: p.Destination, Value: p.Value}, nil
+	}
+	data, err := ethereum.EncodeTokenTransfer(p.Destination, p.Value)
+	if err != nil {
+		return spending.Transaction{}, err
+	}
+	return spending.Transaction{To: *p.Token, Value: new(big.Int), Data: data}, nil
+}
+
+// Parses a CSV file of payments. The first line is a header naming the columns, in any order:
+// address and amount are required, token and memo are optional.
+func ParseCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(io.LimitReader(r, MAX_FILE_SIZE))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CSV header")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case COLUMN_ADDRESS, COLUMN_AMOUNT, COLUMN_TOKEN, COLUMN_MEMO:
+			if _, ok := columns[name]; ok {
+				return nil, fmt.Errorf("duplicate %q column", name)
+			}
+			columns[name] = i
+		default:
+			return nil, fmt.Errorf("unknown column %q: expected %s, %s, and optionally %s and %s", name, COLUMN_ADDRESS, COLUMN_AMOUNT, COLUMN_TOKEN, COLUMN_MEMO)
+		}
+	}
+	for _, required := range []string{COLUMN_ADDRESS, COLUMN_AMOUNT} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid CSV")
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(rows) == MAX_ROWS {
+			return nil, fmt.Errorf("batches are limited to %d payments", MAX_ROWS)
+		}
+		rows = append(rows, Row{
+			Line:    line,
+			Address: field(record, COLUMN_ADDRESS),
+			Amount:  field(record, COLUMN_AMOUNT),
+			Token:   field(record, COLUMN_TOKEN),
+			Memo:    field(record, COLUMN_MEMO),
+		})
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the file has no payments")
+	}
+	return rows, nil
+}
+
+// Validates every row: addresses (or ENS names), amounts, tokens, spending rules and balances.
+// spentInWindow is the value already sent within the spending policy's daily window. Limits only apply to ETH, so
+// token rows are rejected when the policy has a maximum per transaction or a daily limit (see spending.Rules).
+// Invalid rows are reported in a *ValidationError.
+func Validate(ctx context.Context, source common.Address, rows []Row, rules *spending.Rules, spentInWindow *big.Int) ([]Payment, error) {
+	var rowErrors []RowError
+	fail := func(row Row, format string, args ...interface{}) {
+		rowErrors = append(rowErrors, RowError{Line: row.Line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	decimals := map[common.Address]uint8{}
+	totals := map[common.Address]*big.Int{} // by token, the zero address for ETH
+	spent := new(big.Int).Set(spentInWindow)
+	payments := []Payment{}
+	for _, row := range rows {
+		payment := Payment{Row: row}
+
+		destination, err := resolveAddress(ctx, row.Address)
+		if err != nil {
+			fail(row, "invalid address: %s", err.Error())
+			continue
+		}
+		payment.Destination = destination
+		if len(row.Memo) > MAX_MEMO_LENGTH {
+			fail(row, "memos are limited to %d characters", MAX_MEMO_LENGTH)
+			continue
+		}
+
+		unitDecimals := uint8(18)
+		if row.Token != "" {
+			token, err := ethereum.ParseAddress(row.Token)
+			if err != nil {
+				fail(row, "invalid token: %s", err.Error())
+				continue
+			}
+			if _, ok := decimals[token]; !ok {
+				tokenDecimals, err := ethereum.TokenDecimals(ctx, token)
+				if err != nil {
+					fail(row, "invalid token: %s", err.Error())
+					continue
+				}
+				decimals[token] = tokenDecimals
+			}
+			payment.Token = &token
+			unitDecimals = decimals[token]
+		}
+		payment.Value, err = ethereum.ParseUnits(row.Amount, unitDecimals)
+		if err != nil {
+			fail(row, "invalid amount: %s", err.Error())
+			continue
+		}
+		if payment.Value.Sign() == 0 {
+			fail(row, "amount must be positive")
+			continue
+		}
+
+		if !rules.IsEmpty() {
+			tx, err := payment.Transaction()
+			if err != nil {
+				return nil, err
+			}
+			// Earlier rows count towards the daily limit
+			if err := rules.Evaluate(tx, spent); err != nil {
+				fail(row, "%s", err.Error())
+				continue
+			}
+			spent.Add(spent, tx.Value)
+		}
+
+		key := common.Address{}
+		if payment.Token != nil {
+			key = *payment.Token
+		}
+		if _, ok := totals[key]; !ok {
+			totals[key] = new(big.Int)
+		}
+		totals[key].Add(totals[key], payment.Value)
+		payments = append(payments, payment)
+	}
+	if len(rowErrors) > 0 {
+		return nil, &ValidationError{Rows: rowErrors}
+	}
+
+	// ETH also pays for fees: that's checked once transactions are constructed
+	for token, total := range totals {
+		if token == (common.Address{}) {
+			continue
+		}
+		balance, err := ethereum.TokenBalance(ctx, token, source)
+		if err != nil {
+			return nil, err
+		}
+		if balance.Cmp(total) < 0 {
+			return nil, &ValidationError{Rows: []RowError{{Message: fmt.Sprintf("the batch sends %s base units of %s, the wallet holds %s", total, token.Hex(), balance)}}}
+		}
+	}
+	return payments, nil
+}
+
+// Constructs one transaction per payment, with sequential nonces starting at the wallet's next nonce.
+// Returns the batch to store, after checking that the wallet's ETH covers the payments and their maximum fees.
+func Construct(ctx context.Context, user *models.User, source string, payments []Payment) (*models.PaymentBatch, error) {
+	sourceAddress, err := ethereum.ParseAddress(source)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := ethereum.Client.PendingNonceAt(ctx, sourceAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot fetch nonce for address %s", source)
+	}
+
+	batch := &models.PaymentBatch{UserID: user.ID, Source: source}
+	totalCost := new(big.Int)
+	for _, payment := range payments {
+		tx, err := payment.Transaction()
+		if err != nil {
+			return nil, err
+		}
+		var unsignedTransaction []byte
+		if payment.Token == nil {
+			unsignedTransaction, err = ethereum.ConstructTransfer(ctx, source, tx.To.Hex(), tx.Value, &nonce)
+		} else {
+			unsignedTransaction, _, err = ethereum.ConstructContractCall(ctx, source, tx.To.Hex(), tx.Value, tx.Data, &nonce)
+		}
+		if err != nil {
+			if ethereum.IsExecutionError(err) {
+				return nil, &ValidationError{Rows: []RowError{{Line: payment.Line, Message: errors.Wrap(err, "transaction would fail").Error()}}}
+			}
+			return nil, errors.Wrapf(err, "unable to construct the payment of line %d", payment.Line)
+		}
+
+		constructed, err := ethereum.DecodeUnsignedTransaction(hex.EncodeToString(unsignedTransaction))
+		if err != nil {
+			return nil, err
+		}
+		totalCost.Add(totalCost, constructed.Cost())
+
+		var token string
+		if payment.Token != nil {
+			token = payment.Token.Hex()
+		}
+		batch.Payments = append(batch.Payments, models.BatchPayment{
+			Line:                payment.Line,
+			Destination:         payment.Destination.Hex(),
+			Amount:              payment.Amount,
+			Value:               payment.Value.String(),
+			Token:               token,
+			Memo:                payment.Memo,
+			Nonce:               nonce,
+			UnsignedTransaction: hex.EncodeToString(unsignedTransaction),
+		})
+		nonce++
+	}
+
+	balance, err := ethereum.GetBalance(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Cmp(totalCost) < 0 {
+		return nil, &ValidationError{Rows: []RowError{{Message: fmt.Sprintf("the batch costs up to %s ETH including fees, the wallet holds %s ETH", ethereum.FormatEth(totalCost), ethereum.FormatEth(balance))}}}
+	}
+	return batch, nil
+}
+
+// Status of each payment and of the batch as a whole
+type Progress struct {
+	ID        uint              `json:"id"`
+	Source    string            `json:"source"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Counts    map[string]int    `json:"counts"`
+	Completed bool              `json:"completed"`
+	Payments  []PaymentProgress `json:"payments"`
+}
+
+type PaymentProgress struct {
+	models.BatchPayment
+	// constructed, pending, confirmed or failed
+	Status string `json:"status"`
+	Block  int64  `json:"block,omitempty"`
+}
+
+// Reports a batch's progress. Broadcast payments are pending until the block watcher sees their receipt.
+func GetProgress(batch *models.PaymentBatch) (*Progress, error) {
+	var hashes []string
+	for _, payment := range batch.Payments {
+		if payment.Hash != "" {
+			hashes = append(hashes, payment.Hash)
+		}
+	}
+	transactions, err := models.FindTransactionsByHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &Progress{
+		ID:        batch.ID,
+		Source:    batch.Source,
+		CreatedAt: batch.CreatedAt,
+		Counts:    map[string]int{},
+		Payments:  []PaymentProgress{},
+	}
+	for _, payment := range batch.Payments {
+		paymentProgress := PaymentProgress{BatchPayment: payment, Status: payment.Status}
+		if payment.Status == models.BATCH_PAYMENT_STATUS_BROADCAST {
+			paymentProgress.Status = PAYMENT_STATUS_PENDING
+			if tx, ok := transactions[payment.Hash]; ok {
+				switch tx.Status {
+				case models.TRANSACTION_STATUS_CONFIRMED:
+					paymentProgress.Status = PAYMENT_STATUS_CONFIRMED
+				case models.TRANSACTION_STATUS_FAILED:
+					paymentProgress.Status = models.BATCH_PAYMENT_STATUS_FAILED
+					paymentProgress.Error = "the transaction reverted"
+				}
+				paymentProgress.Block = tx.Block.Int64
+			}
+		}
+		progress.Counts[paymentProgress.Status]++
+		progress.Payments = append(progress.Payments, paymentProgress)
+	}
+	progress.Completed = progress.Counts[PAYMENT_STATUS_CONFIRMED]+progress.Counts[models.BATCH_PAYMENT_STATUS_FAILED] == len(batch.Payments)
+	return progress, nil
+}
+
+func resolveAddress(ctx context.Context, address string) (common.Address, error) {
+	if !ens.IsName(address) {
+		return ethereum.ParseAddress(address)
+	}
+	return ens.Client.Resolve(ctx, address)
+}
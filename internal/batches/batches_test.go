@@ -0,0 +1,362 @@
+package batches
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/spending"
+)
+
+var source = common.HexToAddress("0x00000000000000000000000000000000000005ac")
+var alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+var bob = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+
+// An ERC-20 token with 6 decimals, known to the fake RPC node
+var usdc = common.HexToAddress("0x0000000000000000000000000000000000005dc0")
+
+// Not a contract
+var notAToken = common.HexToAddress("0x000000000000000000000000000000000000dead")
+
+func TestParseCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		rows []Row
+		err  string
+	}{
+		{
+			name: "required columns",
+			csv:  "address,amount\n" + alice.Hex() + ",0.1\n",
+			rows: []Row{{Line: 2, Address: alice.Hex(), Amount: "0.1"}},
+		},
+		{
+			name: "columns in any order and case, with spaces",
+			csv:  " Memo ,TOKEN, amount ,Address\nrent, " + usdc.Hex() + " ,12.5, alice.eth\n",
+			rows: []Row{{Line: 2, Address: "alice.eth", Amount: "12.5", Token: usdc.Hex(), Memo: "rent"}},
+		},
+		{
+			name: "short rows leave optional columns empty",
+			csv:  "address,amount,memo\n" + alice.Hex() + ",1\n",
+			rows: []Row{{Line: 2, Address: alice.Hex(), Amount: "1"}},
+		},
+		{
+			name: "blank lines are skipped, lines keep their number",
+			csv:  "address,amount\n\n" + alice.Hex() + ",1\n  \n\n" + bob.Hex() + ",2\n\n",
+			rows: []Row{{Line: 3, Address: alice.Hex(), Amount: "1"}, {Line: 6, Address: bob.Hex(), Amount: "2"}},
+		},
+		{
+			name: "duplicate rows are separate payments",
+			csv:  "address,amount\n" + alice.Hex() + ",1\n" + alice.Hex() + ",1\n",
+			rows: []Row{{Line: 2, Address: alice.Hex(), Amount: "1"}, {Line: 3, Address: alice.Hex(), Amount: "1"}},
+		},
+		{
+			name: "quoted memo",
+			csv:  "address,amount,memo\n" + alice.Hex() + ",1,\"March, April\"\n",
+			rows: []Row{{Line: 2, Address: alice.Hex(), Amount: "1", Memo: "March, April"}},
+		},
+		{name: "empty file", csv: "", err: "the file is empty"},
+		{name: "header only", csv: "address,amount\n", err: "the file has no payments"},
+		{name: "missing amount column", csv: "address,memo\n" + alice.Hex() + ",rent\n", err: `missing "amount" column`},
+		{name: "unknown column", csv: "address,amount,note\n", err: `unknown column "note"`},
+		{name: "duplicate column", csv: "address,amount,Amount\n", err: `duplicate "amount" column`},
+		{name: "no header", csv: alice.Hex() + ",1\n", err: "unknown column"},
+		{name: "unterminated quote", csv: "address,amount,memo\n" + alice.Hex() + ",1,\"rent\n", err: "invalid CSV"},
+		{name: "too many rows", csv: "address,amount\n" + strings.Repeat(alice.Hex()+",1\n", MAX_ROWS+1), err: fmt.Sprintf("limited to %d payments", MAX_ROWS)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := ParseCSV(strings.NewReader(test.csv))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(test.rows) {
+				t.Fatalf("expected %d rows, got %+v", len(test.rows), rows)
+			}
+			for i := range rows {
+				if rows[i] != test.rows[i] {
+					t.Errorf("row %d: expected %+v, got %+v", i, test.rows[i], rows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseCSVMaxRows(t *testing.T) {
+	rows, err := ParseCSV(strings.NewReader("address,amount\n" + strings.Repeat(alice.Hex()+",1\n", MAX_ROWS)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != MAX_ROWS {
+		t.Errorf("expected %d rows, got %d", MAX_ROWS, len(rows))
+	}
+}
+
+// A JSON-RPC node answering the calls batches make: nonces, fees, balances, gas estimates, and ERC-20 reads of usdc
+type fakeNode struct {
+	nonce        uint64
+	balance      *big.Int
+	tokenBalance *big.Int
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	switch request.Method {
+	case "eth_getTransactionCount":
+		result = hexutil.Uint64(n.nonce)
+	case "eth_gasPrice", "eth_maxPriorityFeePerGas":
+		result = (*hexutil.Big)(big.NewInt(1_000_000_000))
+	case "eth_getBalance":
+		result = (*hexutil.Big)(n.balance)
+	case "eth_estimateGas":
+		result = hexutil.Uint64(50_000)
+	case "eth_call":
+		var call struct {
+			To   common.Address `json:"to"`
+			Data hexutil.Bytes  `json:"data"`
+		}
+		if err := json.Unmarshal(request.Params[0], &call); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result = hexutil.Bytes{}
+		if call.To == usdc {
+			switch hex.EncodeToString(call.Data[:4]) {
+			case "313ce567": // decimals()
+				result = hexutil.Bytes(common.BigToHash(big.NewInt(6)).Bytes())
+			case "70a08231": // balanceOf(address)
+				result = hexutil.Bytes(common.BigToHash(n.tokenBalance).Bytes())
+			}
+		}
+	default:
+		http.Error(w, "unexpected method "+request.Method, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+}
+
+// Points ethereum.Client at a fake node for the duration of the test
+func useFakeNode(t *testing.T, node *fakeNode) {
+	server := httptest.NewServer(node)
+	client, err := ethereum.NewRpcClient([]string{server.URL}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := ethereum.Client
+	ethereum.Client = client
+	t.Cleanup(func() {
+		ethereum.Client = previous
+		server.Close()
+	})
+}
+
+func eth(amount string) *big.Int {
+	wei, err := ethereum.ParseEth(amount)
+	if err != nil {
+		panic(err)
+	}
+	return wei
+}
+
+func TestValidate(t *testing.T) {
+	useFakeNode(t, &fakeNode{balance: eth("10"), tokenBalance: big.NewInt(100_000_000)})
+
+	noRules := &spending.Rules{}
+	tests := []struct {
+		name  string
+		rows  []Row
+		rules *spending.Rules
+		spent *big.Int
+		// Expected values of the payments, or errors by line
+		values []*big.Int
+		errors map[int]string
+	}{
+		{
+			name:   "ETH and token payments",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "0.5"}, {Line: 3, Address: bob.Hex(), Amount: "12.5", Token: usdc.Hex()}},
+			rules:  noRules,
+			values: []*big.Int{eth("0.5"), big.NewInt(12_500_000)},
+		},
+		{
+			name:   "lowercase address",
+			rows:   []Row{{Line: 2, Address: strings.ToLower(alice.Hex()), Amount: "1"}},
+			rules:  noRules,
+			values: []*big.Int{eth("1")},
+		},
+		{
+			name: "every invalid row is reported",
+			rows: []Row{
+				{Line: 2, Address: "0x1234", Amount: "1"},
+				{Line: 3, Address: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", Amount: "1"},
+				{Line: 4, Address: alice.Hex(), Amount: "0"},
+				{Line: 5, Address: alice.Hex(), Amount: "-1"},
+				{Line: 6, Address: alice.Hex(), Amount: "1e18"},
+				{Line: 7, Address: alice.Hex(), Amount: "0.0000001", Token: usdc.Hex()},
+				{Line: 8, Address: alice.Hex(), Amount: "1", Token: notAToken.Hex()},
+				{Line: 9, Address: alice.Hex(), Amount: "1", Memo: strings.Repeat("m", MAX_MEMO_LENGTH+1)},
+				{Line: 10, Address: alice.Hex(), Amount: "1", Memo: strings.Repeat("m", MAX_MEMO_LENGTH)},
+			},
+			rules: noRules,
+			errors: map[int]string{
+				2: "invalid address",
+				3: "invalid address",
+				4: "amount must be positive",
+				5: "invalid amount",
+				6: "invalid amount",
+				7: "more than 6 decimals",
+				8: "is not an ERC-20 token",
+				9: "memos are limited",
+			},
+		},
+		{
+			name:   "maximum per transaction",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "0.1"}, {Line: 3, Address: bob.Hex(), Amount: "0.100000000000000001"}},
+			rules:  &spending.Rules{MaxTransaction: eth("0.1")},
+			errors: map[int]string{3: "transactions are limited to 0.1 ETH"},
+		},
+		{
+			name:   "earlier rows count towards the daily limit",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "0.05"}, {Line: 3, Address: bob.Hex(), Amount: "0.05"}, {Line: 4, Address: bob.Hex(), Amount: "0.05"}},
+			rules:  &spending.Rules{DailyLimit: eth("0.15")},
+			spent:  eth("0.01"),
+			errors: map[int]string{4: "daily limit"},
+		},
+		{
+			name:   "token rows with an ETH limit",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "0.01"}, {Line: 3, Address: bob.Hex(), Amount: "1", Token: usdc.Hex()}},
+			rules:  &spending.Rules{MaxTransaction: eth("1")},
+			errors: map[int]string{3: "spending policy limits ETH amounts"},
+		},
+		{
+			name:   "token rows with a daily limit",
+			rows:   []Row{{Line: 2, Address: bob.Hex(), Amount: "1", Token: usdc.Hex()}},
+			rules:  &spending.Rules{DailyLimit: eth("1")},
+			errors: map[int]string{2: "spending policy limits ETH amounts"},
+		},
+		{
+			name:   "token recipients are checked against the allowlist",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "1", Token: usdc.Hex()}, {Line: 3, Address: bob.Hex(), Amount: "1", Token: usdc.Hex()}},
+			rules:  &spending.Rules{Allowlist: []common.Address{alice}},
+			errors: map[int]string{3: "isn't on the allowlist"},
+		},
+		{
+			name:   "token balance too low",
+			rows:   []Row{{Line: 2, Address: alice.Hex(), Amount: "60", Token: usdc.Hex()}, {Line: 3, Address: bob.Hex(), Amount: "40.000001", Token: usdc.Hex()}},
+			rules:  noRules,
+			errors: map[int]string{0: "the wallet holds 100000000"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spent := test.spent
+			if spent == nil {
+				spent = new(big.Int)
+			}
+			payments, err := Validate(context.Background(), source, test.rows, test.rules, spent)
+			if test.errors != nil {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				if len(validationErr.Rows) != len(test.errors) {
+					t.Errorf("expected errors on %d rows, got %+v", len(test.errors), validationErr.Rows)
+				}
+				for _, rowErr := range validationErr.Rows {
+					if expected, ok := test.errors[rowErr.Line]; !ok || !strings.Contains(rowErr.Message, expected) {
+						t.Errorf("line %d: expected an error containing %q, got %q", rowErr.Line, expected, rowErr.Message)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(payments) != len(test.values) {
+				t.Fatalf("expected %d payments, got %d", len(test.values), len(payments))
+			}
+			for i, payment := range payments {
+				if payment.Value.Cmp(test.values[i]) != 0 {
+					t.Errorf("payment %d: expected value %s, got %s", i, test.values[i], payment.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestConstruct(t *testing.T) {
+	node := &fakeNode{nonce: 7, balance: eth("10"), tokenBalance: big.NewInt(100_000_000)}
+	useFakeNode(t, node)
+
+	rows := []Row{
+		{Line: 2, Address: alice.Hex(), Amount: "0.5", Memo: "rent"},
+		{Line: 3, Address: bob.Hex(), Amount: "12.5", Token: usdc.Hex()},
+		{Line: 5, Address: alice.Hex(), Amount: "0.25"},
+	}
+	payments, err := Validate(context.Background(), source, rows, &spending.Rules{}, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := Construct(context.Background(), &models.User{}, source.Hex(), payments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(batch.Payments) != len(rows) {
+		t.Fatalf("expected %d payments, got %d", len(rows), len(batch.Payments))
+	}
+	for i, payment := range batch.Payments {
+		expectedNonce := node.nonce + uint64(i)
+		if payment.Nonce != expectedNonce {
+			t.Errorf("payment %d: expected nonce %d, got %d", i, expectedNonce, payment.Nonce)
+		}
+		tx, err := ethereum.DecodeUnsignedTransaction(payment.UnsignedTransaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx.Nonce() != expectedNonce {
+			t.Errorf("payment %d: expected the transaction's nonce to be %d, got %d", i, expectedNonce, tx.Nonce())
+		}
+		if payment.Line != rows[i].Line || payment.Memo != rows[i].Memo {
+			t.Errorf("payment %d doesn't match its row: %+v", i, payment)
+		}
+	}
+	if to := batch.Payments[1]; to.Token != usdc.Hex() || to.Value != "12500000" {
+		t.Errorf("unexpected token payment %+v", to)
+	}
+
+	// The same payments can't be afforded once fees are counted
+	node.balance = eth("0.75")
+	_, err = Construct(context.Background(), &models.User{}, source.Hex(), payments)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || !strings.Contains(validationErr.Rows[0].Message, "including fees") {
+		t.Errorf("expected the balance not to cover fees, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package ens
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+// The ENS registry has the same address on mainnet and Sepolia
+var REGISTRY_ADDRESS = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+// Reverse-resolved names are cached this long: history pages look up the same counterparties over and over
+const REVERSE_CACHE_TTL = 10 * time.Minute
+
+var ErrNameNotFound = errors.New("ENS name does not resolve to an address")
+var ErrInvalidName = errors.New("invalid ENS name")
+
+var Client Resolver
+
+// Resolves ENS names to addresses, and addresses to their primary name.
+// Implementations only need a bind.ContractCaller, so they run against an RPC node as well as a simulated chain.
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (common.Address, error)
+	// Returns "" when the address has no primary name, or when its name doesn't resolve back to it
+	LookupAddress(ctx context.Context, address common.Address) (string, error)
+}
+
+const registryAbi = `[
+	{"type":"function","name":"resolver","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"address"}]}
+]`
+
+const resolverAbi = `[
+	{"type":"function","name":"addr","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"name","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"string"}]}
+]`
+
+// Resolves names through the ENS registry, using the resolver contracts it points to.
+// Wildcard resolution (ENSIP-10) and offchain lookups (CCIP-read) aren't supported.
+type RegistryResolver struct {
+	caller   bind.ContractCaller
+	registry common.Address
+
+	registryAbi abi.ABI
+	resolverAbi abi.ABI
+}
+
+func NewRegistryResolver(caller bind.ContractCaller, registry common.Address) (*RegistryResolver, error) {
+	parsedRegistryAbi, err := abi.JSON(strings.NewReader(registryAbi))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid registry ABI")
+	}
+	parsedResolverAbi, err := abi.JSON(strings.NewReader(resolverAbi))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid resolver ABI")
+	}
+	return &RegistryResolver{
+		caller:      caller,
+		registry:    registry,
+		registryAbi: parsedRegistryAbi,
+		resolverAbi: parsedResolverAbi,
+	}, nil
+}
+
+// Sets Client to a cached resolver using the ENS registry through caller
+func Init(caller bind.ContractCaller) error {
+	resolver, err := NewRegistryResolver(caller, REGISTRY_ADDRESS)
+	if err != nil {
+		return err
+	}
+	Client = NewCachedResolver(resolver, REVERSE_CACHE_TTL)
+	return nil
+}
+
+func (r *RegistryResolver) Resolve(ctx context.Context, name string) (common.Address, error) {
+	normalized, err := Normalize(name)
+	if err != nil {
+		return common.Address{}, err
+	}
+	node := Namehash(normalized)
+
+	resolver, err := r.resolverOf(ctx, node)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if resolver == (common.Address{}) {
+		return common.Address{}, ErrNameNotFound
+	}
+	var address common.Address
+	if err := r.call(ctx, r.resolverAbi, resolver, "addr", &address, node); err != nil {
+		return common.Address{}, errors.Wrapf(err, "unable to resolve %s", normalized)
+	}
+	if address == (common.Address{}) {
+		return common.Address{}, ErrNameNotFound
+	}
+	return address, nil
+}
+
+func (r *RegistryResolver) LookupAddress(ctx context.Context, address common.Address) (string, error) {
+	// Reverse records live under <lowercase hex address>.addr.reverse
+	node := Namehash(strings.ToLower(address.Hex()[2:]) + ".addr.reverse")
+	resolver, err := r.resolverOf(ctx, node)
+	if err != nil {
+		return "", err
+	}
+	if resolver == (common.Address{}) {
+		return "", nil
+	}
+	var name string
+	if err := r.call(ctx, r.resolverAbi, resolver, "name", &name, node); err != nil {
+		return "", errors.Wrapf(err, "unable to look up the name of %s", address.Hex())
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	// Anyone can claim any name in their reverse record: it only counts if the name resolves back to the address
+	resolved, err := r.Resolve(ctx, name)
+	if errors.Is(err, ErrNameNotFound) || errors.Is(err, ErrInvalidName) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if resolved != address {
+		return "", nil
+	}
+	return name, nil
+}
+
+func (r *RegistryResolver) resolverOf(ctx context.Context, node common.Hash) (common.Address, error) {
+	var resolver common.Address
+	if err := r.call(ctx, r.registryAbi, r.registry, "resolver", &resolver, node); err != nil {
+		return common.Address{}, errors.Wrap(err, "unable to look up ENS resolver")
+	}
+	return resolver, nil
+}
+
+func (r *RegistryResolver) call(ctx context.Context, contractAbi abi.ABI, contract common.Address, method string, result interface{}, args ...interface{}) error {
+	data, err := contractAbi.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	output, err := r.caller.CallContract(ctx, geth.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	// Resolvers which don't implement a method (or addresses without code) return nothing
+	if len(output) == 0 {
+		return nil
+	}
+	return contractAbi.UnpackIntoInterface(result, method, output)
+}
+
+// EIP-137 namehash of a normalized name
+func Namehash(name string) common.Hash {
+	node := common.Hash{}
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		node = crypto.Keccak256Hash(node.Bytes(), crypto.Keccak256([]byte(labels[i])))
+	}
+	return node
+}
+
+// Lowercases a name and checks its shape. This is a subset of ENSIP-15 normalization: names with characters
+// outside of ASCII letters, digits, hyphens and underscores are rejected rather than normalized.
+func Normalize(name string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if !strings.Contains(normalized, ".") {
+		return "", ErrInvalidName
+	}
+	for _, label := range strings.Split(normalized, ".") {
+		if label == "" {
+			return "", ErrInvalidName
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", errors.Wrapf(ErrInvalidName, "unsupported character %q", c)
+			}
+		}
+	}
+	return normalized, nil
+}
+
+// Returns true if s looks like an ENS name rather than an address
+func IsName(s string) bool {
+	return strings.Contains(s, ".") && !strings.HasPrefix(s, "0x")
+}
+
+// Caches reverse lookups (names and misses) for a while. Forward resolutions aren't cached: they decide where funds go.
+type CachedResolver struct {
+	Resolver
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[common.Address]cachedName
+}
+
+type cachedName struct {
+	name      string
+	expiresAt time.Time
+}
+
+func NewCachedResolver(resolver Resolver, ttl time.Duration) *CachedResolver {
+	return &CachedResolver{
+		Resolver: resolver,
+		ttl:      ttl,
+		entries:  map[common.Address]cachedName{},
+	}
+}
+
+func (c *CachedResolver) LookupAddress(ctx context.Context, address common.Address) (string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[address]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.name, nil
+	}
+
+	name, err := c.Resolver.LookupAddress(ctx, address)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for a, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, a)
+		}
+	}
+	c.entries[address] = cachedName{name: name, expiresAt: now.Add(c.ttl)}
+	return name, nil
+}
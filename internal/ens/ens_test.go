@@ -0,0 +1,176 @@
+package ens
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Runtime code of a contract answering calls with canned responses: the response to a call is stored at
+// keccak256(calldata) (its length) and the following slots (its 32-byte words). Unknown calls return nothing.
+// This stands in for both the ENS registry and a resolver, without needing a Solidity compiler.
+var cannedResponsesCode = []byte{
+	byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.CALLDATACOPY),
+	byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0, byte(vm.KECCAK256), // key
+	byte(vm.DUP1), byte(vm.SLOAD), // length, key
+	byte(vm.PUSH1), 0, // i, length, key
+	// loop (offset 14): copy word i to memory while i*32 < length
+	byte(vm.JUMPDEST),
+	byte(vm.DUP2), byte(vm.DUP2), byte(vm.PUSH1), 32, byte(vm.MUL), byte(vm.LT), byte(vm.ISZERO),
+	byte(vm.PUSH1), 43, byte(vm.JUMPI),
+	byte(vm.DUP1), byte(vm.DUP4), byte(vm.ADD), byte(vm.PUSH1), 1, byte(vm.ADD), byte(vm.SLOAD), // word, i, length, key
+	byte(vm.DUP2), byte(vm.PUSH1), 32, byte(vm.MUL), byte(vm.MSTORE),
+	byte(vm.PUSH1), 1, byte(vm.ADD),
+	byte(vm.PUSH1), 14, byte(vm.JUMP),
+	// done (offset 43): return the response
+	byte(vm.JUMPDEST),
+	byte(vm.POP), byte(vm.PUSH1), 0, byte(vm.RETURN),
+}
+
+var registry = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+var publicResolver = common.HexToAddress("0x0000000000000000000000000000000000001234")
+
+var alice = common.HexToAddress("0x00000000000000000000000000000000000a11ce")
+var mallory = common.HexToAddress("0x000000000000000000000000000000000000bad0")
+var nobody = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+
+// Canned responses of a contract, keyed by method and arguments
+type cannedCall struct {
+	method string
+	args   []interface{}
+	result interface{}
+}
+
+func cannedStorage(t *testing.T, contractAbi string, calls []cannedCall) map[common.Hash]common.Hash {
+	parsed, err := abi.JSON(strings.NewReader(contractAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := map[common.Hash]common.Hash{}
+	for _, call := range calls {
+		calldata, err := parsed.Pack(call.method, call.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := parsed.Methods[call.method].Outputs.Pack(call.result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := new(big.Int).SetBytes(crypto.Keccak256(calldata))
+		storage[common.BigToHash(key)] = common.BigToHash(big.NewInt(int64(len(response))))
+		for i := 0; i*32 < len(response); i++ {
+			slot := new(big.Int).Add(key, big.NewInt(int64(i+1)))
+			storage[common.BigToHash(slot)] = common.BytesToHash(response[i*32 : (i+1)*32])
+		}
+	}
+	return storage
+}
+
+func reverseNode(address common.Address) common.Hash {
+	return Namehash(strings.ToLower(address.Hex()[2:]) + ".addr.reverse")
+}
+
+func newTestResolver(t *testing.T) *RegistryResolver {
+	registryStorage := cannedStorage(t, registryAbi, []cannedCall{
+		{"resolver", []interface{}{Namehash("alice.eth")}, publicResolver},
+		{"resolver", []interface{}{reverseNode(alice)}, publicResolver},
+		{"resolver", []interface{}{reverseNode(mallory)}, publicResolver},
+	})
+	resolverStorage := cannedStorage(t, resolverAbi, []cannedCall{
+		{"addr", []interface{}{Namehash("alice.eth")}, alice},
+		{"name", []interface{}{reverseNode(alice)}, "alice.eth"},
+		// Anyone can set their reverse record to any name
+		{"name", []interface{}{reverseNode(mallory)}, "alice.eth"},
+	})
+
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		registry:       {Code: cannedResponsesCode, Storage: registryStorage, Balance: big.NewInt(0)},
+		publicResolver: {Code: cannedResponsesCode, Storage: resolverStorage, Balance: big.NewInt(0)},
+	}, 30_000_000)
+	t.Cleanup(func() { backend.Close() })
+
+	resolver, err := NewRegistryResolver(backend, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resolver
+}
+
+func TestResolve(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	tests := []struct {
+		name    string
+		address common.Address
+		err     error
+	}{
+		{"alice.eth", alice, nil},
+		{" Alice.ETH ", alice, nil},
+		{"unknown.eth", common.Address{}, ErrNameNotFound},
+		{"eth", common.Address{}, ErrInvalidName},
+		{"alice..eth", common.Address{}, ErrInvalidName},
+		{"alice$.eth", common.Address{}, ErrInvalidName},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, err := resolver.Resolve(context.Background(), test.name)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Resolve() error = %v, expected %v", err, test.err)
+			}
+			if address != test.address {
+				t.Errorf("Resolve() = %s, expected %s", address.Hex(), test.address.Hex())
+			}
+		})
+	}
+}
+
+func TestLookupAddress(t *testing.T) {
+	resolver := newTestResolver(t)
+
+	tests := []struct {
+		name    string
+		address common.Address
+		ensName string
+	}{
+		{"primary name", alice, "alice.eth"},
+		{"name which doesn't resolve back", mallory, ""},
+		{"no reverse record", nobody, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ensName, err := resolver.LookupAddress(context.Background(), test.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ensName != test.ensName {
+				t.Errorf("LookupAddress() = %q, expected %q", ensName, test.ensName)
+			}
+		})
+	}
+}
+
+func TestNamehash(t *testing.T) {
+	// Test vectors from EIP-137
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"", "0x0000000000000000000000000000000000000000000000000000000000000000"},
+		{"eth", "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae"},
+		{"foo.eth", "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+	}
+	for _, test := range tests {
+		if hash := Namehash(test.name); hash.Hex() != test.hash {
+			t.Errorf("Namehash(%q) = %s, expected %s", test.name, hash.Hex(), test.hash)
+		}
+	}
+}
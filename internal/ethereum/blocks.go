@@ -0,0 +1,207 @@
+package ethereum
+
+import (
+	"context"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const DEFAULT_BLOCK_POLL_INTERVAL = 12 * time.Second
+
+// When we fall behind (RPC outage, missed websocket notifications) we only catch up on this many blocks.
+// Older blocks are skipped: subscribers are meant for live updates, not backfills.
+const MAX_CATCH_UP_BLOCKS = 10
+
+// When the websocket subscription drops, we poll while waiting to resubscribe. The delay doubles after every failed
+// attempt, up to the maximum, and starts over once a subscription held for that long.
+const MIN_RESUBSCRIBE_DELAY = time.Second
+const MAX_RESUBSCRIBE_DELAY = 5 * time.Minute
+
+var Blocks *BlockWatcher
+
+// BlockWatcher follows the chain head and fans out new blocks to subscribers.
+// It uses a websocket subscription when a websocket endpoint is configured (polling while it's down), and polls otherwise.
+type BlockWatcher struct {
+	mu          sync.Mutex
+	subscribers map[int]chan *types.Block
+	nextId      int
+	lastBlock   uint64
+}
+
+// A transfer of ETH found in a block
+type BlockTransfer struct {
+	Hash        string
+	Source      string
+	Destination string
+	Amount      *big.Int
+	Block       uint64
+}
+
+// Starts watching new blocks in the background. Must be called after Init.
+func StartBlockWatcher(pollInterval time.Duration) {
+	if pollInterval <= 0 {
+		pollInterval = DEFAULT_BLOCK_POLL_INTERVAL
+	}
+	Blocks = &BlockWatcher{
+		subscribers: map[int]chan *types.Block{},
+	}
+	go Blocks.run(pollInterval)
+}
+
+// Returns a channel receiving every new block, and a function to call when done with it.
+// Slow subscribers miss blocks rather than holding up everyone else.
+func (w *BlockWatcher) Subscribe() (<-chan *types.Block, func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	id := w.nextId
+	w.nextId++
+	ch := make(chan *types.Block, 16)
+	w.subscribers[id] = ch
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if _, ok := w.subscribers[id]; ok {
+			delete(w.subscribers, id)
+			close(ch)
+		}
+	}
+}
+
+func (w *BlockWatcher) run(pollInterval time.Duration) {
+	delay := MIN_RESUBSCRIBE_DELAY
+	for {
+		subscribedAt := time.Now()
+		err := w.followSubscription()
+		if err == ErrNoWebsocketEndpoint {
+			log.Printf("no websocket endpoint configured, polling for new blocks every %s", pollInterval)
+			w.poll(pollInterval, 0)
+			return
+		}
+		// Subscriptions which are closed without an error (e.g. unsubscribed by the node) end with nil
+		reason := "closed"
+		if err != nil {
+			reason = err.Error()
+		}
+		if time.Since(subscribedAt) >= MAX_RESUBSCRIBE_DELAY {
+			delay = MIN_RESUBSCRIBE_DELAY
+		}
+		log.Printf("new block subscription ended (%s), polling every %s and resubscribing in %s", reason, pollInterval, delay)
+		w.poll(pollInterval, delay)
+
+		delay *= 2
+		if delay > MAX_RESUBSCRIBE_DELAY {
+			delay = MAX_RESUBSCRIBE_DELAY
+		}
+	}
+}
+
+// Polls for new blocks right away then every interval, for the given duration (forever if zero)
+func (w *BlockWatcher) poll(interval time.Duration, duration time.Duration) {
+	var done <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		done = timer.C
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		latest, err := Client.BlockNumber(context.Background())
+		if err != nil {
+			log.Printf("error while polling for new blocks: %s", err.Error())
+		} else {
+			w.catchUp(latest)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Follows new heads through a websocket subscription. Only returns when the subscription can't be (or stops being) used.
+// The error is nil if the subscription was closed without one.
+func (w *BlockWatcher) followSubscription() error {
+	headers := make(chan *types.Header, 16)
+	subscription, err := Client.SubscribeNewHead(context.Background(), headers)
+	if err != nil {
+		return err
+	}
+	defer subscription.Unsubscribe()
+	log.Println("subscribed to new blocks over websocket")
+
+	for {
+		select {
+		case err := <-subscription.Err():
+			return err
+		case header := <-headers:
+			w.catchUp(header.Number.Uint64())
+		}
+	}
+}
+
+// Fetches and publishes every block between the last published one and `latest`
+func (w *BlockWatcher) catchUp(latest uint64) {
+	// Failover endpoints can lag behind the one we last heard from: never go back to blocks we already published
+	if w.lastBlock != 0 && latest <= w.lastBlock {
+		return
+	}
+	if w.lastBlock == 0 || latest-w.lastBlock > MAX_CATCH_UP_BLOCKS {
+		w.lastBlock = latest - 1
+	}
+
+	for number := w.lastBlock + 1; number <= latest; number++ {
+		block, err := Client.BlockByNumber(context.Background(), new(big.Int).SetUint64(number))
+		if err != nil {
+			log.Printf("unable to fetch block %d: %s", number, err.Error())
+			return
+		}
+		w.publish(block)
+		w.lastBlock = number
+	}
+}
+
+func (w *BlockWatcher) publish(block *types.Block) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, ch := range w.subscribers {
+		select {
+		case ch <- block:
+		default:
+			log.Printf("block subscriber %d is lagging behind, dropping block %d", id, block.NumberU64())
+		}
+	}
+}
+
+// Returns all plain ETH transfers (non-zero value) contained in a block.
+func ExternalTransfers(block *types.Block) []BlockTransfer {
+	var transfers []BlockTransfer
+	for _, tx := range block.Transactions() {
+		if tx.To() == nil || tx.Value().Sign() == 0 {
+			continue
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil {
+			log.Printf("unable to recover sender for transaction %s: %s", tx.Hash().Hex(), err.Error())
+			continue
+		}
+		transfers = append(transfers, BlockTransfer{
+			Hash:        tx.Hash().Hex(),
+			Source:      strings.ToLower(sender.Hex()),
+			Destination: strings.ToLower(tx.To().Hex()),
+			Amount:      tx.Value(),
+			Block:       block.NumberU64(),
+		})
+	}
+	return transfers
+}
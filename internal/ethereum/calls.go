@@ -0,0 +1,344 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Calls are estimated, then given this much extra gas (in percent): state can change between estimation and inclusion
+const GAS_ESTIMATE_MARGIN_PERCENT = 20
+
+// Human-readable description of a contract call, so users see which function they're about to sign
+type CallSummary struct {
+	// 0x-prefixed 4-byte function selector
+	Selector string `json:"selector"`
+	// e.g. "transfer(address,uint256)". Empty when the function is unknown.
+	Signature string         `json:"signature"`
+	Arguments []CallArgument `json:"arguments"`
+}
+
+type CallArgument struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// JSON-friendly value: numbers are decimal strings, bytes and addresses are 0x-prefixed hex
+	Value interface{} `json:"value"`
+}
+
+// Functions we can describe without being given an ABI: the most common token operations
+const knownFunctionsAbi = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"transferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"amount","type":"uint256"}]},
+	{"type":"function","name":"safeTransferFrom","inputs":[{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"tokenId","type":"uint256"}]},
+	{"type":"function","name":"setApprovalForAll","inputs":[{"name":"operator","type":"address"},{"name":"approved","type":"bool"}]}
+]`
+
+var knownFunctions = mustParseAbi(knownFunctionsAbi)
+
+// Parses an ABI fragment: either a JSON array of ABI entries, or a single entry (e.g. one function)
+func ParseAbiFragment(fragment json.RawMessage) (*abi.ABI, error) {
+	trimmed := bytes.TrimSpace(fragment)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		trimmed = append(append([]byte{'['}, trimmed...), ']')
+	}
+	parsed, err := abi.JSON(bytes.NewReader(trimmed))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ABI")
+	}
+	return &parsed, nil
+}
+
+// Picks the function to call in an ABI. The name can be omitted when the ABI has a single function.
+func FindAbiMethod(contractAbi *abi.ABI, name string) (*abi.Method, error) {
+	if name == "" {
+		if len(contractAbi.Methods) != 1 {
+			return nil, fmt.Errorf("the ABI has %d functions: specify which one to call", len(contractAbi.Methods))
+		}
+		for _, method := range contractAbi.Methods {
+			return &method, nil
+		}
+	}
+	method, ok := contractAbi.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("function %q isn't in the ABI", name)
+	}
+	return &method, nil
+}
+
+// ABI-encodes a call to method. Arguments are JSON values: numbers (or decimal/0x-hex strings) for integers,
+// hex strings for addresses and bytes, arrays for arrays, and arrays or objects for tuples.
+func EncodeCall(method *abi.Method, args []json.RawMessage) ([]byte, error) {
+	if len(args) != len(method.Inputs) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", method.Sig, len(method.Inputs), len(args))
+	}
+	values := make([]interface{}, len(args))
+	for i, input := range method.Inputs {
+		value, err := convertArgument(input.Type, args[i])
+		if err != nil {
+			return nil, errors.Wrapf(err, "argument %d (%s)", i, argumentName(input, i))
+		}
+		values[i] = value
+	}
+	encoded, err := method.Inputs.Pack(values...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to encode call to %s", method.Sig)
+	}
+	return append(common.CopyBytes(method.ID), encoded...), nil
+}
+
+// Describes calldata. The function is looked up in contractAbi if given, then among well-known token functions.
+// Calldata for unknown functions is summarized by its selector only.
+func DecodeCall(contractAbi *abi.ABI, data []byte) (*CallSummary, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("calldata must start with a 4-byte function selector")
+	}
+	summary := CallSummary{
+		Selector:  "0x" + hex.EncodeToString(data[:4]),
+		Arguments: []CallArgument{},
+	}
+
+	var method *abi.Method
+	for _, candidate := range []*abi.ABI{contractAbi, knownFunctions} {
+		if candidate == nil {
+			continue
+		}
+		if m, err := candidate.MethodById(data[:4]); err == nil {
+			method = m
+			break
+		}
+	}
+	if method == nil {
+		if contractAbi != nil {
+			return nil, fmt.Errorf("selector %s doesn't match any function in the ABI", summary.Selector)
+		}
+		return &summary, nil
+	}
+
+	values, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "calldata doesn't match %s", method.Sig)
+	}
+	summary.Signature = method.Sig
+	for i, input := range method.Inputs {
+		summary.Arguments = append(summary.Arguments, CallArgument{
+			Name:  argumentName(input, i),
+			Type:  input.Type.String(),
+			Value: formatArgument(input.Type, reflect.ValueOf(values[i])),
+		})
+	}
+	return &summary, nil
+}
+
+// Converts a JSON value to the Go type go-ethereum expects when packing an argument of type t
+func convertArgument(t abi.Type, raw json.RawMessage) (interface{}, error) {
+	value, err := convertArgumentValue(t, raw)
+	if err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+func convertArgumentValue(t abi.Type, raw json.RawMessage) (reflect.Value, error) {
+	goType := t.GetType()
+	switch t.T {
+	case abi.BoolTy:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a boolean")
+		}
+		return reflect.ValueOf(b), nil
+	case abi.StringTy:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a string")
+		}
+		return reflect.ValueOf(s), nil
+	case abi.AddressTy:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return reflect.Value{}, fmt.Errorf("expected a hex address")
+		}
+		address, err := ParseAddress(s)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(address), nil
+	case abi.IntTy, abi.UintTy:
+		n, err := parseInteger(raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if t.T == abi.UintTy && n.Sign() < 0 {
+			return reflect.Value{}, fmt.Errorf("expected an unsigned integer")
+		}
+		limit := t.Size
+		if t.T == abi.IntTy {
+			limit--
+		}
+		if n.BitLen() > limit {
+			return reflect.Value{}, fmt.Errorf("%s overflows %s", n.String(), t.String())
+		}
+		// go-ethereum packs integers of up to 64 bits from the matching Go type, and larger ones from *big.Int
+		if goType == reflect.TypeOf(&big.Int{}) {
+			return reflect.ValueOf(n), nil
+		}
+		value := reflect.New(goType).Elem()
+		if t.T == abi.IntTy {
+			value.SetInt(n.Int64())
+		} else {
+			value.SetUint(n.Uint64())
+		}
+		return value, nil
+	case abi.BytesTy, abi.FixedBytesTy:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil || !strings.HasPrefix(s, "0x") {
+			return reflect.Value{}, fmt.Errorf("expected 0x-prefixed hex bytes")
+		}
+		b, err := hex.DecodeString(s[2:])
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("expected 0x-prefixed hex bytes")
+		}
+		if t.T == abi.BytesTy {
+			return reflect.ValueOf(b), nil
+		}
+		if len(b) != t.Size {
+			return reflect.Value{}, fmt.Errorf("expected %d bytes, got %d", t.Size, len(b))
+		}
+		value := reflect.New(goType).Elem()
+		reflect.Copy(value, reflect.ValueOf(b))
+		return value, nil
+	case abi.SliceTy, abi.ArrayTy:
+		var elements []json.RawMessage
+		if err := json.Unmarshal(raw, &elements); err != nil {
+			return reflect.Value{}, fmt.Errorf("expected an array")
+		}
+		var value reflect.Value
+		if t.T == abi.SliceTy {
+			value = reflect.MakeSlice(goType, len(elements), len(elements))
+		} else {
+			if len(elements) != t.Size {
+				return reflect.Value{}, fmt.Errorf("expected %d elements, got %d", t.Size, len(elements))
+			}
+			value = reflect.New(goType).Elem()
+		}
+		for i, element := range elements {
+			elementValue, err := convertArgumentValue(*t.Elem, element)
+			if err != nil {
+				return reflect.Value{}, errors.Wrapf(err, "element %d", i)
+			}
+			value.Index(i).Set(elementValue)
+		}
+		return value, nil
+	case abi.TupleTy:
+		components, err := tupleComponents(t, raw)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		value := reflect.New(goType).Elem()
+		for i, elem := range t.TupleElems {
+			fieldValue, err := convertArgumentValue(*elem, components[i])
+			if err != nil {
+				return reflect.Value{}, errors.Wrapf(err, "component %s", t.TupleRawNames[i])
+			}
+			value.Field(i).Set(fieldValue)
+		}
+		return value, nil
+	}
+	return reflect.Value{}, fmt.Errorf("unsupported argument type %s", t.String())
+}
+
+// Tuples are given either as arrays of components (in order), or as objects keyed by component name
+func tupleComponents(t abi.Type, raw json.RawMessage) ([]json.RawMessage, error) {
+	var components []json.RawMessage
+	if err := json.Unmarshal(raw, &components); err == nil {
+		if len(components) != len(t.TupleElems) {
+			return nil, fmt.Errorf("expected %d components, got %d", len(t.TupleElems), len(components))
+		}
+		return components, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, fmt.Errorf("expected an array or object")
+	}
+	for _, name := range t.TupleRawNames {
+		component, ok := named[name]
+		if !ok {
+			return nil, fmt.Errorf("missing component %q", name)
+		}
+		components = append(components, component)
+	}
+	return components, nil
+}
+
+// Integers are JSON numbers, or strings (decimal, or 0x-prefixed hex) for values too large for JSON numbers
+func parseInteger(raw json.RawMessage) (*big.Int, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return nil, fmt.Errorf("expected an integer")
+		}
+		s = number.String()
+	}
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("expected an integer, got %q", s)
+	}
+	return n, nil
+}
+
+// Formats a decoded argument as a JSON-friendly value
+func formatArgument(t abi.Type, value reflect.Value) interface{} {
+	switch t.T {
+	case abi.AddressTy:
+		return value.Interface().(common.Address).Hex()
+	case abi.IntTy, abi.UintTy:
+		if n, ok := value.Interface().(*big.Int); ok {
+			return n.String()
+		}
+		return fmt.Sprintf("%d", value.Interface())
+	case abi.BytesTy:
+		return "0x" + hex.EncodeToString(value.Bytes())
+	case abi.FixedBytesTy:
+		b := make([]byte, value.Len())
+		reflect.Copy(reflect.ValueOf(b), value)
+		return "0x" + hex.EncodeToString(b)
+	case abi.SliceTy, abi.ArrayTy:
+		elements := make([]interface{}, value.Len())
+		for i := range elements {
+			elements[i] = formatArgument(*t.Elem, value.Index(i))
+		}
+		return elements
+	case abi.TupleTy:
+		components := map[string]interface{}{}
+		for i, elem := range t.TupleElems {
+			components[t.TupleRawNames[i]] = formatArgument(*elem, value.Field(i))
+		}
+		return components
+	}
+	return value.Interface()
+}
+
+func argumentName(argument abi.Argument, index int) string {
+	if argument.Name != "" {
+		return argument.Name
+	}
+	return fmt.Sprintf("arg%d", index)
+}
+
+func mustParseAbi(definition string) *abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return &parsed
+}
@@ -6,65 +6,119 @@ import (
 	"fmt"
 	"math/big"
 	"os"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
+	geth "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
-var Client *ethclient.Client
+var Client *RpcClient
 
-func Init() {
-	var err error
-	infuraApiKey := os.Getenv("INFURA_API_KEY")
-	infuraUrl := fmt.Sprintf("https://sepolia.infura.io/v3/%s", infuraApiKey)
+// Wallets live on Sepolia
+var ChainID = params.SepoliaChainConfig.ChainID
 
-	Client, err = ethclient.Dial(infuraUrl)
-	if err != nil {
-		panic(err)
+// Plain ETH transfers always use exactly this much gas
+const TRANSFER_GAS_LIMIT = uint64(21000)
+
+var ErrInvalidAddress = errors.New("invalid address: expected 0x followed by 40 hex characters")
+var ErrInvalidAddressChecksum = errors.New("invalid address checksum (EIP-55): check the address for typos")
+
+// Returned by ConstructSweep when the balance doesn't cover transaction fees
+var ErrNothingToSweep = errors.New("balance is too low to cover transaction fees")
+
+// Connects to the configured RPC endpoints.
+// ETHEREUM_RPC_URLS is a comma-separated list of endpoints, primary first. When it isn't set
+// we fall back to a single Infura endpoint built from INFURA_API_KEY.
+func Init() error {
+	var rpcUrls []string
+	if configuredUrls := os.Getenv("ETHEREUM_RPC_URLS"); configuredUrls != "" {
+		rpcUrls = strings.Split(configuredUrls, ",")
 	} else {
-		fmt.Println("Successfully connected to Infura")
+		infuraApiKey := os.Getenv("INFURA_API_KEY")
+		rpcUrls = []string{fmt.Sprintf("https://sepolia.infura.io/v3/%s", infuraApiKey)}
+	}
+
+	callTimeout := DEFAULT_RPC_CALL_TIMEOUT
+	if configuredTimeout := os.Getenv("ETHEREUM_RPC_TIMEOUT"); configuredTimeout != "" {
+		var err error
+		callTimeout, err = time.ParseDuration(configuredTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "cannot parse ETHEREUM_RPC_TIMEOUT (%q)", configuredTimeout)
+		}
 	}
+
+	var err error
+	Client, err = NewRpcClient(rpcUrls, callTimeout)
+	if err != nil {
+		return err
+	}
+	Client.StartHealthChecks(DEFAULT_RPC_HEALTH_CHECK_INTERVAL)
+
+	fmt.Printf("Successfully connected to %d RPC endpoint(s)\n", len(Client.endpoints))
+	return nil
 }
 
-func GetBalance(addressString string) (*big.Int, error) {
-	address := parseAddress(addressString)
-	ctx := context.Background()
+func GetBalance(ctx context.Context, addressString string) (*big.Int, error) {
+	address, err := ParseAddress(addressString)
+	if err != nil {
+		return nil, err
+	}
 	balance, err := Client.BalanceAt(ctx, address, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "error while fetching balance from Infura")
+		return nil, errors.Wrap(err, "error while fetching balance")
 	}
 	return balance, nil
 }
 
-func ConstructTransfer(from string, to string, amount *big.Int, nonce *uint64) ([]byte, error) {
-	ctx := context.Background()
-	fromAddress := parseAddress(from)
-	toAddress := parseAddress(to)
-
-	// Additional context on gas parameters can be found here:
-	// https://github.com/ethereum/pm/issues/328#issuecomment-853612573
-	gasPrice, err := Client.SuggestGasPrice(ctx)
+func ConstructTransfer(ctx context.Context, from string, to string, amount *big.Int, nonce *uint64) ([]byte, error) {
+	gasFeeCap, gasTipCap, err := suggestFees(ctx)
 	if err != nil {
-		return []byte{}, errors.Wrapf(err, "cannot fetch suggested gas price")
+		return nil, err
 	}
+	return constructTransaction(ctx, from, to, amount, []byte{}, TRANSFER_GAS_LIMIT, gasFeeCap, gasTipCap, nonce)
+}
 
-	gasTipCap, err := Client.SuggestGasTipCap(ctx)
+// Constructs a contract call (or a transfer with calldata). Gas is estimated, with GAS_ESTIMATE_MARGIN_PERCENT on top.
+// Calls which would revert fail estimation: check IsExecutionError. Returns the unsigned transaction and its gas limit.
+// The nonce defaults to the next one of the sender.
+func ConstructContractCall(ctx context.Context, from string, to string, value *big.Int, data []byte, nonce *uint64) ([]byte, uint64, error) {
+	fromAddress, toAddress, err := parseAddresses(from, to)
 	if err != nil {
-		return []byte{}, errors.Wrapf(err, "cannot fetch suggested gas tip cap")
+		return nil, 0, err
 	}
+	estimate, err := Client.EstimateGas(ctx, geth.CallMsg{
+		From:  fromAddress,
+		To:    &toAddress,
+		Value: value,
+		Data:  data,
+	})
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to estimate gas")
+	}
+	gasLimit := estimate + estimate*GAS_ESTIMATE_MARGIN_PERCENT/100
 
-	// Double both the gas price and tip for timely execution
-	multipliedGasPrice := new(big.Int).Mul(gasPrice, big.NewInt(2))
-	multipliedGasTip := new(big.Int).Mul(gasTipCap, big.NewInt(2))
+	gasFeeCap, gasTipCap, err := suggestFees(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	unsignedTransaction, err := constructTransaction(ctx, from, to, value, data, gasLimit, gasFeeCap, gasTipCap, nonce)
+	if err != nil {
+		return nil, 0, err
+	}
+	return unsignedTransaction, gasLimit, nil
+}
 
-	// Ensure gas price >= gas tip
-	if multipliedGasTip.Cmp(multipliedGasPrice) == 1 {
-		multipliedGasPrice = multipliedGasTip
+func constructTransaction(ctx context.Context, from string, to string, value *big.Int, data []byte, gasLimit uint64, gasFeeCap, gasTipCap *big.Int, nonce *uint64) ([]byte, error) {
+	fromAddress, toAddress, err := parseAddresses(from, to)
+	if err != nil {
+		return []byte{}, err
 	}
 
 	var suggestedNonce uint64
@@ -77,45 +131,238 @@ func ConstructTransfer(from string, to string, amount *big.Int, nonce *uint64) (
 		}
 	}
 
-	gasLimit := uint64(21000)
-
 	return messageToSign(types.NewTx(&types.DynamicFeeTx{
-		ChainID:   params.SepoliaChainConfig.ChainID,
+		ChainID:   ChainID,
 		Nonce:     suggestedNonce,
-		GasFeeCap: multipliedGasPrice,
-		GasTipCap: multipliedGasTip,
+		GasFeeCap: gasFeeCap,
+		GasTipCap: gasTipCap,
 		Gas:       gasLimit,
 		To:        &toAddress,
-		Value:     amount,
-		Data:      []byte{},
+		Value:     value,
+		Data:      data,
 	})), nil
 }
 
+// Constructs a transfer of the entire balance of an address, minus the maximum fee the transfer can cost.
+// Any fee not spent (the base fee is usually lower than our fee cap) stays behind as dust.
+// Returns the unsigned transaction along with the amount transferred.
+func ConstructSweep(ctx context.Context, from string, to string) ([]byte, *big.Int, error) {
+	balance, err := GetBalance(ctx, from)
+	if err != nil {
+		return nil, nil, err
+	}
+	gasFeeCap, gasTipCap, err := suggestFees(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The transaction must be built with the fee cap used to size it, or the balance won't cover the max fee
+	maxFee := new(big.Int).Mul(gasFeeCap, new(big.Int).SetUint64(TRANSFER_GAS_LIMIT))
+	amount := new(big.Int).Sub(balance, maxFee)
+	if amount.Sign() <= 0 {
+		return nil, nil, ErrNothingToSweep
+	}
+
+	unsignedTransaction, err := constructTransaction(ctx, from, to, amount, []byte{}, TRANSFER_GAS_LIMIT, gasFeeCap, gasTipCap, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return unsignedTransaction, amount, nil
+}
+
+// Returns the fee cap and tip for new transactions.
+// Additional context on gas parameters can be found here:
+// https://github.com/ethereum/pm/issues/328#issuecomment-853612573
+func suggestFees(ctx context.Context) (*big.Int, *big.Int, error) {
+	gasPrice, err := Client.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot fetch suggested gas price")
+	}
+
+	gasTipCap, err := Client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "cannot fetch suggested gas tip cap")
+	}
+
+	// Double both the gas price and tip for timely execution
+	multipliedGasPrice := new(big.Int).Mul(gasPrice, big.NewInt(2))
+	multipliedGasTip := new(big.Int).Mul(gasTipCap, big.NewInt(2))
+
+	// Ensure gas price >= gas tip
+	if multipliedGasTip.Cmp(multipliedGasPrice) == 1 {
+		multipliedGasPrice = multipliedGasTip
+	}
+	return multipliedGasPrice, multipliedGasTip, nil
+}
+
 // Broadcasts a signed transaction and returns the transaction hash.
 // (or an error if something goes awry)
 // This function expects a hex-encoded string as input.
-func BroadcastTransaction(signedTx string) (string, error) {
+// Re-broadcasting a transaction which is already known to the network isn't an error.
+func BroadcastTransaction(ctx context.Context, signedTx string) (string, error) {
+	tx, err := DecodeSignedTransaction(signedTx)
+	if err != nil {
+		return "", err
+	}
+
+	err = Client.SendTransaction(ctx, tx)
+	if err != nil {
+		return "", errors.Wrap(err, "error while broadcasting transaction")
+	}
+
+	return tx.Hash().Hex(), nil
+}
+
+// Parses a hex-encoded signed transaction
+func DecodeSignedTransaction(signedTx string) (*types.Transaction, error) {
 	signedTxBytes, err := hex.DecodeString(signedTx)
 	if err != nil {
-		return "", errors.Wrapf(err, "cannot decode signed tx %s", signedTx)
+		return nil, errors.Wrapf(err, "cannot decode signed tx %s", signedTx)
 	}
 
 	tx := new(types.Transaction)
 	err = tx.UnmarshalBinary(signedTxBytes)
 	if err != nil {
-		return "", errors.Wrap(err, "cannot parse signed transaction bytes")
+		return nil, errors.Wrap(err, "cannot parse signed transaction bytes")
 	}
+	return tx, nil
+}
+
+// RLP fields of an unsigned EIP-1559 transaction, as serialized by messageToSign
+type unsignedDynamicFeeTx struct {
+	ChainID    *big.Int
+	Nonce      uint64
+	GasTipCap  *big.Int
+	GasFeeCap  *big.Int
+	Gas        uint64
+	To         *common.Address `rlp:"nil"`
+	Value      *big.Int
+	Data       []byte
+	AccessList types.AccessList
+}
 
-	err = Client.SendTransaction(context.Background(), tx)
+// RLP fields of an unsigned legacy transaction. EIP-155 transactions add the chain ID and two zeroes.
+type unsignedLegacyTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       *common.Address `rlp:"nil"`
+	Value    *big.Int
+	Data     []byte
+	ChainID  *big.Int `rlp:"optional"`
+	Zero1    uint     `rlp:"optional"`
+	Zero2    uint     `rlp:"optional"`
+}
+
+// Parses a hex-encoded unsigned transaction: EIP-1559 (as built by construct-tx) or legacy
+func DecodeUnsignedTransaction(unsignedTx string) (*types.Transaction, error) {
+	unsignedTxBytes, err := hex.DecodeString(strings.TrimPrefix(unsignedTx, "0x"))
+	if err != nil || len(unsignedTxBytes) == 0 {
+		return nil, fmt.Errorf("cannot decode unsigned tx %q", unsignedTx)
+	}
+
+	// Typed transactions start with their type, RLP lists with a byte >= 0xc0
+	switch {
+	case unsignedTxBytes[0] == types.DynamicFeeTxType:
+		var fields unsignedDynamicFeeTx
+		if err := rlp.DecodeBytes(unsignedTxBytes[1:], &fields); err != nil {
+			return nil, errors.Wrap(err, "cannot parse unsigned EIP-1559 transaction")
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    fields.ChainID,
+			Nonce:      fields.Nonce,
+			GasTipCap:  fields.GasTipCap,
+			GasFeeCap:  fields.GasFeeCap,
+			Gas:        fields.Gas,
+			To:         fields.To,
+			Value:      fields.Value,
+			Data:       fields.Data,
+			AccessList: fields.AccessList,
+		}), nil
+	case unsignedTxBytes[0] >= 0xc0:
+		var fields unsignedLegacyTx
+		if err := rlp.DecodeBytes(unsignedTxBytes, &fields); err != nil {
+			return nil, errors.Wrap(err, "cannot parse unsigned legacy transaction")
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    fields.Nonce,
+			GasPrice: fields.GasPrice,
+			Gas:      fields.Gas,
+			To:       fields.To,
+			Value:    fields.Value,
+			Data:     fields.Data,
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported transaction type %d", unsignedTxBytes[0])
+}
+
+// Returns the receipt for a transaction hash, or nil if the transaction isn't mined yet.
+func GetTransactionReceipt(ctx context.Context, hash string) (*types.Receipt, error) {
+	receipt, err := Client.TransactionReceipt(ctx, common.HexToHash(hash))
+	if errors.Is(err, geth.NotFound) {
+		return nil, nil
+	}
 	if err != nil {
-		return "", errors.Wrap(err, "error while broadcasting transaction")
+		return nil, errors.Wrapf(err, "error while fetching receipt for transaction %s", hash)
 	}
+	return receipt, nil
+}
 
-	return tx.Hash().Hex(), nil
+// Parses an exact decimal amount of ETH (e.g. "0.25") into wei
+func ParseEth(amount string) (*big.Int, error) {
+	return ParseUnits(amount, 18)
+}
+
+// Amounts are plain decimals: no sign, exponent, fraction, base prefix ("0x10") or digit separator ("1_000")
+var decimalAmount = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// Parses an exact decimal amount (e.g. "0.25") into base units of a currency with the given number of decimals
+func ParseUnits(amount string, decimals uint8) (*big.Int, error) {
+	if !decimalAmount.MatchString(amount) {
+		return nil, fmt.Errorf("invalid amount %q: expected a decimal number such as 0.25", amount)
+	}
+	parsed, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	units := parsed.Mul(parsed, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	if !units.IsInt() {
+		return nil, fmt.Errorf("amount %q has more than %d decimals", amount, decimals)
+	}
+	return units.Num(), nil
 }
 
-func parseAddress(s string) common.Address {
-	return common.BytesToAddress(common.FromHex(s))
+// Transform a bigint (representing a wei amount) into a readable
+// string ("1.23") representing an amount in ETH.
+func FormatEth(amount *big.Int) string {
+	return new(big.Rat).SetFrac(amount, big.NewInt(params.Ether)).FloatString(2)
+}
+
+// Parses a 0x-prefixed hex address. Mixed-case addresses carry an EIP-55 checksum, which must be valid:
+// a typo in a checksummed address is caught rather than sending funds to the wrong address.
+// All-lowercase and all-uppercase addresses have no checksum.
+func ParseAddress(s string) (common.Address, error) {
+	if !strings.HasPrefix(s, "0x") || len(s) != 42 || !common.IsHexAddress(s) {
+		return common.Address{}, ErrInvalidAddress
+	}
+	address := common.HexToAddress(s)
+	digits := s[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && address.Hex() != s {
+		return common.Address{}, ErrInvalidAddressChecksum
+	}
+	return address, nil
+}
+
+func parseAddresses(from string, to string) (common.Address, common.Address, error) {
+	fromAddress, err := ParseAddress(from)
+	if err != nil {
+		return common.Address{}, common.Address{}, errors.Wrapf(err, "invalid source address %q", from)
+	}
+	toAddress, err := ParseAddress(to)
+	if err != nil {
+		return common.Address{}, common.Address{}, errors.Wrapf(err, "invalid destination address %q", to)
+	}
+	return fromAddress, toAddress, nil
 }
 
 // See https://github.com/ethereum/go-ethereum/issues/26199#issuecomment-1318777575
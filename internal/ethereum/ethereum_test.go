@@ -0,0 +1,188 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestParseAddress(t *testing.T) {
+	// Test vector from EIP-55
+	checksummed := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	expected := common.HexToAddress(checksummed)
+
+	tests := []struct {
+		name    string
+		address string
+		err     error
+	}{
+		{"valid checksum", checksummed, nil},
+		{"all lowercase", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", nil},
+		{"all uppercase", "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", nil},
+		{"wrong case", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", ErrInvalidAddressChecksum},
+		{"typo in checksummed address", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAee", ErrInvalidAddressChecksum},
+		{"missing 0x prefix", "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", ErrInvalidAddress},
+		{"uppercase 0X prefix", "0X5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", ErrInvalidAddress},
+		{"too short", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", ErrInvalidAddress},
+		{"too long", checksummed + "00", ErrInvalidAddress},
+		{"not hex", "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeg", ErrInvalidAddress},
+		{"empty", "", ErrInvalidAddress},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address, err := ParseAddress(test.address)
+			if err != test.err {
+				t.Fatalf("ParseAddress(%q) error = %v, expected %v", test.address, err, test.err)
+			}
+			if err == nil && address != expected {
+				t.Errorf("ParseAddress(%q) = %s, expected %s", test.address, address.Hex(), expected.Hex())
+			}
+		})
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	tests := []struct {
+		amount   string
+		decimals uint8
+		// Expected base units, "" if the amount should be rejected
+		units string
+	}{
+		{"1", 18, "1000000000000000000"},
+		{"0.25", 18, "250000000000000000"},
+		{"0.000000000000000001", 18, "1"},
+		{"0", 18, "0"},
+		{"007.5", 6, "7500000"},
+		{"12.5", 6, "12500000"},
+		{"1.0000000", 6, "1000000"},
+		{"0.0000001", 6, ""},
+		{"0.0000000000000000001", 18, ""},
+		{"1", 0, "1"},
+		{"1.5", 0, ""},
+		{"0x10", 18, ""},
+		{"0X10", 18, ""},
+		{"0b1", 18, ""},
+		{"0o7", 18, ""},
+		{"1_0", 18, ""},
+		{"1_000.5", 18, ""},
+		{"-1", 18, ""},
+		{"+1", 18, ""},
+		{"1e18", 18, ""},
+		{"1E3", 18, ""},
+		{"1/2", 18, ""},
+		{".5", 18, ""},
+		{"5.", 18, ""},
+		{" 1", 18, ""},
+		{"1,5", 18, ""},
+		{"", 18, ""},
+		{"Inf", 18, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.amount, func(t *testing.T) {
+			units, err := ParseUnits(test.amount, test.decimals)
+			if test.units == "" {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected, got %s", test.amount, units)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if units.String() != test.units {
+				t.Errorf("ParseUnits(%q, %d) = %s, expected %s", test.amount, test.decimals, units, test.units)
+			}
+		})
+	}
+}
+
+func TestParseEth(t *testing.T) {
+	wei, err := ParseEth("0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wei.String() != "50000000000000000" {
+		t.Errorf("ParseEth(\"0.05\") = %s", wei)
+	}
+	for _, amount := range []string{"0x10", "0b1", "0o7", "1_0"} {
+		if _, err := ParseEth(amount); err == nil {
+			t.Errorf("expected %q to be rejected", amount)
+		}
+	}
+}
+
+// A fake node whose gas price goes up by 1 gwei every time it's asked
+type risingGasPriceNode struct {
+	balance  *big.Int
+	gasPrice int64
+}
+
+func (n *risingGasPriceNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result interface{}
+	switch request.Method {
+	case "eth_getBalance":
+		result = (*hexutil.Big)(n.balance)
+	case "eth_gasPrice":
+		n.gasPrice += 1_000_000_000
+		result = (*hexutil.Big)(big.NewInt(n.gasPrice))
+	case "eth_maxPriorityFeePerGas":
+		result = (*hexutil.Big)(big.NewInt(1_000_000_000))
+	case "eth_getTransactionCount":
+		result = hexutil.Uint64(7)
+	default:
+		http.Error(w, "unexpected method "+request.Method, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+}
+
+func TestConstructSweep(t *testing.T) {
+	balance := big.NewInt(1_000_000_000_000_000)
+	server := httptest.NewServer(&risingGasPriceNode{balance: balance})
+	client, err := NewRpcClient([]string{server.URL}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Client
+	Client = client
+	t.Cleanup(func() {
+		Client = previous
+		server.Close()
+	})
+
+	from := "0x00000000000000000000000000000000000005ac"
+	to := "0x00000000000000000000000000000000000a11ce"
+	unsignedTransaction, amount, err := ConstructSweep(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := DecodeUnsignedTransaction(hex.EncodeToString(unsignedTransaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Value().Cmp(amount) != 0 {
+		t.Errorf("transaction value %s, expected the swept amount %s", tx.Value(), amount)
+	}
+	// The balance must cover the value and the max fee of the transaction as built
+	maxFee := new(big.Int).Mul(tx.GasFeeCap(), new(big.Int).SetUint64(tx.Gas()))
+	if total := new(big.Int).Add(tx.Value(), maxFee); total.Cmp(balance) != 0 {
+		t.Errorf("value + max fee = %s, expected the balance %s", total, balance)
+	}
+}
@@ -0,0 +1,346 @@
+package ethereum
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+const DEFAULT_RPC_CALL_TIMEOUT = 10 * time.Second
+const DEFAULT_RPC_HEALTH_CHECK_INTERVAL = 30 * time.Second
+
+// Number of attempts made for idempotent reads before giving up.
+// Each attempt targets the next endpoint in line, so with multiple endpoints
+// a failing provider is skipped over quickly.
+const MAX_READ_ATTEMPTS = 4
+const BASE_RETRY_BACKOFF = 100 * time.Millisecond
+
+var ErrNoWebsocketEndpoint = errors.New("no websocket RPC endpoint configured")
+var ErrTracingUnsupported = errors.New("no RPC endpoint supports debug_traceCall")
+
+// A single RPC provider (Infura, Alchemy, a self-hosted node, ...)
+type endpoint struct {
+	url     string
+	client  *ethclient.Client
+	healthy atomic.Bool
+}
+
+// RpcClient wraps several Ethereum RPC endpoints. Calls go to the first healthy
+// endpoint (in configuration order) and fail over to the next ones on error.
+type RpcClient struct {
+	endpoints   []*endpoint
+	callTimeout time.Duration
+}
+
+// Dials every URL passed in. Endpoints which can't be dialed are skipped (and logged),
+// but at least one of them has to succeed.
+func NewRpcClient(urls []string, callTimeout time.Duration) (*RpcClient, error) {
+	if callTimeout <= 0 {
+		callTimeout = DEFAULT_RPC_CALL_TIMEOUT
+	}
+
+	c := &RpcClient{callTimeout: callTimeout}
+	for _, url := range urls {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		client, err := ethclient.Dial(url)
+		if err != nil {
+			log.Printf("unable to dial RPC endpoint %s: %s", redactUrl(url), err.Error())
+			continue
+		}
+		e := &endpoint{url: url, client: client}
+		e.healthy.Store(true)
+		c.endpoints = append(c.endpoints, e)
+	}
+
+	if len(c.endpoints) == 0 {
+		return nil, fmt.Errorf("unable to dial any of the %d configured RPC endpoints", len(urls))
+	}
+	return c, nil
+}
+
+// Periodically checks every endpoint with a cheap `eth_blockNumber` call and
+// updates its health status. Unhealthy endpoints are only used as a last resort.
+func (c *RpcClient) StartHealthChecks(interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_RPC_HEALTH_CHECK_INTERVAL
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.checkHealth()
+		}
+	}()
+}
+
+func (c *RpcClient) checkHealth() {
+	for _, e := range c.endpoints {
+		ctx, cancel := context.WithTimeout(context.Background(), c.callTimeout)
+		_, err := e.client.BlockNumber(ctx)
+		cancel()
+
+		wasHealthy := e.healthy.Swap(err == nil)
+		if wasHealthy && err != nil {
+			log.Printf("RPC endpoint %s is now unhealthy: %s", redactUrl(e.url), err.Error())
+		} else if !wasHealthy && err == nil {
+			log.Printf("RPC endpoint %s is healthy again", redactUrl(e.url))
+		}
+	}
+}
+
+// Returns endpoints in the order they should be tried: healthy ones first (in configuration order), then unhealthy ones.
+func (c *RpcClient) orderedEndpoints() []*endpoint {
+	ordered := make([]*endpoint, 0, len(c.endpoints))
+	for _, e := range c.endpoints {
+		if e.healthy.Load() {
+			ordered = append(ordered, e)
+		}
+	}
+	for _, e := range c.endpoints {
+		if !e.healthy.Load() {
+			ordered = append(ordered, e)
+		}
+	}
+	return ordered
+}
+
+// Runs an idempotent read against the endpoints, with a deadline on each call and a jittered backoff between attempts.
+// Every failed attempt moves on to the next endpoint.
+func read[T any](ctx context.Context, c *RpcClient, description string, call func(context.Context, *ethclient.Client) (T, error)) (T, error) {
+	var zero T
+	var lastErr error
+
+	endpoints := c.orderedEndpoints()
+	for attempt := 0; attempt < MAX_READ_ATTEMPTS; attempt++ {
+		if attempt > 0 {
+			if err := sleepWithJitter(ctx, attempt); err != nil {
+				return zero, errors.Wrapf(lastErr, "%s: gave up after %d attempts", description, attempt)
+			}
+		}
+
+		e := endpoints[attempt%len(endpoints)]
+		callCtx, cancel := context.WithTimeout(ctx, c.callTimeout)
+		result, err := call(callCtx, e.client)
+		cancel()
+		if err == nil {
+			return result, nil
+		}
+
+		// "Not found" is a valid answer (e.g. a receipt for a transaction which isn't mined yet), not a failure.
+		// So is a reverted call: every node would revert it the same way.
+		if errors.Is(err, geth.NotFound) || IsExecutionError(err) {
+			return zero, err
+		}
+
+		log.Printf("%s failed on %s (attempt %d): %s", description, redactUrl(e.url), attempt+1, err.Error())
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return zero, errors.Wrapf(lastErr, "%s failed", description)
+}
+
+// Sleeps for an exponentially increasing duration with full jitter, or returns early if the context is done.
+func sleepWithJitter(ctx context.Context, attempt int) error {
+	backoff := BASE_RETRY_BACKOFF * time.Duration(1<<(attempt-1))
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff))) + backoff/2)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *RpcClient) BalanceAt(ctx context.Context, address common.Address, blockNumber *big.Int) (*big.Int, error) {
+	return read(ctx, c, "eth_getBalance", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.BalanceAt(ctx, address, blockNumber)
+	})
+}
+
+func (c *RpcClient) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return read(ctx, c, "eth_gasPrice", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasPrice(ctx)
+	})
+}
+
+func (c *RpcClient) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	return read(ctx, c, "eth_maxPriorityFeePerGas", func(ctx context.Context, client *ethclient.Client) (*big.Int, error) {
+		return client.SuggestGasTipCap(ctx)
+	})
+}
+
+func (c *RpcClient) PendingNonceAt(ctx context.Context, address common.Address) (uint64, error) {
+	return read(ctx, c, "eth_getTransactionCount", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.PendingNonceAt(ctx, address)
+	})
+}
+
+func (c *RpcClient) BlockNumber(ctx context.Context) (uint64, error) {
+	return read(ctx, c, "eth_blockNumber", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.BlockNumber(ctx)
+	})
+}
+
+func (c *RpcClient) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	return read(ctx, c, "eth_getBlockByNumber", func(ctx context.Context, client *ethclient.Client) (*types.Block, error) {
+		return client.BlockByNumber(ctx, number)
+	})
+}
+
+func (c *RpcClient) TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error) {
+	return read(ctx, c, "eth_getTransactionReceipt", func(ctx context.Context, client *ethclient.Client) (*types.Receipt, error) {
+		return client.TransactionReceipt(ctx, hash)
+	})
+}
+
+func (c *RpcClient) EstimateGas(ctx context.Context, call geth.CallMsg) (uint64, error) {
+	return read(ctx, c, "eth_estimateGas", func(ctx context.Context, client *ethclient.Client) (uint64, error) {
+		return client.EstimateGas(ctx, call)
+	})
+}
+
+// CodeAt and CallContract make the client usable as a bind.ContractCaller (e.g. by the ens package)
+func (c *RpcClient) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return read(ctx, c, "eth_getCode", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CodeAt(ctx, contract, blockNumber)
+	})
+}
+
+func (c *RpcClient) CallContract(ctx context.Context, call geth.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return read(ctx, c, "eth_call", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.CallContract(ctx, call, blockNumber)
+	})
+}
+
+// Runs a call against the pending state (eth_call)
+func (c *RpcClient) PendingCallContract(ctx context.Context, call geth.CallMsg) ([]byte, error) {
+	return read(ctx, c, "eth_call", func(ctx context.Context, client *ethclient.Client) ([]byte, error) {
+		return client.PendingCallContract(ctx, call)
+	})
+}
+
+// Traces a call against the pending state with geth's callTracer, logs included (debug_traceCall).
+// Few providers support debug methods: each endpoint is tried once, and ErrTracingUnsupported is returned if none can trace.
+func (c *RpcClient) TraceCall(ctx context.Context, call geth.CallMsg, result interface{}) error {
+	arg := map[string]interface{}{
+		"from": call.From,
+		"to":   call.To,
+		"data": hexutil.Bytes(call.Data),
+	}
+	if call.Value != nil {
+		arg["value"] = (*hexutil.Big)(call.Value)
+	}
+	if call.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(call.Gas)
+	}
+	config := map[string]interface{}{
+		"tracer":       "callTracer",
+		"tracerConfig": map[string]interface{}{"withLog": true},
+	}
+
+	for _, e := range c.orderedEndpoints() {
+		callCtx, cancel := context.WithTimeout(ctx, c.callTimeout)
+		err := e.client.Client().CallContext(callCtx, result, "debug_traceCall", arg, "pending", config)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		log.Printf("debug_traceCall failed on %s: %s", redactUrl(e.url), err.Error())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return ErrTracingUnsupported
+}
+
+// Returns true for errors meaning a call or gas estimation reverted (as opposed to the node being unavailable)
+func IsExecutionError(err error) bool {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		return true
+	}
+	message := err.Error()
+	return strings.Contains(message, "execution reverted") || strings.Contains(message, "gas required exceeds allowance") || strings.Contains(message, "insufficient funds")
+}
+
+// Subscribes to new block headers. Subscriptions need a websocket endpoint:
+// ErrNoWebsocketEndpoint is returned if none is configured.
+func (c *RpcClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (geth.Subscription, error) {
+	for _, e := range c.orderedEndpoints() {
+		if strings.HasPrefix(e.url, "ws://") || strings.HasPrefix(e.url, "wss://") {
+			return e.client.SubscribeNewHead(ctx, ch)
+		}
+	}
+	return nil, ErrNoWebsocketEndpoint
+}
+
+// Sends a signed transaction, failing over to the next endpoint on transport errors.
+// Errors returned by a node (JSON-RPC errors) aren't retried elsewhere: another node would reject the transaction too.
+// Responses meaning "this transaction is already in the mempool or chain" are treated as success.
+func (c *RpcClient) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	var lastErr error
+	for _, e := range c.orderedEndpoints() {
+		callCtx, cancel := context.WithTimeout(ctx, c.callTimeout)
+		err := e.client.SendTransaction(callCtx, tx)
+		if err != nil && isAlreadyBroadcast(callCtx, e.client, tx, err) {
+			log.Printf("transaction %s was already broadcast (%s), treating as success", tx.Hash().Hex(), err.Error())
+			err = nil
+		}
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var rpcErr rpc.Error
+		if errors.As(err, &rpcErr) {
+			return err
+		}
+		log.Printf("eth_sendRawTransaction failed on %s: %s", redactUrl(e.url), err.Error())
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Wrap(lastErr, "unable to broadcast transaction with any RPC endpoint")
+}
+
+// Returns true if the error returned while sending tx indicates that the exact same transaction was broadcast before.
+// "nonce too low" is ambiguous: it's only considered a success if the node knows about a transaction with the same hash.
+func isAlreadyBroadcast(ctx context.Context, client *ethclient.Client, tx *types.Transaction, err error) bool {
+	message := strings.ToLower(err.Error())
+	if strings.Contains(message, "already known") || strings.Contains(message, "known transaction") || strings.Contains(message, "already imported") {
+		return true
+	}
+	if strings.Contains(message, "nonce too low") {
+		found, _, lookupErr := client.TransactionByHash(ctx, tx.Hash())
+		return lookupErr == nil && found != nil
+	}
+	return false
+}
+
+// RPC URLs commonly embed API keys in their path. Strip it before logging.
+func redactUrl(url string) string {
+	if i := strings.LastIndex(url, "/"); i > len("https://") {
+		return url[:i] + "/<redacted>"
+	}
+	return url
+}
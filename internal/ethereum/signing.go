@@ -0,0 +1,88 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/pkg/errors"
+)
+
+// Kinds of payloads users can sign with SIGN_RAW_PAYLOAD, named after the JSON-RPC methods dapps use to request them
+const SIGNATURE_KIND_PERSONAL_SIGN = "personal_sign"
+const SIGNATURE_KIND_TYPED_DATA = "eth_signTypedData_v4"
+
+var ErrSignatureMismatch = errors.New("signature was not made by the expected address")
+
+// Returns the EIP-191 digest signed by personal_sign: keccak256("\x19Ethereum Signed Message:\n" + len(message) + message)
+func PersonalMessageDigest(message []byte) []byte {
+	return accounts.TextHash(message)
+}
+
+// Returns the EIP-712 digest of typed data: keccak256("\x19\x01" + domainSeparator + hashStruct(message)).
+// Typed data bound to a chain must be bound to the one our wallets live on.
+func TypedDataDigest(typedData apitypes.TypedData) ([]byte, error) {
+	if typedData.Domain.ChainId != nil {
+		chainId := (*big.Int)(typedData.Domain.ChainId)
+		if chainId.Cmp(ChainID) != 0 {
+			return nil, fmt.Errorf("typed data is bound to chain %s, expected %s", chainId.String(), ChainID.String())
+		}
+	}
+	digest, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to hash typed data")
+	}
+	return digest, nil
+}
+
+// Assembles the 65-byte [R || S || V] signature expected by Ethereum tooling from Turnkey's hex-encoded r, s and v.
+// Turnkey returns the recovery ID (0 or 1) as v; Ethereum signatures carry it as 27 or 28.
+func AssembleSignature(r, s, v string) ([]byte, error) {
+	rBytes, err := hex.DecodeString(strings.TrimPrefix(r, "0x"))
+	if err != nil || len(rBytes) > 32 {
+		return nil, fmt.Errorf("invalid signature r value %q", r)
+	}
+	sBytes, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(sBytes) > 32 {
+		return nil, fmt.Errorf("invalid signature s value %q", s)
+	}
+	vBytes, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+	if err != nil || len(vBytes) != 1 || vBytes[0] > 1 {
+		return nil, fmt.Errorf("invalid signature v value %q", v)
+	}
+
+	signature := make([]byte, crypto.SignatureLength)
+	copy(signature[32-len(rBytes):32], rBytes)
+	copy(signature[64-len(sBytes):64], sBytes)
+	signature[crypto.RecoveryIDOffset] = vBytes[0] + 27
+	return signature, nil
+}
+
+// Checks that a 65-byte signature over digest was made by address, by recovering the signer's public key
+func VerifySignature(digest []byte, signature []byte, address string) error {
+	if len(signature) != crypto.SignatureLength {
+		return fmt.Errorf("expected a %d-byte signature, got %d bytes", crypto.SignatureLength, len(signature))
+	}
+	// crypto.SigToPub expects the raw recovery ID
+	recoverable := common.CopyBytes(signature)
+	if recoverable[crypto.RecoveryIDOffset] >= 27 {
+		recoverable[crypto.RecoveryIDOffset] -= 27
+	}
+	publicKey, err := crypto.SigToPub(digest, recoverable)
+	if err != nil {
+		return errors.Wrap(err, "unable to recover signer")
+	}
+	expected, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	if crypto.PubkeyToAddress(*publicKey) != expected {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
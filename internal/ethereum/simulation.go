@@ -0,0 +1,263 @@
+package ethereum
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+// Outcome of running a transaction against the pending state before it's signed
+type Simulation struct {
+	Success      bool   `json:"success"`
+	RevertReason string `json:"revertReason,omitempty"`
+	// True when the simulation comes from a debug trace. Without a trace, only the top-level ETH transfer is known:
+	// ETH moved by internal calls and token transfers aren't reported.
+	Traced         bool            `json:"traced"`
+	GasUsed        uint64          `json:"gasUsed,omitempty"`
+	BalanceChanges []BalanceChange `json:"balanceChanges"`
+	TokenTransfers []TokenTransfer `json:"tokenTransfers"`
+	// Maximum fee the transaction can cost (gas limit times fee cap), not included in balance changes
+	MaxFee string `json:"maxFee"`
+}
+
+// ETH balance change of an address, in wei (negative when the address pays)
+type BalanceChange struct {
+	Address string `json:"address"`
+	Delta   string `json:"delta"`
+}
+
+// A token transfer, read from Transfer events. Standard is ERC20 (Amount is set) or ERC721 (TokenId is set).
+type TokenTransfer struct {
+	Token    string `json:"token"`
+	Standard string `json:"standard"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Amount   string `json:"amount,omitempty"`
+	TokenId  string `json:"tokenId,omitempty"`
+}
+
+// keccak256("Transfer(address,address,uint256)"), shared by ERC-20 and ERC-721
+var transferEventTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// Error(string) reverts are decoded by abi.UnpackRevert, Panic(uint256) ones here
+var panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+
+var panicReasons = map[uint64]string{
+	0x01: "assertion failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array",
+	0x31: "pop on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to an uninitialized function",
+}
+
+// A frame of geth's callTracer output
+type callFrame struct {
+	Type         string         `json:"type"`
+	From         common.Address `json:"from"`
+	To           common.Address `json:"to"`
+	Value        *hexutil.Big   `json:"value"`
+	GasUsed      hexutil.Uint64 `json:"gasUsed"`
+	Output       hexutil.Bytes  `json:"output"`
+	Error        string         `json:"error"`
+	RevertReason string         `json:"revertReason"`
+	Calls        []callFrame    `json:"calls"`
+	Logs         []callLog      `json:"logs"`
+}
+
+type callLog struct {
+	Address common.Address `json:"address"`
+	Topics  []common.Hash  `json:"topics"`
+	Data    hexutil.Bytes  `json:"data"`
+}
+
+// Simulates a transaction against the pending state. A debug trace is used when an RPC endpoint supports it,
+// otherwise the transaction runs through eth_call. Reverts are reported in the simulation, not as errors.
+func Simulate(ctx context.Context, from string, to string, value *big.Int, data []byte, gasLimit uint64) (*Simulation, error) {
+	fromAddress, toAddress, err := parseAddresses(from, to)
+	if err != nil {
+		return nil, err
+	}
+	call := geth.CallMsg{
+		From:  fromAddress,
+		To:    &toAddress,
+		Value: value,
+		Data:  data,
+		Gas:   gasLimit,
+	}
+
+	gasFeeCap, _, err := suggestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	simulation := &Simulation{
+		BalanceChanges: []BalanceChange{},
+		TokenTransfers: []TokenTransfer{},
+		MaxFee:         new(big.Int).Mul(gasFeeCap, new(big.Int).SetUint64(gasLimit)).String(),
+	}
+
+	var trace callFrame
+	err = Client.TraceCall(ctx, call, &trace)
+	if err == nil {
+		simulation.Traced = true
+		simulation.GasUsed = uint64(trace.GasUsed)
+		simulation.Success = trace.Error == ""
+		if !simulation.Success {
+			simulation.RevertReason = frameRevertReason(trace)
+			return simulation, nil
+		}
+		deltas := map[common.Address]*big.Int{}
+		collectFrameEffects(trace, deltas, simulation)
+		simulation.BalanceChanges = sortedBalanceChanges(deltas)
+		return simulation, nil
+	}
+	if err != ErrTracingUnsupported {
+		return nil, errors.Wrap(err, "unable to trace transaction")
+	}
+
+	_, err = Client.PendingCallContract(ctx, call)
+	if err != nil {
+		if !IsExecutionError(err) {
+			return nil, errors.Wrap(err, "unable to simulate transaction")
+		}
+		simulation.RevertReason = executionErrorReason(err)
+		return simulation, nil
+	}
+	simulation.Success = true
+	if value != nil && value.Sign() > 0 {
+		deltas := map[common.Address]*big.Int{}
+		addDelta(deltas, call.From, new(big.Int).Neg(value))
+		addDelta(deltas, toAddress, value)
+		simulation.BalanceChanges = sortedBalanceChanges(deltas)
+	}
+	return simulation, nil
+}
+
+// Accumulates ETH moved and tokens transferred by a successful frame and its successful sub-calls.
+// Frames which failed were reverted, along with everything their sub-calls did.
+func collectFrameEffects(frame callFrame, deltas map[common.Address]*big.Int, simulation *Simulation) {
+	if frame.Error != "" {
+		return
+	}
+	// Delegate and static calls don't move ETH (a delegate call's value is the caller's, already accounted for)
+	if frame.Value != nil && frame.Type != "DELEGATECALL" && frame.Type != "STATICCALL" {
+		value := frame.Value.ToInt()
+		if value.Sign() > 0 {
+			addDelta(deltas, frame.From, new(big.Int).Neg(value))
+			addDelta(deltas, frame.To, value)
+		}
+	}
+	for _, l := range frame.Logs {
+		if transfer := parseTransferLog(l); transfer != nil {
+			simulation.TokenTransfers = append(simulation.TokenTransfers, *transfer)
+		}
+	}
+	for _, child := range frame.Calls {
+		collectFrameEffects(child, deltas, simulation)
+	}
+}
+
+func parseTransferLog(l callLog) *TokenTransfer {
+	if len(l.Topics) < 3 || l.Topics[0] != transferEventTopic {
+		return nil
+	}
+	transfer := TokenTransfer{
+		Token: l.Address.Hex(),
+		From:  common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+		To:    common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+	}
+	// ERC-721 indexes the token ID, ERC-20 puts the amount in the data
+	switch {
+	case len(l.Topics) == 4:
+		transfer.Standard = "ERC721"
+		transfer.TokenId = l.Topics[3].Big().String()
+	case len(l.Topics) == 3 && len(l.Data) == 32:
+		transfer.Standard = "ERC20"
+		transfer.Amount = new(big.Int).SetBytes(l.Data).String()
+	default:
+		return nil
+	}
+	return &transfer
+}
+
+func addDelta(deltas map[common.Address]*big.Int, address common.Address, amount *big.Int) {
+	if _, ok := deltas[address]; !ok {
+		deltas[address] = new(big.Int)
+	}
+	deltas[address].Add(deltas[address], amount)
+}
+
+func sortedBalanceChanges(deltas map[common.Address]*big.Int) []BalanceChange {
+	changes := []BalanceChange{}
+	for address, delta := range deltas {
+		if delta.Sign() != 0 {
+			changes = append(changes, BalanceChange{Address: address.Hex(), Delta: delta.String()})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Address < changes[j].Address
+	})
+	return changes
+}
+
+// The innermost failed frame usually carries the most specific reason
+func frameRevertReason(frame callFrame) string {
+	for _, child := range frame.Calls {
+		if child.Error != "" {
+			if reason := frameRevertReason(child); reason != "" {
+				return reason
+			}
+		}
+	}
+	if frame.RevertReason != "" {
+		return frame.RevertReason
+	}
+	if reason := decodeRevertData(frame.Output); reason != "" {
+		return reason
+	}
+	return frame.Error
+}
+
+func executionErrorReason(err error) string {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if data, ok := dataErr.ErrorData().(string); ok {
+			if reason := decodeRevertData(common.FromHex(data)); reason != "" {
+				return reason
+			}
+		}
+	}
+	return err.Error()
+}
+
+// Decodes Error(string) and Panic(uint256) revert data. Custom errors are reported by selector.
+func decodeRevertData(data []byte) string {
+	if len(data) < 4 {
+		return ""
+	}
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason
+	}
+	if bytes.Equal(data[:4], panicSelector) && len(data) == 36 {
+		code := new(big.Int).SetBytes(data[4:])
+		if reason, ok := panicReasons[code.Uint64()]; ok && code.IsUint64() {
+			return "panic: " + reason
+		}
+		return fmt.Sprintf("panic: code 0x%s", code.Text(16))
+	}
+	return "custom error " + strings.ToLower(hexutil.Encode(data[:4]))
+}
@@ -0,0 +1,60 @@
+package ethereum
+
+import (
+	"context"
+	"math/big"
+
+	geth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+const erc20Abi = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"decimals","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint8"}]},
+	{"type":"function","name":"balanceOf","stateMutability":"view","inputs":[{"name":"owner","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+var erc20 = mustParseAbi(erc20Abi)
+
+// Returns the calldata of an ERC-20 transfer
+func EncodeTokenTransfer(to common.Address, amount *big.Int) ([]byte, error) {
+	return erc20.Pack("transfer", to, amount)
+}
+
+// Returns the number of decimals of an ERC-20 token
+func TokenDecimals(ctx context.Context, token common.Address) (uint8, error) {
+	var decimals uint8
+	if err := callToken(ctx, token, "decimals", &decimals); err != nil {
+		return 0, err
+	}
+	return decimals, nil
+}
+
+// Returns the ERC-20 token balance of an address, in base units
+func TokenBalance(ctx context.Context, token common.Address, owner common.Address) (*big.Int, error) {
+	var balance *big.Int
+	if err := callToken(ctx, token, "balanceOf", &balance, owner); err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+func callToken(ctx context.Context, token common.Address, method string, result interface{}, args ...interface{}) error {
+	data, err := erc20.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	output, err := Client.CallContract(ctx, geth.CallMsg{To: &token, Data: data}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to call %s on %s", method, token.Hex())
+	}
+	// Addresses without code return nothing
+	if len(output) == 0 {
+		return errors.Errorf("%s is not an ERC-20 token", token.Hex())
+	}
+	if err := erc20.UnpackIntoInterface(result, method, output); err != nil {
+		return errors.Wrapf(err, "unexpected %s result from %s", method, token.Hex())
+	}
+	return nil
+}
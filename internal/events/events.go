@@ -0,0 +1,216 @@
+package events
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/webhooks"
+)
+
+const EVENT_TYPE_BALANCE = "balance"
+const EVENT_TYPE_TRANSFER = "transfer"
+const EVENT_TYPE_TRANSACTION = "transaction"
+
+// An event pushed to a user. Type is used as the SSE event name, Data is JSON-encoded.
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+type BalanceEvent struct {
+	Address string `json:"address"`
+	Balance string `json:"balance"`
+}
+
+type TransferEvent struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Amount      string `json:"amount"`
+	Hash        string `json:"hash"`
+	Block       int64  `json:"block"`
+}
+
+type TransactionEvent struct {
+	Hash   string `json:"hash"`
+	Status string `json:"status"`
+	Block  int64  `json:"block"`
+}
+
+type subscription struct {
+	userId      uint
+	address     string
+	ch          chan Event
+	lastBalance string
+}
+
+var (
+	mu            sync.Mutex
+	subscriptions = map[int]*subscription{}
+	nextId        int
+)
+
+// Starts processing new blocks. Must be called after ethereum.StartBlockWatcher.
+func Start() {
+	blocks, _ := ethereum.Blocks.Subscribe()
+	go func() {
+		for block := range blocks {
+			processBlock(context.Background(), block)
+		}
+	}()
+}
+
+// Subscribes to events for a user and their wallet address.
+// The returned function must be called once the subscriber is gone.
+func Subscribe(userId uint, address string) (<-chan Event, func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := nextId
+	nextId++
+	s := &subscription{
+		userId:  userId,
+		address: strings.ToLower(address),
+		ch:      make(chan Event, 32),
+	}
+	subscriptions[id] = s
+
+	return s.ch, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := subscriptions[id]; ok {
+			delete(subscriptions, id)
+			close(s.ch)
+		}
+	}
+}
+
+// Sends an event to every subscriber for a given user
+func Publish(userId uint, event Event) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range subscriptions {
+		if s.userId == userId {
+			send(s, event)
+		}
+	}
+}
+
+// Must be called with mu held
+func send(s *subscription, event Event) {
+	select {
+	case s.ch <- event:
+	default:
+		log.Printf("event subscriber for user %d is lagging behind, dropping %s event", s.userId, event.Type)
+	}
+}
+
+func processBlock(ctx context.Context, block *types.Block) {
+	updatePendingTransactions(ctx)
+
+	// Balances are fetched without holding the lock: RPC calls can be slow.
+	balances := map[string]string{}
+	for _, address := range subscribedAddresses() {
+		balance, err := ethereum.GetBalance(ctx, address)
+		if err != nil {
+			log.Printf("unable to refresh balance for %s: %s", address, err.Error())
+			continue
+		}
+		balances[address] = ethereum.FormatEth(balance)
+	}
+	transfers := ethereum.ExternalTransfers(block)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range subscriptions {
+		for _, transfer := range transfers {
+			if transfer.Destination != s.address {
+				continue
+			}
+			send(s, Event{Type: EVENT_TYPE_TRANSFER, Data: TransferEvent{
+				Type:        "deposit",
+				Source:      transfer.Source,
+				Destination: transfer.Destination,
+				Amount:      ethereum.FormatEth(transfer.Amount),
+				Hash:        transfer.Hash,
+				Block:       int64(transfer.Block),
+			}})
+		}
+
+		if balance, ok := balances[s.address]; ok && balance != s.lastBalance {
+			s.lastBalance = balance
+			send(s, Event{Type: EVENT_TYPE_BALANCE, Data: BalanceEvent{
+				Address: s.address,
+				Balance: balance,
+			}})
+		}
+	}
+}
+
+// Returns the distinct wallet addresses with at least one subscriber
+func subscribedAddresses() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	seen := map[string]bool{}
+	var addresses []string
+	for _, s := range subscriptions {
+		if !seen[s.address] {
+			seen[s.address] = true
+			addresses = append(addresses, s.address)
+		}
+	}
+	return addresses
+}
+
+// Looks up receipts for all pending transactions, and records (and publishes) status transitions
+func updatePendingTransactions(ctx context.Context) {
+	pending, err := models.ListPendingTransactions()
+	if err != nil {
+		log.Printf("unable to list pending transactions: %s", err.Error())
+		return
+	}
+
+	for i := range pending {
+		tx := &pending[i]
+		receipt, err := ethereum.GetTransactionReceipt(ctx, tx.Hash)
+		if err != nil {
+			log.Printf("unable to get receipt for transaction %s: %s", tx.Hash, err.Error())
+			continue
+		}
+		if receipt == nil {
+			continue
+		}
+
+		status := models.TRANSACTION_STATUS_CONFIRMED
+		if receipt.Status == types.ReceiptStatusFailed {
+			status = models.TRANSACTION_STATUS_FAILED
+		}
+		if err := models.UpdateTransactionStatus(tx, status, receipt.BlockNumber.Uint64()); err != nil {
+			log.Print(err.Error())
+			continue
+		}
+
+		transactionEvent := TransactionEvent{
+			Hash:   tx.Hash,
+			Status: tx.Status,
+			Block:  tx.Block.Int64,
+		}
+		Publish(tx.UserID, Event{Type: EVENT_TYPE_TRANSACTION, Data: transactionEvent})
+		if tx.Status == models.TRANSACTION_STATUS_CONFIRMED {
+			webhooks.Emit(webhooks.EVENT_TRANSACTION_CONFIRMED, map[string]interface{}{
+				"userId":      tx.UserID,
+				"hash":        tx.Hash,
+				"source":      tx.Source,
+				"destination": tx.Destination,
+				"amount":      tx.Amount,
+				"block":       tx.Block.Int64,
+			})
+		}
+	}
+}
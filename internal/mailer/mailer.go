@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var Client Mailer
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Sends plain-text emails through an SMTP server.
+// Any SMTP server works, including local stand-ins such as MailHog or smtp4dev (no credentials needed).
+type SmtpMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Only logs emails. Used when no SMTP server is configured.
+type LogMailer struct{}
+
+// Configures the mailer from SMTP_* environment variables.
+// Without SMTP_HOST, emails are logged instead of sent.
+func Init() error {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST is not set: emails will be logged instead of sent")
+		Client = &LogMailer{}
+		return nil
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		return errors.New("SMTP_FROM must be set when SMTP_HOST is")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	Client = &SmtpMailer{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	}
+	return nil
+}
+
+func (m *SmtpMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid recipient or subject: %q, %q", to, subject)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	message := strings.Join([]string{
+		fmt.Sprintf("From: %s", m.From),
+		fmt.Sprintf("To: %s", to),
+		fmt.Sprintf("Subject: %s", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+		"",
+		body,
+	}, "\r\n")
+
+	err := smtp.SendMail(net.JoinHostPort(m.Host, m.Port), auth, m.From, []string{to}, []byte(message))
+	if err != nil {
+		return errors.Wrapf(err, "error while sending email to %s", to)
+	}
+	return nil
+}
+
+func (m *LogMailer) Send(to, subject, body string) error {
+	log.Printf("email to %s (subject: %q):\n%s\n", to, subject, body)
+	return nil
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Everything we store locally about a user, as returned by the account export endpoint
+type AccountExport struct {
+	ExportedAt             time.Time               `json:"exportedAt"`
+	User                   User                    `json:"user"`
+	Wallets                []Wallet                `json:"wallets"`
+	Authenticators         []Authenticator         `json:"authenticators"`
+	Transactions           []Transaction           `json:"transactions"`
+	NotificationPreference *NotificationPreference `json:"notificationPreference"`
+	Registration           *Registration           `json:"registration"`
+	Sessions               []UserSession           `json:"sessions"`
+	AuditEvents            []AuditEvent            `json:"auditEvents"`
+	Contacts               []Contact               `json:"contacts"`
+	SpendingPolicy         *SpendingPolicy         `json:"spendingPolicy"`
+	TurnkeyPolicies        []TurnkeyPolicy         `json:"turnkeyPolicies"`
+	PaymentBatches         []PaymentBatch          `json:"paymentBatches"`
+}
+
+// Why a sub-organization was retired
+const RETIREMENT_REASON_ACCOUNT_DELETED = "account_deleted"
+const RETIREMENT_REASON_EMAIL_CLAIMED = "email_claimed"
+
+// The sub-organization of a deleted account. It's never linked to a user again, nor adopted by reconciliation.
+type RetiredSubOrganization struct {
+	gorm.Model
+	SubOrganizationId string `gorm:"size:255;not null;unique"`
+	UserID            uint   `gorm:"not null;index"`
+	Reason            string `gorm:"size:32;not null"`
+}
+
+func IsSubOrganizationRetired(subOrganizationId string) (bool, error) {
+	var count int64
+	err := db.Database.Model(&RetiredSubOrganization{}).Where("sub_organization_id=?", subOrganizationId).Count(&count).Error
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to look up retired sub-organization %s", subOrganizationId)
+	}
+	return count > 0, nil
+}
+
+func ListTransactionsForUser(userId uint) ([]Transaction, error) {
+	var transactions []Transaction
+	err := db.Database.Where("user_id=?", userId).Order("id").Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func ExportAccount(userId uint) (*AccountExport, error) {
+	export := AccountExport{ExportedAt: time.Now()}
+
+	if err := db.Database.Where("id=?", userId).First(&export.User).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to find user %d", userId)
+	}
+	if err := db.Database.Where("user_id=?", userId).Order("id").Find(&export.Wallets).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export wallets of user %d", userId)
+	}
+	authenticators, err := ListAuthenticatorsForUser(userId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to export authenticators of user %d", userId)
+	}
+	export.Authenticators = authenticators
+	transactions, err := ListTransactionsForUser(userId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to export transactions of user %d", userId)
+	}
+	export.Transactions = transactions
+
+	var preferences []NotificationPreference
+	if err := db.Database.Where("user_id=?", userId).Find(&preferences).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export notification preferences of user %d", userId)
+	}
+	if len(preferences) > 0 {
+		export.NotificationPreference = &preferences[0]
+	}
+	var registrations []Registration
+	if err := db.Database.Where("user_id=?", userId).Find(&registrations).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export registration of user %d", userId)
+	}
+	if len(registrations) > 0 {
+		export.Registration = &registrations[0]
+	}
+	if err := db.Database.Where("user_id=?", userId).Order("id").Find(&export.Sessions).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export sessions of user %d", userId)
+	}
+	if err := db.Database.Where("user_id=?", userId).Order("id").Find(&export.AuditEvents).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export audit events of user %d", userId)
+	}
+	contacts, err := ListContactsForUser(userId)
+	if err != nil {
+		return nil, err
+	}
+	export.Contacts = contacts
+	if export.SpendingPolicy, err = FindSpendingPolicy(userId); err != nil {
+		return nil, err
+	}
+	if export.TurnkeyPolicies, err = ListTurnkeyPolicies(userId); err != nil {
+		return nil, err
+	}
+	if err := db.Database.Preload("Payments").Where("user_id=?", userId).Order("id").Find(&export.PaymentBatches).Error; err != nil {
+		return nil, errors.Wrapf(err, "unable to export payment batches of user %d", userId)
+	}
+	return &export, nil
+}
+
+// Soft-deletes a user along with their local data, and retires their sub-organization.
+// The email is rewritten to "deleted-<id>:<email>" so that it can be used to register again, while keeping a record of it.
+// Sessions of deleted users stop resolving to a user (see FindUserById).
+func DeleteAccount(userId uint, reason string) error {
+	return db.Database.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.Where("id=?", userId).First(&user).Error; err != nil {
+			return errors.Wrapf(err, "unable to find user %d", userId)
+		}
+
+		err := tx.Model(&user).Update("email", fmt.Sprintf("deleted-%d:%s", user.ID, user.Email)).Error
+		if err != nil {
+			return errors.Wrapf(err, "unable to release email of user %d", userId)
+		}
+		if user.SubOrganizationId.Valid {
+			retired := RetiredSubOrganization{SubOrganizationId: user.SubOrganizationId.String, UserID: user.ID, Reason: reason}
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&retired).Error; err != nil {
+				return errors.Wrapf(err, "unable to retire sub-organization of user %d", userId)
+			}
+		}
+
+		for _, model := range []interface{}{&Wallet{}, &Authenticator{}, &Transaction{}, &NotificationPreference{}, &Registration{}, &UserSession{}, &Contact{}, &SpendingPolicy{}, &TurnkeyPolicy{}} {
+			if err := tx.Where("user_id=?", userId).Delete(model).Error; err != nil {
+				return errors.Wrapf(err, "unable to delete %T rows of user %d", model, userId)
+			}
+		}
+		// Batch payments belong to the user through their batch
+		batchIds := tx.Model(&PaymentBatch{}).Select("id").Where("user_id=?", userId)
+		if err := tx.Where("batch_id IN (?)", batchIds).Delete(&BatchPayment{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete batch payments of user %d", userId)
+		}
+		if err := tx.Where("user_id=?", userId).Delete(&PaymentBatch{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete payment batches of user %d", userId)
+		}
+		// Pending codes are useless once the account is gone
+		if err := tx.Unscoped().Where("user_id=?", userId).Delete(&EmailVerification{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete email verification of user %d", userId)
+		}
+		if err := tx.Delete(&user).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete user %d", userId)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+// Security-sensitive actions we keep a trail of
+const AUDIT_ACTION_STEP_UP = "step_up"
+const AUDIT_ACTION_WALLET_EXPORT = "wallet_export"
+const AUDIT_ACTION_ACCOUNT_EXPORT = "account_export"
+const AUDIT_ACTION_RECOVERY = "recovery"
+const AUDIT_ACTION_SPENDING_POLICY_UPDATE = "spending_policy_update"
+const AUDIT_ACTION_CO_SIGN = "co_sign"
+const AUDIT_ACTION_ACCOUNT_DELETION = "account_deletion"
+
+const AUDIT_OUTCOME_SUCCEEDED = "succeeded"
+const AUDIT_OUTCOME_FAILED = "failed"
+const AUDIT_OUTCOME_DENIED = "denied"
+
+// One audited action. Audit events are kept when accounts are deleted.
+type AuditEvent struct {
+	gorm.Model
+	UserID uint `gorm:"not null;index" json:"userId"`
+	// Session the action was taken from. Null for actions taken without a session (e.g. recovery).
+	UserSessionID sql.NullInt64 `json:"userSessionId"`
+	Action        string        `gorm:"size:64;not null;index" json:"action"`
+	Outcome       string        `gorm:"size:32;not null" json:"outcome"`
+	IpAddress     string        `gorm:"size:64" json:"ipAddress"`
+	UserAgent     string        `gorm:"size:512" json:"userAgent"`
+	// Free-form context, e.g. why an action was denied
+	Details string `gorm:"size:1024" json:"details"`
+}
+
+func RecordAuditEvent(event *AuditEvent) error {
+	if len(event.UserAgent) > 512 {
+		event.UserAgent = event.UserAgent[:512]
+	}
+	if len(event.Details) > 1024 {
+		event.Details = event.Details[:1024]
+	}
+	if err := db.Database.Create(event).Error; err != nil {
+		return errors.Wrapf(err, "unable to record %s audit event for user %d", event.Action, event.UserID)
+	}
+	return nil
+}
+
+// Returns when a user last completed an action successfully. The time is zero if they never did.
+func LastSucceededAuditEvent(userId uint, action string) (time.Time, error) {
+	var event AuditEvent
+	err := db.Database.
+		Where("user_id=? AND action=? AND outcome=?", userId, action, AUDIT_OUTCOME_SUCCEEDED).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&event).Error
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "unable to look up last %s of user %d", action, userId)
+	}
+	return event.CreatedAt, nil
+}
+
+// Lists a user's audit events, most recent first
+func ListAuditEventsForUser(userId uint, limit int) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := db.Database.Where("user_id=?", userId).Order("created_at DESC").Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list audit events of user %d", userId)
+	}
+	return events, nil
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Local record of a passkey registered in a user's sub-organization.
+// Turnkey is the source of truth for which authenticators exist; we keep names (which users can change) and
+// device metadata. Records are keyed by credential ID since that's known before Turnkey assigns authenticator IDs.
+type Authenticator struct {
+	gorm.Model
+	UserID       uint   `gorm:"not null;index" json:"-"`
+	CredentialId string `gorm:"size:1024;not null;unique" json:"credentialId"`
+	Name         string `gorm:"size:255;not null" json:"name"`
+	// Identifies the authenticator model (e.g. a given security key, or a platform authenticator like iCloud Keychain)
+	Aaguid string `gorm:"size:64" json:"aaguid"`
+	// Comma-separated Turnkey transports (AUTHENTICATOR_TRANSPORT_USB, ...)
+	Transports string `gorm:"size:255" json:"transports"`
+}
+
+func (a *Authenticator) TransportList() []string {
+	if a.Transports == "" {
+		return []string{}
+	}
+	return strings.Split(a.Transports, ",")
+}
+
+// Creates or updates the local record for a credential
+func SaveAuthenticator(authenticator *Authenticator) error {
+	if authenticator.CredentialId == "" {
+		return errors.New("cannot save an authenticator without credential ID")
+	}
+	err := db.Database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "credential_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "name", "aaguid", "transports", "updated_at"}),
+	}).Create(authenticator).Error
+	if err != nil {
+		return errors.Wrapf(err, "error while saving authenticator for user %d", authenticator.UserID)
+	}
+	return nil
+}
+
+// Sets the local name of a credential, leaving other metadata untouched
+func RenameAuthenticator(userId uint, credentialId, name string) error {
+	authenticator := Authenticator{
+		UserID:       userId,
+		CredentialId: credentialId,
+		Name:         name,
+	}
+	err := db.Database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "credential_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "updated_at"}),
+	}).Create(&authenticator).Error
+	if err != nil {
+		return errors.Wrapf(err, "error while renaming authenticator for user %d", userId)
+	}
+	return nil
+}
+
+func ListAuthenticatorsForUser(userId uint) ([]Authenticator, error) {
+	var authenticators []Authenticator
+	err := db.Database.Where("user_id=?", userId).Find(&authenticators).Error
+	if err != nil {
+		return nil, err
+	}
+	return authenticators, nil
+}
+
+func DeleteAuthenticatorsForUser(userId uint, credentialIds []string) error {
+	if len(credentialIds) == 0 {
+		return nil
+	}
+	// Hard delete: credential IDs are unique, and a soft-deleted row would block registering the same credential again
+	return db.Database.Unscoped().Where("user_id=? AND credential_id IN ?", userId, credentialIds).Delete(&Authenticator{}).Error
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+// How a contact was added
+const CONTACT_SOURCE_MANUAL = "manual"
+const CONTACT_SOURCE_HISTORY = "history"
+
+var ErrDuplicateContact = errors.New("this address is already in the address book")
+
+// A saved recipient in a user's address book
+type Contact struct {
+	gorm.Model
+	UserID  uint   `gorm:"not null;index" json:"-"`
+	Label   string `gorm:"size:255;not null" json:"label"`
+	Address string `gorm:"size:42;not null" json:"address"` // checksummed
+	ChainID int64  `gorm:"not null" json:"chainId"`
+	Notes   string `gorm:"size:1024" json:"notes"`
+	Source  string `gorm:"size:32;not null" json:"source"`
+	// Set when the contact was created from a history entry
+	TransferHash string `gorm:"size:255" json:"transferHash,omitempty"`
+}
+
+func ListContactsForUser(userId uint) ([]Contact, error) {
+	var contacts []Contact
+	err := db.Database.Where("user_id=?", userId).Order("label, id").Find(&contacts).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list contacts of user %d", userId)
+	}
+	return contacts, nil
+}
+
+// Returns gorm.ErrRecordNotFound if the contact doesn't exist or belongs to another user
+func FindContactForUser(userId uint, contactId uint) (*Contact, error) {
+	var contact Contact
+	err := db.Database.Where("id=? AND user_id=?", contactId, userId).First(&contact).Error
+	if err != nil {
+		return nil, err
+	}
+	return &contact, nil
+}
+
+// Returns nil if the user has no contact for this address on this chain
+func FindContactByAddress(userId uint, address string, chainId int64) (*Contact, error) {
+	var contacts []Contact
+	err := db.Database.Where("user_id=? AND address=? AND chain_id=?", userId, address, chainId).Limit(1).Find(&contacts).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up contact of user %d for %s", userId, address)
+	}
+	if len(contacts) == 0 {
+		return nil, nil
+	}
+	return &contacts[0], nil
+}
+
+// Saves a new contact. Each address is saved at most once per chain.
+func CreateContact(contact *Contact) error {
+	existing, err := FindContactByAddress(contact.UserID, contact.Address, contact.ChainID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrDuplicateContact
+	}
+	if err := db.Database.Create(contact).Error; err != nil {
+		return errors.Wrapf(err, "unable to create contact for user %d", contact.UserID)
+	}
+	return nil
+}
+
+// Saves changes to a contact's label, address, chain and notes
+func UpdateContact(contact *Contact) error {
+	existing, err := FindContactByAddress(contact.UserID, contact.Address, contact.ChainID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != contact.ID {
+		return ErrDuplicateContact
+	}
+	err = db.Database.Model(contact).Updates(map[string]interface{}{
+		"label":    contact.Label,
+		"address":  contact.Address,
+		"chain_id": contact.ChainID,
+		"notes":    contact.Notes,
+	}).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to update contact %d", contact.ID)
+	}
+	return nil
+}
+
+// Returns gorm.ErrRecordNotFound if the contact doesn't exist or belongs to another user
+func DeleteContact(userId uint, contactId uint) error {
+	result := db.Database.Where("id=? AND user_id=?", contactId, userId).Delete(&Contact{})
+	if result.Error != nil {
+		return errors.Wrapf(result.Error, "unable to delete contact %d", contactId)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Returns true if the user sent a transaction to this address through our backend
+func HasSentTransactionTo(userId uint, address string) (bool, error) {
+	var count int64
+	err := db.Database.Model(&Transaction{}).Where("user_id=? AND destination=?", userId, address).Count(&count).Error
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to look up transactions of user %d to %s", userId, address)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"os"
+	"testing"
+
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+)
+
+// These tests need a Postgres database: set TEST_DATABASE_URL to run them
+func connectTestDatabase(t *testing.T) {
+	databaseUrl := os.Getenv("TEST_DATABASE_URL")
+	if databaseUrl == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	t.Setenv("DATABASE_URL", databaseUrl)
+	db.Connect()
+	if err := db.Database.AutoMigrate(&User{}, &Transaction{}, &SpendingPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+}
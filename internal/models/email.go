@@ -0,0 +1,91 @@
+package models
+
+import (
+	"log"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+)
+
+// Name of the case-insensitive unique index on the emails of users who aren't deleted
+const USERS_EMAIL_LOWER_INDEX = "idx_users_active_email_lower"
+
+// Earlier version of USERS_EMAIL_LOWER_INDEX, which also covered deleted users
+const LEGACY_USERS_EMAIL_LOWER_INDEX = "idx_users_email_lower"
+
+// Normalizes an email before it's stored or looked up: surrounding whitespace is dropped and the address is lowercased.
+// Strictly speaking the local part of an address is case-sensitive, but no mainstream provider treats it that way,
+// and treating "Alice@x.com" and "alice@x.com" as two accounts is far more surprising to users.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Several users whose emails are the same once normalized
+type EmailCollision struct {
+	NormalizedEmail string `json:"normalizedEmail"`
+	// Comma-separated, oldest user first
+	UserIds string `json:"userIds"`
+	Emails  string `json:"emails"`
+}
+
+// Lists groups of users sharing the same normalized email. These were created before emails were normalized
+// and need manual resolution (e.g. deleting the account which was never used).
+func FindEmailCollisions() ([]EmailCollision, error) {
+	var collisions []EmailCollision
+	err := db.Database.Raw(`
+		SELECT lower(trim(email)) AS normalized_email,
+			string_agg(id::text, ',' ORDER BY id) AS user_ids,
+			string_agg(email, ',' ORDER BY id) AS emails
+		FROM users
+		WHERE deleted_at IS NULL
+		GROUP BY lower(trim(email))
+		HAVING count(*) > 1
+		ORDER BY normalized_email`).Scan(&collisions).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to look for email collisions")
+	}
+	return collisions, nil
+}
+
+// Normalizes existing emails and adds a case-insensitive unique index on them.
+// Colliding emails are reported and left untouched; the index is only created once there are no collisions left.
+// Deleted users are ignored throughout: they don't collide, aren't normalized and aren't covered by the index.
+// Safe to run on every startup.
+func MigrateEmailNormalization() error {
+	collisions, err := FindEmailCollisions()
+	if err != nil {
+		return err
+	}
+
+	for _, collision := range collisions {
+		log.Printf("email collision: users %s share the normalized email %q (%s)", collision.UserIds, collision.NormalizedEmail, collision.Emails)
+	}
+
+	result := db.Database.Exec(`
+		UPDATE users SET email = lower(trim(email))
+		WHERE deleted_at IS NULL
+		AND email <> lower(trim(email))
+		AND lower(trim(email)) IN (
+			SELECT lower(trim(email)) FROM users WHERE deleted_at IS NULL GROUP BY lower(trim(email)) HAVING count(*) = 1
+		)`)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "unable to normalize user emails")
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("normalized %d user emails", result.RowsAffected)
+	}
+
+	if len(collisions) > 0 {
+		log.Printf("%d email collisions found: skipping creation of %s until they're resolved", len(collisions), USERS_EMAIL_LOWER_INDEX)
+		return nil
+	}
+	err = db.Database.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + USERS_EMAIL_LOWER_INDEX + " ON users (lower(email)) WHERE deleted_at IS NULL").Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to create %s", USERS_EMAIL_LOWER_INDEX)
+	}
+	if err := db.Database.Exec("DROP INDEX IF EXISTS " + LEGACY_USERS_EMAIL_LOWER_INDEX).Error; err != nil {
+		return errors.Wrapf(err, "unable to drop %s", LEGACY_USERS_EMAIL_LOWER_INDEX)
+	}
+	return nil
+}
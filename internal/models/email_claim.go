@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A pending claim on an email used by an unverified account. The code is sent to the email: whoever types it back
+// controls the email, and can register with it in place of the unverified account.
+type EmailClaim struct {
+	gorm.Model
+	Email string `gorm:"size:255;not null;unique"`
+	// SHA-256 of the code. Codes are never stored in clear.
+	CodeHash  string    `gorm:"size:64;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+}
+
+// Creates or replaces the pending claim on an email
+func SaveEmailClaim(claim *EmailClaim) error {
+	err := db.Database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"code_hash", "expires_at", "attempts", "created_at", "updated_at"}),
+	}).Create(claim).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to save claim on %s", claim.Email)
+	}
+	return nil
+}
+
+func FindEmailClaim(email string) (*EmailClaim, error) {
+	var claim EmailClaim
+	err := db.Database.Where("email=?", email).First(&claim).Error
+	if err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+func IncrementEmailClaimAttempts(claim *EmailClaim) error {
+	err := db.Database.Model(claim).Update("attempts", gorm.Expr("attempts + 1")).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to record attempt for email claim %d", claim.ID)
+	}
+	return nil
+}
+
+func DeleteEmailClaim(email string) error {
+	return db.Database.Unscoped().Where("email=?", email).Delete(&EmailClaim{}).Error
+}
+
+// Returns the account registered with an email which was never verified, if any. Its email can be claimed.
+// Returns gorm.ErrRecordNotFound otherwise.
+func FindUnverifiedAccountByEmail(email string) (User, error) {
+	var user User
+	err := db.Database.
+		Where("lower(email)=? AND sub_organization_id IS NOT NULL AND verified_at IS NULL", NormalizeEmail(email)).
+		Order("id").
+		First(&user).Error
+	if err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email      string
+		normalized string
+	}{
+		{"alice@example.com", "alice@example.com"},
+		{"Alice@Example.COM", "alice@example.com"},
+		{"  alice@example.com ", "alice@example.com"},
+		{"\talice@example.com\n", "alice@example.com"},
+		// Provider-specific aliases are distinct addresses
+		{"alice+wallet@example.com", "alice+wallet@example.com"},
+		{"a.lice@gmail.com", "a.lice@gmail.com"},
+		{"ÉLODIE@example.com", "élodie@example.com"},
+		{"", ""},
+		{"   ", ""},
+	}
+	for _, test := range tests {
+		if normalized := NormalizeEmail(test.email); normalized != test.normalized {
+			t.Errorf("NormalizeEmail(%q) = %q, expected %q", test.email, normalized, test.normalized)
+		}
+		// Normalizing is idempotent: stored emails can be normalized again when looked up
+		if twice := NormalizeEmail(NormalizeEmail(test.email)); twice != test.normalized {
+			t.Errorf("NormalizeEmail isn't idempotent for %q: got %q", test.email, twice)
+		}
+	}
+}
+
+func TestMigrateEmailNormalizationIgnoresDeletedUsers(t *testing.T) {
+	connectTestDatabase(t)
+	domain := fmt.Sprintf("migration-%d.example.com", time.Now().UnixNano())
+
+	// A deleted user whose email only differs by case from an active user's
+	deleted := User{Email: "Bob@" + domain}
+	active := User{Email: "BOB@" + domain}
+	for _, user := range []*User{&deleted, &active} {
+		if err := db.Database.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Database.Unscoped().Where("email ILIKE ?", "%@"+domain).Delete(&User{})
+	})
+	if err := db.Database.Delete(&deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	collisions, err := FindEmailCollisions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, collision := range collisions {
+		if collision.NormalizedEmail == "bob@"+domain {
+			t.Fatalf("deleted users shouldn't collide: %+v", collision)
+		}
+	}
+	if err := MigrateEmailNormalization(); err != nil {
+		t.Fatal(err)
+	}
+
+	var emails []string
+	if err := db.Database.Unscoped().Model(&User{}).Where("email ILIKE ?", "%@"+domain).Order("id").Pluck("email", &emails).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 2 || emails[0] != "Bob@"+domain || emails[1] != "bob@"+domain {
+		t.Errorf("expected only the active user's email to be normalized, got %v", emails)
+	}
+
+	if len(collisions) == 0 {
+		// The index only covers active users
+		if err := db.Database.Create(&User{Email: "bOB@" + domain}).Error; err == nil {
+			t.Error("expected the index to reject an active user with the same normalized email")
+		}
+	}
+}
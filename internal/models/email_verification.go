@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A pending email verification. Users have at most one: sending a new code replaces the previous one.
+type EmailVerification struct {
+	gorm.Model
+	UserID uint `gorm:"not null;unique"`
+	// Address the code was sent to. Verification only counts if the user's email didn't change in the meantime.
+	Email string `gorm:"size:255;not null"`
+	// SHA-256 of the code. Codes are never stored in clear.
+	CodeHash  string    `gorm:"size:64;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	Attempts  int       `gorm:"not null;default:0"`
+}
+
+// Creates or replaces the pending verification of a user
+func SaveEmailVerification(verification *EmailVerification) error {
+	err := db.Database.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "code_hash", "expires_at", "attempts", "created_at", "updated_at"}),
+	}).Create(verification).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to save email verification for user %d", verification.UserID)
+	}
+	return nil
+}
+
+func FindEmailVerificationForUser(userId uint) (*EmailVerification, error) {
+	var verification EmailVerification
+	err := db.Database.Where("user_id=?", userId).First(&verification).Error
+	if err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
+
+func IncrementEmailVerificationAttempts(verification *EmailVerification) error {
+	err := db.Database.Model(verification).Update("attempts", gorm.Expr("attempts + 1")).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to record attempt for email verification %d", verification.ID)
+	}
+	return nil
+}
+
+func DeleteEmailVerificationForUser(userId uint) error {
+	return db.Database.Unscoped().Where("user_id=?", userId).Delete(&EmailVerification{}).Error
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+// Per-user notification settings. Users without a row get the defaults (see GetNotificationPreferenceForUser).
+type NotificationPreference struct {
+	gorm.Model
+	UserID        uint           `gorm:"not null;unique"`
+	EmailDeposits bool           `gorm:"not null"`
+	WebhookUrl    sql.NullString `gorm:"size:2048;default:null"`
+	WebhookSecret string         `gorm:"size:255"`
+}
+
+func GetNotificationPreferenceForUser(userId uint) (*NotificationPreference, error) {
+	var preference NotificationPreference
+	err := db.Database.Where("user_id=?", userId).Limit(1).Find(&preference).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while fetching notification preferences for user %d", userId)
+	}
+	if preference.ID == 0 {
+		// Defaults: deposits are notified by email (once the address is verified), no webhook
+		return &NotificationPreference{
+			UserID:        userId,
+			EmailDeposits: true,
+		}, nil
+	}
+	return &preference, nil
+}
+
+func SaveNotificationPreference(preference *NotificationPreference) error {
+	return db.Database.Save(preference).Error
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+const BATCH_PAYMENT_STATUS_CONSTRUCTED = "constructed"
+const BATCH_PAYMENT_STATUS_BROADCAST = "broadcast"
+const BATCH_PAYMENT_STATUS_FAILED = "failed"
+
+// Payments uploaded together as a CSV file. Each payment is its own transaction, with sequential nonces.
+type PaymentBatch struct {
+	gorm.Model
+	UserID   uint           `gorm:"not null;index" json:"-"`
+	Source   string         `gorm:"size:255;not null" json:"source"` // sending address
+	Payments []BatchPayment `gorm:"foreignKey:BatchID" json:"payments"`
+}
+
+// One row of a batch. Once broadcast, its confirmation is tracked through the Transaction with the same hash.
+type BatchPayment struct {
+	gorm.Model
+	BatchID     uint   `gorm:"not null;index" json:"-"`
+	Line        int    `gorm:"not null" json:"line"` // in the uploaded CSV file
+	Destination string `gorm:"size:255;not null" json:"destination"`
+	// As entered, in ETH or token units
+	Amount string `gorm:"size:255;not null" json:"amount"`
+	// In wei or token base units
+	Value string `gorm:"size:78;not null" json:"value"`
+	// ERC-20 token address, empty for ETH
+	Token               string `gorm:"size:255" json:"token"`
+	Memo                string `gorm:"size:255" json:"memo"`
+	Nonce               uint64 `gorm:"not null" json:"nonce"`
+	UnsignedTransaction string `gorm:"type:text;not null" json:"unsignedTransaction"`
+	Status              string `gorm:"size:32;not null" json:"status"`
+	Hash                string `gorm:"size:255" json:"hash"`
+	Error               string `gorm:"type:text" json:"error"`
+}
+
+func CreatePaymentBatch(batch *PaymentBatch) error {
+	for i := range batch.Payments {
+		batch.Payments[i].Status = BATCH_PAYMENT_STATUS_CONSTRUCTED
+	}
+	if err := db.Database.Create(batch).Error; err != nil {
+		return errors.Wrapf(err, "unable to create payment batch for user %d", batch.UserID)
+	}
+	return nil
+}
+
+// Returns gorm.ErrRecordNotFound if the batch doesn't exist or belongs to another user. Payments are in nonce order.
+func FindPaymentBatchForUser(userId uint, batchId uint) (*PaymentBatch, error) {
+	var batch PaymentBatch
+	err := db.Database.
+		Preload("Payments", func(tx *gorm.DB) *gorm.DB { return tx.Order("nonce") }).
+		Where("id=? AND user_id=?", batchId, userId).
+		First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// Lists a user's batches with their payments, most recent first
+func ListPaymentBatches(userId uint, limit int) ([]PaymentBatch, error) {
+	var batches []PaymentBatch
+	err := db.Database.
+		Preload("Payments", func(tx *gorm.DB) *gorm.DB { return tx.Order("nonce") }).
+		Where("user_id=?", userId).
+		Order("id desc").
+		Limit(limit).
+		Find(&batches).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list payment batches of user %d", userId)
+	}
+	return batches, nil
+}
+
+// Records the outcome of submitting a payment: its hash once broadcast, or why it failed
+func UpdateBatchPayment(payment *BatchPayment) error {
+	err := db.Database.Model(payment).Updates(map[string]interface{}{
+		"status": payment.Status,
+		"hash":   payment.Hash,
+		"error":  payment.Error,
+	}).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to update batch payment %d", payment.ID)
+	}
+	return nil
+}
+
+// Returns the transactions with the given hashes, by hash
+func FindTransactionsByHashes(hashes []string) (map[string]Transaction, error) {
+	transactions := map[string]Transaction{}
+	if len(hashes) == 0 {
+		return transactions, nil
+	}
+	var found []Transaction
+	if err := db.Database.Where("hash IN ?", hashes).Find(&found).Error; err != nil {
+		return nil, errors.Wrap(err, "unable to look up transactions")
+	}
+	for _, tx := range found {
+		transactions[tx.Hash] = tx
+	}
+	return transactions, nil
+}
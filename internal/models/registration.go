@@ -0,0 +1,233 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Registrations move through these states, in order. Only FAILED registrations (and STARTED ones) can be restarted
+// with a new passkey: once a sub-organization is requested, only the original attestation (same challenge) can resume it.
+const (
+	// The user exists locally, nothing was sent to Turnkey yet
+	REGISTRATION_STATE_STARTED = "STARTED"
+	// The CREATE_SUB_ORGANIZATION activity is being submitted
+	REGISTRATION_STATE_SUBMITTING = "SUBMITTING"
+	// The activity was submitted (ActivityId is set) and we're waiting for it to complete
+	REGISTRATION_STATE_REQUESTED = "REQUESTED"
+	// The sub-organization exists and is linked to the user. The wallet and passkey still have to be saved locally.
+	REGISTRATION_STATE_CREATED   = "CREATED"
+	REGISTRATION_STATE_COMPLETED = "COMPLETED"
+	// Turnkey rejected the sub-organization: no sub-organization exists for this registration
+	REGISTRATION_STATE_FAILED = "FAILED"
+)
+
+var ErrEmailAlreadyRegistered = errors.New("this email is already registered")
+var ErrRegistrationInProgress = errors.New("a registration is already in progress for this email")
+
+// Returned when a transition lost a race: another request (or the reconciliation job) moved the registration first
+var ErrRegistrationStateChanged = errors.New("registration state changed concurrently")
+
+// Tracks the progress of a user's registration, so that partial failures can be resumed or cleaned up
+type Registration struct {
+	gorm.Model
+	UserID            uint   `gorm:"not null;unique"`
+	User              User   `gorm:"constraint:OnDelete:CASCADE"`
+	State             string `gorm:"size:32;not null;index"`
+	Challenge         string `gorm:"size:1024;not null"`
+	CredentialId      string `gorm:"size:1024;not null"`
+	AuthenticatorName string `gorm:"size:255;not null"`
+	// Comma-separated Turnkey transports for the registration passkey
+	Transports        string         `gorm:"size:255"`
+	ActivityId        sql.NullString `gorm:"size:255;index"`
+	SubOrganizationId sql.NullString `gorm:"size:255"`
+	WalletId          sql.NullString `gorm:"size:255"`
+	EthereumAddress   sql.NullString `gorm:"size:255"`
+	LastError         string         `gorm:"type:text"`
+}
+
+// Creates the user and its registration, or returns the existing registration if it can be resumed.
+// Registrations without a sub-organization request (STARTED or FAILED) are restarted with the new passkey.
+// Registrations further along are only resumed for the same challenge.
+func BeginRegistration(email string, registration Registration) (*Registration, error) {
+	email = NormalizeEmail(email)
+	if email == "" {
+		return nil, errors.New("expected non-empty email to begin registration")
+	}
+
+	err := db.Database.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Where("lower(email)=?", email).Order("id").First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			registration.User = User{Email: email}
+			registration.State = REGISTRATION_STATE_STARTED
+			return tx.Create(&registration).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		var existing Registration
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id=?", user.ID).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Users registered before registrations were tracked
+			if user.SubOrganizationId.Valid {
+				return ErrEmailAlreadyRegistered
+			}
+			registration.UserID = user.ID
+			registration.State = REGISTRATION_STATE_STARTED
+			if err := tx.Create(&registration).Error; err != nil {
+				return err
+			}
+			registration.User = user
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case existing.State == REGISTRATION_STATE_COMPLETED:
+			return ErrEmailAlreadyRegistered
+		case existing.State == REGISTRATION_STATE_STARTED || existing.State == REGISTRATION_STATE_FAILED:
+			existing.State = REGISTRATION_STATE_STARTED
+			existing.Challenge = registration.Challenge
+			existing.CredentialId = registration.CredentialId
+			existing.AuthenticatorName = registration.AuthenticatorName
+			existing.Transports = registration.Transports
+			existing.ActivityId = sql.NullString{}
+			existing.LastError = ""
+			if err := tx.Save(&existing).Error; err != nil {
+				return err
+			}
+			registration = existing
+		case existing.Challenge == registration.Challenge:
+			registration = existing
+		default:
+			return ErrRegistrationInProgress
+		}
+		registration.User = user
+		return nil
+	})
+	if err != nil {
+		if err == ErrEmailAlreadyRegistered || err == ErrRegistrationInProgress {
+			return nil, err
+		}
+		return nil, errors.Wrapf(err, "unable to begin registration for %s", email)
+	}
+	return &registration, nil
+}
+
+// Moves a registration to a new state if it's still in one of the expected states, with the same passkey.
+// Fields set on the registration (ActivityId, LastError, sub-organization details) are saved along with the state.
+func TransitionRegistration(registration *Registration, from []string, to string) error {
+	result := db.Database.Model(&Registration{}).
+		Where("id=? AND challenge=? AND state IN ?", registration.ID, registration.Challenge, from).
+		Updates(map[string]interface{}{
+			"state":               to,
+			"activity_id":         registration.ActivityId,
+			"sub_organization_id": registration.SubOrganizationId,
+			"wallet_id":           registration.WalletId,
+			"ethereum_address":    registration.EthereumAddress,
+			"last_error":          registration.LastError,
+			"updated_at":          time.Now(),
+		})
+	if result.Error != nil {
+		return errors.Wrapf(result.Error, "unable to move registration %d to %s", registration.ID, to)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRegistrationStateChanged
+	}
+	registration.State = to
+	return nil
+}
+
+// Records the sub-organization on both the registration and its user, atomically
+func CompleteRegistrationSubOrganization(registration *Registration, subOrganizationId, walletId, ethereumAddress string) error {
+	return db.Database.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Registration{}).
+			Where("id=? AND state=?", registration.ID, REGISTRATION_STATE_REQUESTED).
+			Updates(map[string]interface{}{
+				"state":               REGISTRATION_STATE_CREATED,
+				"sub_organization_id": subOrganizationId,
+				"wallet_id":           walletId,
+				"ethereum_address":    ethereumAddress,
+				"last_error":          "",
+				"updated_at":          time.Now(),
+			})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to record sub-organization for registration %d", registration.ID)
+		}
+		if result.RowsAffected == 0 {
+			return ErrRegistrationStateChanged
+		}
+		err := tx.Model(&User{}).Where("id=?", registration.UserID).Update("sub_organization_id", subOrganizationId).Error
+		if err != nil {
+			return errors.Wrapf(err, "unable to link sub-organization %s to user %d", subOrganizationId, registration.UserID)
+		}
+
+		registration.State = REGISTRATION_STATE_CREATED
+		registration.SubOrganizationId = sql.NullString{String: subOrganizationId, Valid: true}
+		registration.WalletId = sql.NullString{String: walletId, Valid: true}
+		registration.EthereumAddress = sql.NullString{String: ethereumAddress, Valid: true}
+		registration.User.SubOrganizationId = registration.SubOrganizationId
+		return nil
+	})
+}
+
+// Lists registrations which aren't completed and haven't moved since the given time
+func ListStaleRegistrations(updatedBefore time.Time) ([]Registration, error) {
+	var registrations []Registration
+	err := db.Database.Preload("User").
+		Where("state <> ? AND updated_at < ?", REGISTRATION_STATE_COMPLETED, updatedBefore).
+		Order("id").
+		Find(&registrations).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list stale registrations")
+	}
+	return registrations, nil
+}
+
+func FindRegistrationForUser(userId uint) (*Registration, error) {
+	var registration Registration
+	err := db.Database.Preload("User").Where("user_id=?", userId).First(&registration).Error
+	if err != nil {
+		return nil, err
+	}
+	return &registration, nil
+}
+
+// Lists users created before registrations were tracked which never got a sub-organization
+func ListUntrackedUsersWithoutSubOrganization(createdBefore time.Time) ([]User, error) {
+	var users []User
+	err := db.Database.
+		Where("sub_organization_id IS NULL AND created_at < ?", createdBefore).
+		Where("NOT EXISTS (SELECT 1 FROM registrations WHERE registrations.user_id = users.id)").
+		Find(&users).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list users without sub-organization")
+	}
+	return users, nil
+}
+
+// Deletes a user who never got a sub-organization, along with its registration, to free up its email.
+// Users with a sub-organization are never deleted by this function.
+func ReleaseAbandonedUser(userId uint) error {
+	return db.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id=?", userId).Delete(&Registration{}).Error; err != nil {
+			return errors.Wrapf(err, "unable to delete registration for user %d", userId)
+		}
+		result := tx.Unscoped().Where("id=? AND sub_organization_id IS NULL", userId).Delete(&User{})
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "unable to delete user %d", userId)
+		}
+		if result.RowsAffected == 0 {
+			return ErrRegistrationStateChanged
+		}
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+// Spending rules of a user, enforced by our backend on every transaction it builds, forwards or co-signs.
+// Amounts are in wei (decimal strings, empty for no limit). Address lists are comma-separated checksummed addresses.
+type SpendingPolicy struct {
+	gorm.Model
+	UserID         uint   `gorm:"not null;unique" json:"-"`
+	MaxTransaction string `gorm:"size:78" json:"maxTransaction"`
+	// Over any 24 hours
+	DailyLimit string `gorm:"size:78" json:"dailyLimit"`
+	// When set, only these addresses can receive funds
+	Allowlist string `gorm:"type:text" json:"allowlist"`
+	Denylist  string `gorm:"type:text" json:"denylist"`
+	// When set, contract calls can only target these token contracts
+	AllowedTokens string `gorm:"type:text" json:"allowedTokens"`
+}
+
+// A Turnkey policy created in a user's sub-organization to mirror their spending policy
+type TurnkeyPolicy struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null;index" json:"-"`
+	PolicyID  string `gorm:"size:255;not null" json:"policyId"`
+	Name      string `gorm:"size:255;not null" json:"name"`
+	Effect    string `gorm:"size:32;not null" json:"effect"`
+	Condition string `gorm:"type:text;not null" json:"condition"`
+}
+
+// Returns nil if the user has no spending policy
+func FindSpendingPolicy(userId uint) (*SpendingPolicy, error) {
+	var policies []SpendingPolicy
+	err := db.Database.Where("user_id=?", userId).Limit(1).Find(&policies).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up spending policy of user %d", userId)
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	return &policies[0], nil
+}
+
+// Creates or replaces a user's spending policy
+func SaveSpendingPolicy(policy *SpendingPolicy) error {
+	existing, err := FindSpendingPolicy(policy.UserID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		policy.ID = existing.ID
+		policy.CreatedAt = existing.CreatedAt
+	}
+	if err := db.Database.Save(policy).Error; err != nil {
+		return errors.Wrapf(err, "unable to save spending policy of user %d", policy.UserID)
+	}
+	return nil
+}
+
+// Namespace of the advisory locks taken by LockSpending (the second key is the user ID)
+const SPENDING_LOCK_NAMESPACE = 1
+
+// Serializes spending checks per user: the lock must be held from SumTransactionValueSince until the transaction it
+// allowed is recorded, or concurrent requests would all pass against the same total. Waits for the lock if another
+// request holds it. The returned function releases it, and must be called (it holds a database connection until then).
+func LockSpending(userId uint) (func(), error) {
+	tx := db.Database.Begin()
+	if tx.Error != nil {
+		return nil, errors.Wrapf(tx.Error, "unable to lock spending of user %d", userId)
+	}
+	// Transaction-level advisory locks go away with the transaction, so nothing stays locked if the connection drops
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?::int, ?::int)", SPENDING_LOCK_NAMESPACE, userId).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(err, "unable to lock spending of user %d", userId)
+	}
+	return func() { tx.Rollback() }, nil
+}
+
+// Sums the value (in wei) of transactions a user sent through our backend since a given time. Failed transactions don't count.
+func SumTransactionValueSince(userId uint, since time.Time) (*big.Int, error) {
+	var amounts []string
+	err := db.Database.Model(&Transaction{}).
+		Where("user_id=? AND created_at>=? AND status<>?", userId, since, TRANSACTION_STATUS_FAILED).
+		Pluck("amount", &amounts).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to sum transactions of user %d", userId)
+	}
+	total := new(big.Int)
+	for _, amount := range amounts {
+		value, ok := new(big.Int).SetString(amount, 10)
+		if !ok {
+			return nil, errors.Errorf("invalid transaction amount %q", amount)
+		}
+		total.Add(total, value)
+	}
+	return total, nil
+}
+
+func RecordTurnkeyPolicy(policy *TurnkeyPolicy) error {
+	if err := db.Database.Create(policy).Error; err != nil {
+		return errors.Wrapf(err, "unable to record Turnkey policy %s of user %d", policy.PolicyID, policy.UserID)
+	}
+	return nil
+}
+
+func ListTurnkeyPolicies(userId uint) ([]TurnkeyPolicy, error) {
+	var policies []TurnkeyPolicy
+	err := db.Database.Where("user_id=?", userId).Order("id").Find(&policies).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list Turnkey policies of user %d", userId)
+	}
+	return policies, nil
+}
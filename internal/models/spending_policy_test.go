@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockSpending(t *testing.T) {
+	connectTestDatabase(t)
+	const userId = 4242
+
+	unlock, err := LockSpending(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlockSecond, err := LockSpending(userId)
+		if err != nil {
+			t.Error(err)
+			close(locked)
+			return
+		}
+		locked <- unlockSecond
+	}()
+
+	// Other users aren't affected
+	unlockOther, err := LockSpending(userId + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockOther()
+
+	select {
+	case <-locked:
+		t.Fatal("expected the second lock to wait for the first one")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case unlockSecond, ok := <-locked:
+		if ok {
+			unlockSecond()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second lock to be acquired once the first one was released")
+	}
+}
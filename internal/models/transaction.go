@@ -0,0 +1,57 @@
+package models
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+const TRANSACTION_STATUS_PENDING = "pending"
+const TRANSACTION_STATUS_CONFIRMED = "confirmed"
+const TRANSACTION_STATUS_FAILED = "failed"
+
+// Represents a transaction broadcast by our backend on behalf of a user.
+// We keep track of these to report their status as they get mined.
+type Transaction struct {
+	gorm.Model
+	UserID      uint          `gorm:"not null;index" json:"-"`
+	Hash        string        `gorm:"size:255;not null;unique" json:"hash"`
+	Source      string        `gorm:"size:255;not null" json:"source"`
+	Destination string        `gorm:"size:255;not null" json:"destination"`
+	Amount      string        `gorm:"size:255;not null" json:"amount"` // in wei
+	Nonce       uint64        `json:"nonce"`
+	Status      string        `gorm:"size:32;not null;index" json:"status"`
+	Block       sql.NullInt64 `json:"block"`
+}
+
+func RecordBroadcastTransaction(tx *Transaction) error {
+	if tx.Hash == "" {
+		return errors.New("cannot record a transaction without hash")
+	}
+	tx.Status = TRANSACTION_STATUS_PENDING
+	return db.Database.Create(tx).Error
+}
+
+func ListPendingTransactions() ([]Transaction, error) {
+	var transactions []Transaction
+	err := db.Database.Where("status=?", TRANSACTION_STATUS_PENDING).Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func UpdateTransactionStatus(tx *Transaction, status string, block uint64) error {
+	tx.Status = status
+	tx.Block = sql.NullInt64{Int64: int64(block), Valid: true}
+	err := db.Database.Model(tx).Updates(map[string]interface{}{
+		"status": tx.Status,
+		"block":  tx.Block,
+	}).Error
+	if err != nil {
+		return errors.Wrapf(err, "error while updating status of transaction %s to %q", tx.Hash, status)
+	}
+	return nil
+}
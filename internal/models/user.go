@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tkhq/demo-passkey-wallet/internal/db"
@@ -13,9 +14,12 @@ type User struct {
 	gorm.Model
 	Email             string         `gorm:"size:255;not null;unique" json:"email"`
 	SubOrganizationId sql.NullString `gorm:"size:255;unique;default:null" json:"subOrganizationId"`
+	// Set once the user proved they own their email. Email recovery and email auth require a verified email.
+	VerifiedAt sql.NullTime `json:"verifiedAt"`
 }
 
 func CreateUser(email string) (*User, error) {
+	email = NormalizeEmail(email)
 	if email == "" {
 		return nil, errors.New("expected non-empty email to create user")
 	}
@@ -33,16 +37,17 @@ func CreateUser(email string) (*User, error) {
 
 func FindUserByEmail(email string) (User, error) {
 	var user User
-	err := db.Database.Where("email=?", email).First(&user).Error
+	err := db.Database.Where("lower(email)=?", NormalizeEmail(email)).Order("id").First(&user).Error
 	if err != nil {
 		return User{}, err
 	}
 	return user, nil
 }
 
+// Returns gorm.ErrRecordNotFound for unknown (or deleted) users
 func FindUserById(userId uint) (User, error) {
 	var user User
-	err := db.Database.Where("id=?", userId).Find(&user).Error
+	err := db.Database.Where("id=?", userId).First(&user).Error
 	if err != nil {
 		return User{}, err
 	}
@@ -58,6 +63,16 @@ func FindUserBySubOrganizationId(subOrganizationId string) (User, error) {
 	return user, nil
 }
 
+// Returns true if a user, deleted or not, was ever linked to the sub-organization
+func IsSubOrganizationLinked(subOrganizationId string) (bool, error) {
+	var count int64
+	err := db.Database.Unscoped().Model(&User{}).Where("sub_organization_id=?", subOrganizationId).Count(&count).Error
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to look up users of sub-organization %s", subOrganizationId)
+	}
+	return count > 0, nil
+}
+
 // Given an internal user name, return the name of that user on the Turnkey side
 // We prefix all end-users with "wallet-user-" for convenience
 func (user *User) TurnkeyName() string {
@@ -84,3 +99,15 @@ func UpdateUserTurnkeySubOrganization(userId uint, subOrganizationId string) (*U
 	}
 	return &user, nil
 }
+
+func (user *User) IsVerified() bool {
+	return user.VerifiedAt.Valid
+}
+
+func MarkUserVerified(userId uint) error {
+	err := db.Database.Model(&User{}).Where("id=?", userId).Update("verified_at", time.Now()).Error
+	if err != nil {
+		return errors.Wrapf(err, "unable to mark user %d as verified", userId)
+	}
+	return nil
+}
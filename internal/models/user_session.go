@@ -0,0 +1,114 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+// A login session. The session cookie carries the token; this row is what lets users see and revoke their sessions.
+type UserSession struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;index" json:"-"`
+	Token  string `gorm:"size:64;not null;unique" json:"-"`
+	// Device information, as reported by the browser
+	UserAgent string `gorm:"size:512" json:"userAgent"`
+	// IP address the session was last seen from
+	IpAddress  string       `gorm:"size:64" json:"ipAddress"`
+	LastSeenAt time.Time    `gorm:"not null" json:"lastSeenAt"`
+	ExpiresAt  time.Time    `gorm:"not null;index" json:"expiresAt"`
+	RevokedAt  sql.NullTime `json:"-"`
+	// Last time the user re-authenticated with a passkey in this session (see the stepup package)
+	SteppedUpAt sql.NullTime `json:"steppedUpAt"`
+}
+
+func CreateUserSession(session *UserSession) error {
+	if err := db.Database.Create(session).Error; err != nil {
+		return errors.Wrapf(err, "unable to create session for user %d", session.UserID)
+	}
+	return nil
+}
+
+func FindUserSessionByToken(token string) (*UserSession, error) {
+	var session UserSession
+	err := db.Database.Where("token=?", token).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func TouchUserSession(session *UserSession, ipAddress string) error {
+	session.LastSeenAt = time.Now()
+	session.IpAddress = ipAddress
+	return db.Database.Model(session).Updates(map[string]interface{}{
+		"last_seen_at": session.LastSeenAt,
+		"ip_address":   session.IpAddress,
+	}).Error
+}
+
+func RecordUserSessionStepUp(session *UserSession) error {
+	session.SteppedUpAt.Time, session.SteppedUpAt.Valid = time.Now(), true
+	if err := db.Database.Model(session).Update("stepped_up_at", session.SteppedUpAt).Error; err != nil {
+		return errors.Wrapf(err, "unable to record step-up on session %d", session.ID)
+	}
+	return nil
+}
+
+// Lists sessions which are neither revoked nor past their absolute expiry, most recently used first
+func ListActiveUserSessions(userId uint) ([]UserSession, error) {
+	var sessions []UserSession
+	err := db.Database.
+		Where("user_id=? AND revoked_at IS NULL AND expires_at > ?", userId, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list sessions of user %d", userId)
+	}
+	return sessions, nil
+}
+
+// Revokes one of a user's sessions. Returns gorm.ErrRecordNotFound if the user has no such active session.
+func RevokeUserSession(userId uint, sessionId uint) error {
+	result := db.Database.Model(&UserSession{}).
+		Where("id=? AND user_id=? AND revoked_at IS NULL", sessionId, userId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return errors.Wrapf(result.Error, "unable to revoke session %d", sessionId)
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Revokes all sessions of a user, except the one with the given token (pass "" to revoke them all).
+// Returns the number of sessions revoked.
+func RevokeUserSessions(userId uint, exceptToken string) (int64, error) {
+	result := db.Database.Model(&UserSession{}).
+		Where("user_id=? AND revoked_at IS NULL AND token <> ?", userId, exceptToken).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, errors.Wrapf(result.Error, "unable to revoke sessions of user %d", userId)
+	}
+	return result.RowsAffected, nil
+}
+
+// Deletes sessions which expired (or were revoked) before the given time or haven't been used since idleBefore,
+// along with expired rows of the cookie session store's "sessions" table. Returns the number of rows deleted.
+func DeleteExpiredUserSessions(before time.Time, idleBefore time.Time) (int64, error) {
+	result := db.Database.Unscoped().
+		Where("expires_at < ? OR revoked_at < ? OR last_seen_at < ?", before, before, idleBefore).
+		Delete(&UserSession{})
+	if result.Error != nil {
+		return 0, errors.Wrap(result.Error, "unable to delete expired sessions")
+	}
+	storeResult := db.Database.Exec("DELETE FROM sessions WHERE expires_at <= ?", time.Now())
+	if storeResult.Error != nil {
+		return 0, errors.Wrap(storeResult.Error, "unable to delete expired rows from the session store")
+	}
+	return result.RowsAffected + storeResult.RowsAffected, nil
+}
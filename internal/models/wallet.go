@@ -1,6 +1,8 @@
 package models
 
 import (
+	"strings"
+
 	"github.com/tkhq/demo-passkey-wallet/internal/db"
 	"gorm.io/gorm"
 )
@@ -52,3 +54,21 @@ func RecordDropForWallet(pk *Wallet) error {
 	dropsCount := pk.Drops + 1
 	return db.Database.Find(pk).Update("drops", dropsCount).Error
 }
+
+// Finds wallets (along with their users) for a list of addresses. Matching is case-insensitive.
+func FindWalletsByAddresses(addresses []string) ([]Wallet, error) {
+	var wallets []Wallet
+	if len(addresses) == 0 {
+		return wallets, nil
+	}
+
+	lowercased := make([]string, len(addresses))
+	for i, address := range addresses {
+		lowercased[i] = strings.ToLower(address)
+	}
+	err := db.Database.Preload("User").Where("lower(ethereum_address) IN ?", lowercased).Find(&wallets).Error
+	if err != nil {
+		return nil, err
+	}
+	return wallets, nil
+}
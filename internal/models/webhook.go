@@ -0,0 +1,254 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"gorm.io/gorm"
+)
+
+const WEBHOOK_DELIVERY_STATUS_PENDING = "pending"
+const WEBHOOK_DELIVERY_STATUS_SUCCEEDED = "succeeded"
+const WEBHOOK_DELIVERY_STATUS_DEAD = "dead"
+
+// Subscribing to this event type means subscribing to all events
+const WEBHOOK_ALL_EVENTS = "*"
+
+// An integrator endpoint receiving wallet events. Managed through admin endpoints.
+type WebhookSubscription struct {
+	gorm.Model
+	Url        string `gorm:"size:2048;not null" json:"url"`
+	Secret     string `gorm:"size:255;not null" json:"-"`
+	EventTypes string `gorm:"size:1024;not null" json:"eventTypes"` // comma-separated, or "*"
+	Active     bool   `gorm:"not null" json:"active"`
+}
+
+// One event to deliver to one subscription. Retried until it succeeds or runs out of attempts.
+type WebhookDelivery struct {
+	gorm.Model
+	SubscriptionID uint                `gorm:"not null;index" json:"subscriptionId"`
+	Subscription   WebhookSubscription `json:"-"`
+	EventID        string              `gorm:"size:255;not null;index" json:"eventId"`
+	EventType      string              `gorm:"size:255;not null" json:"eventType"`
+	Payload        string              `gorm:"type:text;not null" json:"payload"`
+	Status         string              `gorm:"size:32;not null;index" json:"status"`
+	Attempts       int                 `gorm:"not null" json:"attempts"`
+	NextAttemptAt  time.Time           `gorm:"not null;index" json:"nextAttemptAt"`
+}
+
+// Log of every delivery attempt
+type WebhookDeliveryAttempt struct {
+	gorm.Model
+	DeliveryID uint          `gorm:"not null;index" json:"deliveryId"`
+	StatusCode sql.NullInt32 `json:"statusCode"`
+	Error      string        `gorm:"type:text" json:"error"`
+	DurationMs int64         `json:"durationMs"`
+}
+
+// Deliveries which exhausted their retries end up here, until an admin replays them.
+type WebhookDeadLetter struct {
+	gorm.Model
+	DeliveryID uint         `gorm:"not null;unique" json:"deliveryId"`
+	LastError  string       `gorm:"type:text" json:"lastError"`
+	ReplayedAt sql.NullTime `json:"replayedAt"`
+}
+
+func (s *WebhookSubscription) Accepts(eventType string) bool {
+	for _, t := range strings.Split(s.EventTypes, ",") {
+		t = strings.TrimSpace(t)
+		if t == WEBHOOK_ALL_EVENTS || t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func CreateWebhookSubscription(url, secret string, eventTypes []string) (*WebhookSubscription, error) {
+	if len(eventTypes) == 0 {
+		return nil, errors.New("expected at least one event type to create a webhook subscription")
+	}
+	subscription := WebhookSubscription{
+		Url:        url,
+		Secret:     secret,
+		EventTypes: strings.Join(eventTypes, ","),
+		Active:     true,
+	}
+	err := db.Database.Create(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+func ListWebhookSubscriptions() ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	err := db.Database.Order("id").Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func ListActiveWebhookSubscriptions() ([]WebhookSubscription, error) {
+	var subscriptions []WebhookSubscription
+	err := db.Database.Where("active=?", true).Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func SetWebhookSubscriptionActive(subscriptionId uint, active bool) error {
+	result := db.Database.Model(&WebhookSubscription{}).Where("id=?", subscriptionId).Update("active", active)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func CreateWebhookDelivery(delivery *WebhookDelivery) error {
+	delivery.Status = WEBHOOK_DELIVERY_STATUS_PENDING
+	if delivery.NextAttemptAt.IsZero() {
+		delivery.NextAttemptAt = time.Now()
+	}
+	return db.Database.Create(delivery).Error
+}
+
+func FindWebhookDeliveryById(deliveryId uint) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	err := db.Database.Where("id=?", deliveryId).First(&delivery).Error
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Lists deliveries, most recent first. An empty status lists all of them.
+func ListWebhookDeliveries(status string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	query := db.Database.Order("id desc").Limit(limit)
+	if status != "" {
+		query = query.Where("status=?", status)
+	}
+	err := query.Find(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+func ListWebhookDeliveryAttempts(deliveryId uint) ([]WebhookDeliveryAttempt, error) {
+	var attempts []WebhookDeliveryAttempt
+	err := db.Database.Where("delivery_id=?", deliveryId).Order("id").Find(&attempts).Error
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// Claims up to `limit` deliveries due for an attempt, by pushing their next attempt `lease` into the future.
+// The conditional update makes this safe when several processes work on the same table.
+func ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]WebhookDelivery, error) {
+	var due []WebhookDelivery
+	err := db.Database.Preload("Subscription").
+		Where("status=? AND next_attempt_at<=?", WEBHOOK_DELIVERY_STATUS_PENDING, time.Now()).
+		Order("next_attempt_at").Limit(limit).Find(&due).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var claimed []WebhookDelivery
+	for _, delivery := range due {
+		leaseUntil := time.Now().Add(lease)
+		result := db.Database.Model(&WebhookDelivery{}).
+			Where("id=? AND status=? AND next_attempt_at=?", delivery.ID, WEBHOOK_DELIVERY_STATUS_PENDING, delivery.NextAttemptAt).
+			Update("next_attempt_at", leaseUntil)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			delivery.NextAttemptAt = leaseUntil
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed, nil
+}
+
+// Records the outcome of a delivery attempt. Failed attempts are rescheduled at `retryAt`,
+// or moved to the dead-letter table when `retryAt` is nil.
+func RecordWebhookDeliveryAttempt(delivery *WebhookDelivery, attempt WebhookDeliveryAttempt, retryAt *time.Time) error {
+	return db.Database.Transaction(func(tx *gorm.DB) error {
+		attempt.DeliveryID = delivery.ID
+		if err := tx.Create(&attempt).Error; err != nil {
+			return errors.Wrap(err, "error while recording webhook delivery attempt")
+		}
+
+		delivery.Attempts++
+		updates := map[string]interface{}{"attempts": delivery.Attempts}
+		switch {
+		case attempt.Error == "":
+			delivery.Status = WEBHOOK_DELIVERY_STATUS_SUCCEEDED
+		case retryAt != nil:
+			delivery.NextAttemptAt = *retryAt
+			updates["next_attempt_at"] = delivery.NextAttemptAt
+		default:
+			delivery.Status = WEBHOOK_DELIVERY_STATUS_DEAD
+			deadLetter := WebhookDeadLetter{DeliveryID: delivery.ID, LastError: attempt.Error}
+			if err := tx.Create(&deadLetter).Error; err != nil {
+				return errors.Wrap(err, "error while recording webhook dead letter")
+			}
+		}
+		updates["status"] = delivery.Status
+
+		return tx.Model(delivery).Updates(updates).Error
+	})
+}
+
+func ListWebhookDeadLetters(includeReplayed bool) ([]WebhookDeadLetter, error) {
+	var deadLetters []WebhookDeadLetter
+	query := db.Database.Order("id desc")
+	if !includeReplayed {
+		query = query.Where("replayed_at IS NULL")
+	}
+	err := query.Find(&deadLetters).Error
+	if err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
+
+// Replaying a delivery creates a new pending delivery with the same subscription and payload.
+// The original delivery and its attempts are kept as-is for the record; its dead letter (if any) is marked as replayed.
+func ReplayWebhookDelivery(deliveryId uint) (*WebhookDelivery, error) {
+	original, err := FindWebhookDeliveryById(deliveryId)
+	if err != nil {
+		return nil, err
+	}
+
+	replay := WebhookDelivery{
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		Status:         WEBHOOK_DELIVERY_STATUS_PENDING,
+		NextAttemptAt:  time.Now(),
+	}
+	err = db.Database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&replay).Error; err != nil {
+			return err
+		}
+		return tx.Model(&WebhookDeadLetter{}).
+			Where("delivery_id=? AND replayed_at IS NULL", original.ID).
+			Update("replayed_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while replaying webhook delivery %d", deliveryId)
+	}
+	return &replay, nil
+}
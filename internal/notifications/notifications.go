@@ -0,0 +1,106 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/mailer"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/webhooks"
+)
+
+type DepositNotification struct {
+	UserID  uint   `json:"userId"`
+	Address string `json:"address"`
+	Source  string `json:"source"`
+	Amount  string `json:"amount"`
+	Hash    string `json:"hash"`
+	Block   int64  `json:"block"`
+}
+
+// Starts watching new blocks for deposits into any of our wallets. Must be called after ethereum.StartBlockWatcher and mailer.Init.
+func Start() {
+	blocks, _ := ethereum.Blocks.Subscribe()
+	go func() {
+		for block := range blocks {
+			processBlock(block)
+		}
+	}()
+}
+
+func processBlock(block *types.Block) {
+	transfers := ethereum.ExternalTransfers(block)
+	if len(transfers) == 0 {
+		return
+	}
+
+	destinations := make([]string, 0, len(transfers))
+	for _, transfer := range transfers {
+		destinations = append(destinations, transfer.Destination)
+	}
+	wallets, err := models.FindWalletsByAddresses(destinations)
+	if err != nil {
+		log.Printf("unable to look up wallets for block %d: %s", block.NumberU64(), err.Error())
+		return
+	}
+
+	for _, wallet := range wallets {
+		for _, transfer := range transfers {
+			if transfer.Destination != strings.ToLower(wallet.EthereumAddress) {
+				continue
+			}
+			// Notifications involve slow network calls (SMTP, webhooks). Don't hold up block processing.
+			go notifyDeposit(context.Background(), wallet, DepositNotification{
+				UserID:  wallet.User.ID,
+				Address: wallet.EthereumAddress,
+				Source:  transfer.Source,
+				Amount:  ethereum.FormatEth(transfer.Amount),
+				Hash:    transfer.Hash,
+				Block:   int64(transfer.Block),
+			})
+		}
+	}
+}
+
+func notifyDeposit(ctx context.Context, wallet models.Wallet, deposit DepositNotification) {
+	webhooks.Emit(webhooks.EVENT_DEPOSIT_RECEIVED, deposit)
+
+	preference, err := models.GetNotificationPreferenceForUser(wallet.User.ID)
+	if err != nil {
+		log.Print(err.Error())
+		return
+	}
+
+	// Unverified addresses may belong to someone else: they don't get emails
+	if preference.EmailDeposits && wallet.User.IsVerified() {
+		subject := fmt.Sprintf("You received %s ETH", deposit.Amount)
+		body := fmt.Sprintf(
+			"Your Demo Passkey Wallet (%s) received %s Sepolia ETH from %s.\n\nTransaction: https://sepolia.etherscan.io/tx/%s\n",
+			deposit.Address, deposit.Amount, deposit.Source, deposit.Hash,
+		)
+		if err := mailer.Client.Send(wallet.User.Email, subject, body); err != nil {
+			log.Printf("unable to send deposit email for transaction %s: %s", deposit.Hash, err.Error())
+		}
+	}
+
+	if preference.WebhookUrl.Valid {
+		payload, err := webhooks.NewPayload(webhooks.EVENT_DEPOSIT_RECEIVED, deposit)
+		if err != nil {
+			log.Printf("unable to create deposit webhook payload: %s", err.Error())
+			return
+		}
+		serializedPayload, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("unable to serialize deposit webhook payload: %s", err.Error())
+			return
+		}
+		if _, err := webhooks.Deliver(ctx, preference.WebhookUrl.String, preference.WebhookSecret, serializedPayload); err != nil {
+			log.Printf("unable to deliver deposit webhook for transaction %s: %s", deposit.Hash, err.Error())
+		}
+	}
+}
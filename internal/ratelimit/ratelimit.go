@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How often expired windows are dropped from memory
+const CLEANUP_INTERVAL = 5 * time.Minute
+
+// Allows up to Limit requests per key (e.g. client IP) in fixed windows of Window.
+// Counters live in memory: limits apply per process, which is good enough for a single-instance deployment.
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu       sync.Mutex
+	counters map[string]*counter
+}
+
+type counter struct {
+	count       int
+	windowStart time.Time
+}
+
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	l := &Limiter{
+		Limit:    limit,
+		Window:   window,
+		counters: map[string]*counter{},
+	}
+	go l.cleanup()
+	return l
+}
+
+// Reads a "<count>/<window>" limit (e.g. "20/1m") from an environment variable, falling back to the given default
+func NewLimiterFromEnv(name string, defaultLimit int, defaultWindow time.Duration) (*Limiter, error) {
+	configured := os.Getenv(name)
+	if configured == "" {
+		return NewLimiter(defaultLimit, defaultWindow), nil
+	}
+	limitPart, windowPart, _ := strings.Cut(configured, "/")
+	limit, err := strconv.Atoi(limitPart)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid %s (%q): expected <count>/<window>, e.g. 20/1m", name, configured)
+	}
+	window, err := time.ParseDuration(windowPart)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid %s (%q): expected <count>/<window>, e.g. 20/1m", name, configured)
+	}
+	return NewLimiter(limit, window), nil
+}
+
+// Records a request for key. Returns false, along with how long to wait, when the key is over its limit.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c, ok := l.counters[key]
+	if !ok || now.Sub(c.windowStart) >= l.Window {
+		l.counters[key] = &counter{count: 1, windowStart: now}
+		return true, 0
+	}
+	if c.count >= l.Limit {
+		return false, c.windowStart.Add(l.Window).Sub(now)
+	}
+	c.count++
+	return true, 0
+}
+
+// Limits requests per client IP. Requests over the limit get a 429 with a Retry-After header.
+func (l *Limiter) Middleware(ctx *gin.Context) {
+	allowed, retryAfter := l.Allow(ctx.ClientIP())
+	if !allowed {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, "too many requests, try again later")
+		return
+	}
+	ctx.Next()
+}
+
+func (l *Limiter) cleanup() {
+	ticker := time.NewTicker(CLEANUP_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		l.mu.Lock()
+		now := time.Now()
+		for key, c := range l.counters {
+			if now.Sub(c.windowStart) >= l.Window {
+				delete(l.counters, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,209 @@
+package registration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/turnkey"
+)
+
+const DEFAULT_RECONCILIATION_INTERVAL = 10 * time.Minute
+
+// Incomplete registrations are left alone for this long: the user's own request may still be working on them
+const RECONCILIATION_GRACE_PERIOD = 5 * time.Minute
+
+// Users who never got a sub-organization are deleted after this long, freeing up their email
+const ABANDONED_REGISTRATION_TTL = 24 * time.Hour
+
+// Timeout for each reconciliation run
+const RECONCILIATION_TIMEOUT = 2 * time.Minute
+
+// A sub-organization created in Turnkey which isn't linked to any local user
+type OrphanedSubOrganization struct {
+	SubOrganizationId string `json:"subOrganizationId"`
+	ActivityId        string `json:"activityId"`
+	UserEmail         string `json:"userEmail"`
+}
+
+// What a reconciliation run found and did
+type Report struct {
+	// Registrations which were moved to completion
+	Completed []uint `json:"completed"`
+	// Registrations marked as failed because their submission was interrupted
+	Failed []uint `json:"failed"`
+	// Emails of abandoned registrations which were deleted
+	Released []string `json:"released"`
+	// Registrations which are still waiting on Turnkey, or couldn't be resumed this time
+	Pending []uint `json:"pending"`
+	// Sub-organizations matched with (and linked to) the incomplete registration of their email and passkey
+	Adopted []OrphanedSubOrganization `json:"adopted"`
+	// Sub-organizations without local user which need manual attention
+	Orphaned []OrphanedSubOrganization `json:"orphaned"`
+}
+
+// Reads REGISTRATION_RECONCILIATION_INTERVAL (Go duration). Defaults to DEFAULT_RECONCILIATION_INTERVAL.
+func ReconciliationIntervalFromEnv() (time.Duration, error) {
+	configured := os.Getenv("REGISTRATION_RECONCILIATION_INTERVAL")
+	if configured == "" {
+		return DEFAULT_RECONCILIATION_INTERVAL, nil
+	}
+	interval, err := time.ParseDuration(configured)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid REGISTRATION_RECONCILIATION_INTERVAL (%q): expected a positive duration", configured)
+	}
+	return interval, nil
+}
+
+// Periodically reconciles registrations in the background. Must be called after turnkey.Init.
+func StartReconciliation(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), RECONCILIATION_TIMEOUT)
+			report, err := Reconcile(ctx)
+			cancel()
+			if err != nil {
+				log.Printf("registration reconciliation failed: %s", err.Error())
+			} else if len(report.Completed)+len(report.Failed)+len(report.Released)+len(report.Adopted)+len(report.Orphaned) > 0 {
+				log.Printf("registration reconciliation: %+v", *report)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Finds sub-organizations without local user, and users without sub-organization, and repairs what can be repaired:
+//   - sub-organizations created for the email and passkey of an incomplete registration are linked to it
+//   - registrations waiting on Turnkey (or on local writes) are resumed
+//   - registrations interrupted while submitting to Turnkey are marked as failed, so users can start over
+//   - users without sub-organization are deleted once abandoned
+func Reconcile(ctx context.Context) (*Report, error) {
+	report := &Report{}
+
+	if err := adoptOrphanedSubOrganizations(ctx, report); err != nil {
+		return nil, err
+	}
+
+	registrations, err := models.ListStaleRegistrations(time.Now().Add(-RECONCILIATION_GRACE_PERIOD))
+	if err != nil {
+		return nil, err
+	}
+	for i := range registrations {
+		registration := &registrations[i]
+		switch registration.State {
+		case models.REGISTRATION_STATE_REQUESTED, models.REGISTRATION_STATE_CREATED:
+			if err := Resume(ctx, registration); err != nil {
+				log.Printf("unable to resume registration %d: %s", registration.ID, err.Error())
+				report.Pending = append(report.Pending, registration.ID)
+				continue
+			}
+			report.Completed = append(report.Completed, registration.ID)
+		case models.REGISTRATION_STATE_SUBMITTING:
+			registration.LastError = "submission to Turnkey was interrupted"
+			if err := models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_SUBMITTING}, models.REGISTRATION_STATE_FAILED); err != nil {
+				log.Printf("unable to mark registration %d as failed: %s", registration.ID, err.Error())
+				continue
+			}
+			report.Failed = append(report.Failed, registration.ID)
+		case models.REGISTRATION_STATE_STARTED, models.REGISTRATION_STATE_FAILED:
+			if registration.UpdatedAt.After(time.Now().Add(-ABANDONED_REGISTRATION_TTL)) {
+				continue
+			}
+			if err := models.ReleaseAbandonedUser(registration.UserID); err != nil {
+				log.Printf("unable to release abandoned registration %d: %s", registration.ID, err.Error())
+				continue
+			}
+			report.Released = append(report.Released, registration.User.Email)
+		}
+	}
+
+	// Users created before registrations were tracked
+	untrackedUsers, err := models.ListUntrackedUsersWithoutSubOrganization(time.Now().Add(-ABANDONED_REGISTRATION_TTL))
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range untrackedUsers {
+		if err := models.ReleaseAbandonedUser(user.ID); err != nil {
+			log.Printf("unable to release user %d without sub-organization: %s", user.ID, err.Error())
+			continue
+		}
+		report.Released = append(report.Released, user.Email)
+	}
+
+	return report, nil
+}
+
+func adoptOrphanedSubOrganizations(ctx context.Context, report *Report) error {
+	created, err := turnkey.Client.ListCreatedSubOrganizations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, subOrganization := range created {
+		// Sub-organizations of deleted accounts aren't orphans: they must never be adopted again
+		retired, err := models.IsSubOrganizationRetired(subOrganization.SubOrganizationId)
+		if err != nil {
+			return err
+		}
+		linked, err := models.IsSubOrganizationLinked(subOrganization.SubOrganizationId)
+		if err != nil {
+			return err
+		}
+		if retired || linked {
+			continue
+		}
+
+		orphan := OrphanedSubOrganization{
+			SubOrganizationId: subOrganization.SubOrganizationId,
+			ActivityId:        subOrganization.ActivityId,
+			UserEmail:         subOrganization.UserEmail,
+		}
+		if adoptSubOrganization(subOrganization) {
+			report.Adopted = append(report.Adopted, orphan)
+		} else {
+			report.Orphaned = append(report.Orphaned, orphan)
+		}
+	}
+	return nil
+}
+
+// Points the incomplete registration for the sub-organization's email at the activity which created it.
+// The registration is then resumed like any other REQUESTED registration.
+// The email isn't enough to adopt a sub-organization: it must have been created with the registration's own passkey.
+func adoptSubOrganization(subOrganization turnkey.CreatedSubOrganization) bool {
+	user, err := models.FindUserByEmail(subOrganization.UserEmail)
+	if err != nil || user.SubOrganizationId.Valid {
+		return false
+	}
+	registration, err := models.FindRegistrationForUser(user.ID)
+	if err != nil {
+		return false
+	}
+	if !isCreatedForRegistration(subOrganization, registration) {
+		return false
+	}
+
+	registration.ActivityId = sql.NullString{String: subOrganization.ActivityId, Valid: true}
+	registration.LastError = ""
+	err = models.TransitionRegistration(registration, []string{
+		models.REGISTRATION_STATE_STARTED,
+		models.REGISTRATION_STATE_SUBMITTING,
+		models.REGISTRATION_STATE_FAILED,
+	}, models.REGISTRATION_STATE_REQUESTED)
+	if err != nil {
+		log.Printf("unable to adopt sub-organization %s for registration %d: %s", subOrganization.SubOrganizationId, registration.ID, err.Error())
+		return false
+	}
+	return true
+}
+
+func isCreatedForRegistration(subOrganization turnkey.CreatedSubOrganization, registration *models.Registration) bool {
+	return subOrganization.Challenge != "" && subOrganization.Challenge == registration.Challenge &&
+		subOrganization.CredentialId != "" && subOrganization.CredentialId == registration.CredentialId
+}
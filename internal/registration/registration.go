@@ -0,0 +1,243 @@
+package registration
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/turnkey"
+	"github.com/tkhq/demo-passkey-wallet/internal/types"
+	"github.com/tkhq/demo-passkey-wallet/internal/verification"
+	"github.com/tkhq/demo-passkey-wallet/internal/webhooks"
+	turnkeymodels "github.com/tkhq/go-sdk/pkg/api/models"
+	"gorm.io/gorm"
+)
+
+// Registration runs as a saga: every step is recorded on a models.Registration so that a failure half-way through
+// can be resumed (by the user retrying, or by the reconciliation job) instead of leaving a user without sub-organization
+// or a sub-organization without user behind.
+type Params struct {
+	Email             string
+	Attestation       types.Attestation
+	Challenge         string
+	AuthenticatorName string
+	Transports        []turnkeymodels.AuthenticatorTransport
+	// Optional code sent by verification.SendClaim, to replace an unverified account using the email
+	ClaimCode string
+}
+
+// Starts a new registration, or resumes an incomplete one for the same email and challenge.
+// Returns models.ErrEmailAlreadyRegistered or models.ErrRegistrationInProgress when the email can't be registered now.
+func Run(ctx context.Context, params Params) (*models.Registration, error) {
+	params.Email = models.NormalizeEmail(params.Email)
+	claimed := false
+	if params.ClaimCode != "" {
+		replaced, err := reclaimEmail(params.Email, params.ClaimCode)
+		if err != nil {
+			return nil, err
+		}
+		if replaced != nil {
+			claimed = true
+			// The replaced sub-organization stays with whoever holds its passkeys, but our co-signer must not keep acting in it
+			if _, err := turnkey.Client.RemoveCoSigner(ctx, replaced.SubOrganizationId.String); err != nil {
+				log.Printf("unable to remove co-signer from sub-organization %s: %s", replaced.SubOrganizationId.String, err.Error())
+			}
+		}
+	}
+
+	registration, err := models.BeginRegistration(params.Email, models.Registration{
+		Challenge:         params.Challenge,
+		CredentialId:      params.Attestation.CredentialId,
+		AuthenticatorName: params.AuthenticatorName,
+		Transports:        joinTransports(params.Transports),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if registration.State == models.REGISTRATION_STATE_STARTED {
+		if err := requestSubOrganization(ctx, registration, params); err != nil {
+			return nil, err
+		}
+	}
+	if err := Resume(ctx, registration); err != nil {
+		return nil, err
+	}
+	// The claim code proved control of the email
+	if claimed {
+		if err := models.MarkUserVerified(registration.UserID); err != nil {
+			log.Printf("unable to mark user %d as verified: %s", registration.UserID, err.Error())
+		} else {
+			registration.User.VerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+	}
+	return registration, nil
+}
+
+// Deletes the unverified account using an email, once the claim code proves control of the email, and returns it.
+// Returns nil if there is no such account (anymore): the registration goes on as usual.
+func reclaimEmail(email, claimCode string) (*models.User, error) {
+	user, err := models.FindUnverifiedAccountByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verification.CheckClaim(email, claimCode); err != nil {
+		return nil, err
+	}
+	if err := models.DeleteAccount(user.ID, models.RETIREMENT_REASON_EMAIL_CLAIMED); err != nil {
+		return nil, err
+	}
+	log.Printf("unverified user %d was replaced by a new registration for the same email", user.ID)
+	webhooks.Emit(webhooks.EVENT_ACCOUNT_DELETED, map[string]interface{}{
+		"userId":            user.ID,
+		"subOrganizationId": user.SubOrganizationId.String,
+		"reason":            models.RETIREMENT_REASON_EMAIL_CLAIMED,
+	})
+	return &user, nil
+}
+
+// Moves a registration forward from its current state until it's completed.
+// Registrations which were never submitted to Turnkey can't be resumed: the attestation isn't stored.
+func Resume(ctx context.Context, registration *models.Registration) error {
+	for {
+		var err error
+		switch registration.State {
+		case models.REGISTRATION_STATE_COMPLETED:
+			return nil
+		case models.REGISTRATION_STATE_REQUESTED:
+			err = awaitSubOrganization(ctx, registration)
+		case models.REGISTRATION_STATE_CREATED:
+			err = completeRegistration(ctx, registration)
+		case models.REGISTRATION_STATE_FAILED:
+			return errors.Errorf("registration %d failed: %s", registration.ID, registration.LastError)
+		default:
+			return errors.Errorf("registration %d can't be resumed from state %s", registration.ID, registration.State)
+		}
+		if err != nil {
+			if err == models.ErrRegistrationStateChanged {
+				return models.ErrRegistrationInProgress
+			}
+			return err
+		}
+	}
+}
+
+func requestSubOrganization(ctx context.Context, registration *models.Registration, params Params) error {
+	if err := models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_STARTED}, models.REGISTRATION_STATE_SUBMITTING); err != nil {
+		if err == models.ErrRegistrationStateChanged {
+			return models.ErrRegistrationInProgress
+		}
+		return err
+	}
+
+	activityId, err := turnkey.Client.RequestUserSubOrganization(ctx, params.Email, params.Attestation, params.Challenge, params.AuthenticatorName)
+	if err != nil {
+		// Turnkey may have received the request anyway. The reconciliation job adopts the sub-organization if it shows up.
+		registration.LastError = err.Error()
+		if transitionErr := models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_SUBMITTING}, models.REGISTRATION_STATE_FAILED); transitionErr != nil {
+			log.Printf("unable to mark registration %d as failed: %s", registration.ID, transitionErr.Error())
+		}
+		return err
+	}
+
+	registration.ActivityId.String, registration.ActivityId.Valid = activityId, true
+	return models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_SUBMITTING}, models.REGISTRATION_STATE_REQUESTED)
+}
+
+func awaitSubOrganization(ctx context.Context, registration *models.Registration) error {
+	result, err := turnkey.Client.GetUserSubOrganizationResult(ctx, registration.ActivityId.String)
+	if err != nil {
+		var failedErr *turnkey.ActivityFailedError
+		var rejectedErr *turnkey.ActivityRejectedError
+		if errors.As(err, &failedErr) || errors.As(err, &rejectedErr) {
+			registration.LastError = err.Error()
+			if transitionErr := models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_REQUESTED}, models.REGISTRATION_STATE_FAILED); transitionErr != nil {
+				log.Printf("unable to mark registration %d as failed: %s", registration.ID, transitionErr.Error())
+			}
+		}
+		// Anything else (timeouts, network errors) leaves the registration in REQUESTED, ready to be resumed
+		return err
+	}
+
+	if err := models.CompleteRegistrationSubOrganization(registration, result.SubOrganizationId, result.WalletId, result.EthereumAddress); err != nil {
+		return err
+	}
+	webhooks.Emit(webhooks.EVENT_SUB_ORGANIZATION_CREATED, map[string]interface{}{
+		"userId":            registration.UserID,
+		"subOrganizationId": result.SubOrganizationId,
+		"walletId":          result.WalletId,
+		"address":           result.EthereumAddress,
+	})
+	return nil
+}
+
+// Saves the wallet and passkey locally. Both steps are safe to repeat.
+func completeRegistration(ctx context.Context, registration *models.Registration) error {
+	user := &registration.User
+	wallet, err := models.GetWalletForUser(*user)
+	if err != nil {
+		return errors.Wrapf(err, "unable to look up wallet for user %d", user.ID)
+	}
+	if wallet.ID == 0 {
+		wallet, err = models.SaveWalletForUser(user, registration.WalletId.String, registration.EthereumAddress.String)
+		if err != nil {
+			return errors.Wrapf(err, "unable to save wallet for user %d", user.ID)
+		}
+		log.Printf("Wallet account successfully saved: %+v", wallet)
+	}
+
+	var transports []turnkeymodels.AuthenticatorTransport
+	for _, t := range strings.Split(registration.Transports, ",") {
+		if t != "" {
+			transports = append(transports, turnkeymodels.AuthenticatorTransport(t))
+		}
+	}
+	SaveLocalAuthenticator(ctx, user.ID, registration.SubOrganizationId.String, registration.CredentialId, registration.AuthenticatorName, transports)
+
+	if err := models.TransitionRegistration(registration, []string{models.REGISTRATION_STATE_CREATED}, models.REGISTRATION_STATE_COMPLETED); err != nil {
+		return err
+	}
+	webhooks.Emit(webhooks.EVENT_USER_REGISTERED, map[string]interface{}{
+		"userId":            user.ID,
+		"email":             user.Email,
+		"subOrganizationId": registration.SubOrganizationId.String,
+	})
+	return nil
+}
+
+// Records a newly registered passkey locally. The AAGUID is assigned by Turnkey when it verifies the attestation, so we look it up.
+// Failures are logged: the passkey exists in Turnkey regardless, and the listing endpoint falls back to Turnkey's metadata.
+func SaveLocalAuthenticator(ctx context.Context, userId uint, organizationId, credentialId, name string, transports []turnkeymodels.AuthenticatorTransport) {
+	authenticator := models.Authenticator{
+		UserID:       userId,
+		CredentialId: credentialId,
+		Name:         name,
+		Transports:   joinTransports(transports),
+	}
+
+	turnkeyAuthenticator, err := turnkey.Client.FindAuthenticatorByCredentialId(ctx, organizationId, credentialId)
+	if err != nil {
+		log.Printf("unable to look up authenticator %s in organization %s: %s", credentialId, organizationId, err.Error())
+	} else if turnkeyAuthenticator != nil && turnkeyAuthenticator.Aaguid != nil {
+		authenticator.Aaguid = *turnkeyAuthenticator.Aaguid
+	}
+
+	if err := models.SaveAuthenticator(&authenticator); err != nil {
+		log.Printf("unable to save authenticator %s locally: %s", credentialId, err.Error())
+	}
+}
+
+func joinTransports(transports []turnkeymodels.AuthenticatorTransport) string {
+	names := []string{}
+	for _, t := range transports {
+		names = append(names, string(t))
+	}
+	return strings.Join(names, ",")
+}
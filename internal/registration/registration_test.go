@@ -0,0 +1,144 @@
+package registration
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"github.com/tkhq/demo-passkey-wallet/internal/mailer"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/verification"
+)
+
+// Keeps the last email sent
+type testMailer struct {
+	body string
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.body = body
+	return nil
+}
+
+var claimCode = regexp.MustCompile(`code ([0-9]{6})`)
+
+// These tests need a Postgres database: set TEST_DATABASE_URL to run them
+func connectTestDatabase(t *testing.T) {
+	databaseUrl := os.Getenv("TEST_DATABASE_URL")
+	if databaseUrl == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	t.Setenv("DATABASE_URL", databaseUrl)
+	db.Connect()
+	err := db.Database.AutoMigrate(&models.User{}, &models.Wallet{}, &models.Transaction{}, &models.NotificationPreference{}, &models.Authenticator{},
+		&models.Registration{}, &models.EmailVerification{}, &models.EmailClaim{}, &models.UserSession{}, &models.Contact{}, &models.SpendingPolicy{},
+		&models.TurnkeyPolicy{}, &models.PaymentBatch{}, &models.BatchPayment{}, &models.RetiredSubOrganization{}, &models.WebhookSubscription{},
+		&models.WebhookDelivery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func createCompletedRegistration(t *testing.T, email string, verified bool) models.User {
+	user := models.User{
+		Email:             email,
+		SubOrganizationId: sql.NullString{String: fmt.Sprintf("sub-org-%d", time.Now().UnixNano()), Valid: true},
+	}
+	if verified {
+		user.VerifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	registration := models.Registration{User: user, State: models.REGISTRATION_STATE_COMPLETED, Challenge: "squatter-challenge", CredentialId: "squatter-credential"}
+	if err := db.Database.Create(&registration).Error; err != nil {
+		t.Fatal(err)
+	}
+	return registration.User
+}
+
+func TestReclaimUnverifiedEmail(t *testing.T) {
+	connectTestDatabase(t)
+	sentEmails := &testMailer{}
+	mailer.Client = sentEmails
+
+	email := fmt.Sprintf("owner-%d@example.com", time.Now().UnixNano())
+	squatter := createCompletedRegistration(t, email, false)
+
+	// Without a claim, the email stays locked
+	_, err := models.BeginRegistration(email, models.Registration{Challenge: "owner-challenge", CredentialId: "owner-credential"})
+	if err != models.ErrEmailAlreadyRegistered {
+		t.Fatalf("expected ErrEmailAlreadyRegistered, got %v", err)
+	}
+
+	if err := verification.SendClaim(email); err != nil {
+		t.Fatal(err)
+	}
+	match := claimCode.FindStringSubmatch(sentEmails.body)
+	if match == nil {
+		t.Fatalf("no code in claim email: %q", sentEmails.body)
+	}
+
+	// Wrong codes don't release anything
+	wrongCode := "000000"
+	if match[1] == wrongCode {
+		wrongCode = "000001"
+	}
+	if _, err := reclaimEmail(email, wrongCode); err != verification.ErrInvalidCode {
+		t.Fatalf("expected ErrInvalidCode, got %v", err)
+	}
+	if _, err := models.FindUserById(squatter.ID); err != nil {
+		t.Fatalf("expected the unverified account to remain after a wrong code: %v", err)
+	}
+
+	replaced, err := reclaimEmail(email, match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced == nil || replaced.ID != squatter.ID {
+		t.Fatalf("expected user %d to be replaced, got %+v", squatter.ID, replaced)
+	}
+	retired, err := models.IsSubOrganizationRetired(squatter.SubOrganizationId.String)
+	if err != nil || !retired {
+		t.Fatalf("expected the unverified account's sub-organization to be retired (%v)", err)
+	}
+	if _, err := models.FindUserById(squatter.ID); err == nil {
+		t.Fatal("expected the unverified account to be deleted")
+	}
+	// Codes are single-use
+	if err := verification.CheckClaim(email, match[1]); err != verification.ErrNoPendingVerification {
+		t.Fatal("expected the claim code to be consumed")
+	}
+
+	registration, err := models.BeginRegistration(email, models.Registration{Challenge: "owner-challenge", CredentialId: "owner-credential"})
+	if err != nil {
+		t.Fatalf("expected the owner to be able to register: %v", err)
+	}
+	if registration.UserID == squatter.ID || registration.State != models.REGISTRATION_STATE_STARTED {
+		t.Fatalf("unexpected registration %+v", registration)
+	}
+}
+
+func TestVerifiedEmailCantBeClaimed(t *testing.T) {
+	connectTestDatabase(t)
+	sentEmails := &testMailer{}
+	mailer.Client = sentEmails
+
+	email := fmt.Sprintf("verified-%d@example.com", time.Now().UnixNano())
+	owner := createCompletedRegistration(t, email, true)
+
+	if err := verification.SendClaim(email); err != nil {
+		t.Fatal(err)
+	}
+	if sentEmails.body != "" {
+		t.Fatal("expected no claim email for a verified account")
+	}
+	replaced, err := reclaimEmail(email, "123456")
+	if err != nil || replaced != nil {
+		t.Fatalf("expected nothing to claim, got (%+v, %v)", replaced, err)
+	}
+	if _, err := models.FindUserById(owner.ID); err != nil {
+		t.Fatalf("expected the verified account to remain: %v", err)
+	}
+}
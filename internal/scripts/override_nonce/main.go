@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"log"
@@ -15,12 +16,17 @@ import (
 )
 
 func main() {
+	ctx := context.Background()
+
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatalf("Error loading .env file: %s", err.Error())
 	}
 
-	ethereum.Init()
+	err = ethereum.Init()
+	if err != nil {
+		log.Fatalf("Unable to initialize Ethereum RPC client: %+v", err)
+	}
 
 	err = turnkey.Init(
 		os.Getenv("TURNKEY_API_HOST"),
@@ -31,7 +37,7 @@ func main() {
 		log.Fatalf("Unable to initialize Turnkey client: %+v", err)
 	}
 
-	userID, err := turnkey.Client.Whoami()
+	userID, err := turnkey.Client.Whoami(ctx)
 	if err != nil {
 		log.Fatalf("Unable to use Turnkey client for whoami request: %+v", err)
 	}
@@ -41,7 +47,7 @@ func main() {
 	if turnkeyWarchestOrganizationId == "" || turnkeyWarchestPrivateKeyId == "" || err != nil {
 		log.Fatal("Cannot find configuration for Turnkey Warchest org or private key ID! Drop functionality depends on it")
 	}
-	turnkeyWarchestPrivateKeyAddress, err := turnkey.Client.GetEthereumAddress(turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId)
+	turnkeyWarchestPrivateKeyAddress, err := turnkey.Client.GetEthereumAddress(ctx, turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId)
 	if err != nil {
 		log.Fatalf("Unable to get Turnkey Warchest address: %s", err.Error())
 	}
@@ -55,18 +61,18 @@ func main() {
 	}
 
 	// Self-transfer
-	zeroValueTx, err := ethereum.ConstructTransfer(turnkeyWarchestPrivateKeyAddress, turnkeyWarchestPrivateKeyAddress, big.NewInt(0), &nonce)
+	zeroValueTx, err := ethereum.ConstructTransfer(ctx, turnkeyWarchestPrivateKeyAddress, turnkeyWarchestPrivateKeyAddress, big.NewInt(0), &nonce)
 	if err != nil {
 		log.Fatalf(errors.Wrap(err, "unable to construct dummy transfer").Error())
 	}
 
-	signedTx, err := turnkey.Client.SignTransaction(turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId, hex.EncodeToString(zeroValueTx))
+	signedTx, err := turnkey.Client.SignTransaction(ctx, turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId, hex.EncodeToString(zeroValueTx))
 	if err != nil {
 		log.Fatalf(errors.Wrap(err, "unable to sign dummy transfer").Error())
 		return
 	}
 
-	txHash, err := ethereum.BroadcastTransaction(signedTx)
+	txHash, err := ethereum.BroadcastTransaction(ctx, signedTx)
 	if err != nil {
 		log.Fatalf(errors.Wrap(err, "unable to broadcast dummy transfer").Error())
 	}
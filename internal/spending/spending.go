@@ -0,0 +1,297 @@
+package spending
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	turnkeymodels "github.com/tkhq/go-sdk/pkg/api/models"
+)
+
+// Daily limits apply over this rolling window
+const DAILY_LIMIT_WINDOW = 24 * time.Hour
+
+// Rule names, reported in violations
+const RULE_MAX_TRANSACTION = "maxTransaction"
+const RULE_DAILY_LIMIT = "dailyLimit"
+const RULE_ALLOWLIST = "allowlist"
+const RULE_DENYLIST = "denylist"
+const RULE_ALLOWED_TOKENS = "allowedTokens"
+
+const tokenCallUnderLimitsMessage = "token transfers and approvals aren't allowed while the spending policy limits ETH amounts"
+
+// Token functions whose address arguments receive funds or spending rights. Allowlists and denylists apply to them
+// rather than to the token contract.
+var recipientArguments = map[string][]string{
+	"transfer(address,uint256)":                 {"to"},
+	"transferFrom(address,address,uint256)":     {"to"},
+	"safeTransferFrom(address,address,uint256)": {"to"},
+	"approve(address,uint256)":                  {"spender"},
+	"setApprovalForAll(address,bool)":           {"operator"},
+}
+
+// Parsed spending rules. Nil limits and empty lists don't restrict anything.
+// Limits only cover ETH value: token amounts aren't comparable with each other, so token calls are refused while
+// limits are set rather than let through unchecked.
+type Rules struct {
+	MaxTransaction *big.Int
+	DailyLimit     *big.Int
+	Allowlist      []common.Address
+	Denylist       []common.Address
+	AllowedTokens  []common.Address
+}
+
+// A transaction about to be signed
+type Transaction struct {
+	To    common.Address
+	Value *big.Int
+	Data  []byte
+}
+
+// Returned when a transaction breaks a rule
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// Parses the rules of a stored policy. A nil policy has no rules.
+func ParseRules(policy *models.SpendingPolicy) (*Rules, error) {
+	rules := &Rules{}
+	if policy == nil {
+		return rules, nil
+	}
+	var err error
+	if rules.MaxTransaction, err = parseWei(policy.MaxTransaction); err != nil {
+		return nil, errors.Wrap(err, "invalid maximum per transaction")
+	}
+	if rules.DailyLimit, err = parseWei(policy.DailyLimit); err != nil {
+		return nil, errors.Wrap(err, "invalid daily limit")
+	}
+	if rules.Allowlist, err = parseAddressList(policy.Allowlist); err != nil {
+		return nil, errors.Wrap(err, "invalid allowlist")
+	}
+	if rules.Denylist, err = parseAddressList(policy.Denylist); err != nil {
+		return nil, errors.Wrap(err, "invalid denylist")
+	}
+	if rules.AllowedTokens, err = parseAddressList(policy.AllowedTokens); err != nil {
+		return nil, errors.Wrap(err, "invalid allowed tokens")
+	}
+	return rules, nil
+}
+
+// Returns true if the rules don't restrict anything
+func (r *Rules) IsEmpty() bool {
+	return r.MaxTransaction == nil && r.DailyLimit == nil && len(r.Allowlist) == 0 && len(r.Denylist) == 0 && len(r.AllowedTokens) == 0
+}
+
+// Checks a transaction against the rules. spentInWindow is the value already sent within DAILY_LIMIT_WINDOW.
+// Returns a *Violation for the first rule the transaction breaks.
+func (r *Rules) Evaluate(tx Transaction, spentInWindow *big.Int) error {
+	value := tx.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+
+	if r.MaxTransaction != nil && value.Cmp(r.MaxTransaction) > 0 {
+		return &Violation{
+			Rule:    RULE_MAX_TRANSACTION,
+			Message: fmt.Sprintf("transactions are limited to %s ETH", formatEther(r.MaxTransaction)),
+		}
+	}
+	if r.DailyLimit != nil && new(big.Int).Add(spentInWindow, value).Cmp(r.DailyLimit) > 0 {
+		remaining := new(big.Int).Sub(r.DailyLimit, spentInWindow)
+		if remaining.Sign() < 0 {
+			remaining.SetInt64(0)
+		}
+		return &Violation{
+			Rule:    RULE_DAILY_LIMIT,
+			Message: fmt.Sprintf("this would exceed the daily limit of %s ETH (%s ETH left)", formatEther(r.DailyLimit), formatEther(remaining)),
+		}
+	}
+
+	if contains(r.Denylist, tx.To) {
+		return &Violation{Rule: RULE_DENYLIST, Message: fmt.Sprintf("%s is on the denylist", tx.To.Hex())}
+	}
+	recipients := []common.Address{tx.To}
+	if len(tx.Data) > 0 {
+		if len(r.AllowedTokens) > 0 && !contains(r.AllowedTokens, tx.To) {
+			return &Violation{Rule: RULE_ALLOWED_TOKENS, Message: fmt.Sprintf("contract calls to %s aren't allowed", tx.To.Hex())}
+		}
+		if tokenRecipients, ok := tokenCallRecipients(tx.Data); ok {
+			// Token calls carry no ETH value: they'd get around the limits entirely
+			if r.MaxTransaction != nil {
+				return &Violation{Rule: RULE_MAX_TRANSACTION, Message: tokenCallUnderLimitsMessage}
+			}
+			if r.DailyLimit != nil {
+				return &Violation{Rule: RULE_DAILY_LIMIT, Message: tokenCallUnderLimitsMessage}
+			}
+			recipients = tokenRecipients
+		}
+	}
+	for _, recipient := range recipients {
+		if contains(r.Denylist, recipient) {
+			return &Violation{Rule: RULE_DENYLIST, Message: fmt.Sprintf("%s is on the denylist", recipient.Hex())}
+		}
+		if len(r.Allowlist) > 0 && !contains(r.Allowlist, recipient) {
+			return &Violation{Rule: RULE_ALLOWLIST, Message: fmt.Sprintf("%s isn't on the allowlist", recipient.Hex())}
+		}
+	}
+	return nil
+}
+
+// Returns the addresses receiving funds or spending rights from a known token call
+func tokenCallRecipients(data []byte) ([]common.Address, bool) {
+	summary, err := ethereum.DecodeCall(nil, data)
+	if err != nil {
+		return nil, false
+	}
+	names, ok := recipientArguments[summary.Signature]
+	if !ok {
+		return nil, false
+	}
+	var recipients []common.Address
+	for _, argument := range summary.Arguments {
+		for _, name := range names {
+			if address, ok := argument.Value.(string); ok && argument.Name == name {
+				recipients = append(recipients, common.HexToAddress(address))
+			}
+		}
+	}
+	return recipients, true
+}
+
+// A Turnkey policy mirroring some of the rules, to be created with a CREATE_POLICY activity
+type TurnkeyPolicy struct {
+	PolicyName string               `json:"policyName"`
+	Effect     turnkeymodels.Effect `json:"effect"`
+	Condition  string               `json:"condition"`
+	Notes      string               `json:"notes"`
+}
+
+const signTransactionCondition = "activity.type == 'ACTIVITY_TYPE_SIGN_TRANSACTION_V2'"
+
+// Returns Turnkey policies mirroring the rules, along with the rules which can't be mirrored.
+// Turnkey evaluates policies for sub-organization users outside of the root quorum (e.g. approvers added later):
+// denies always win, and these users can only sign what an allow policy permits.
+func (r *Rules) TurnkeyPolicies() ([]TurnkeyPolicy, []string) {
+	policies := []TurnkeyPolicy{}
+	notMirrored := []string{}
+
+	if r.MaxTransaction != nil {
+		policies = append(policies, TurnkeyPolicy{
+			PolicyName: "Spending policy: maximum per transaction",
+			Effect:     turnkeymodels.EffectDeny,
+			Condition:  fmt.Sprintf("%s && eth.tx.value > %s", signTransactionCondition, r.MaxTransaction.String()),
+			Notes:      fmt.Sprintf("Transactions are limited to %s ETH", formatEther(r.MaxTransaction)),
+		})
+	}
+	if len(r.Denylist) > 0 {
+		policies = append(policies, TurnkeyPolicy{
+			PolicyName: "Spending policy: denylist",
+			Effect:     turnkeymodels.EffectDeny,
+			Condition:  fmt.Sprintf("%s && eth.tx.to in %s", signTransactionCondition, policyAddressList(r.Denylist)),
+			Notes:      "Transactions to these addresses are denied",
+		})
+	}
+
+	// Turnkey doesn't decode token calls: the allowlist applies to the transaction's destination only
+	var allowed []string
+	switch {
+	case len(r.Allowlist) > 0 && len(r.AllowedTokens) > 0:
+		allowed = append(allowed, fmt.Sprintf("(eth.tx.data == '0x' && eth.tx.to in %s)", policyAddressList(r.Allowlist)))
+		allowed = append(allowed, fmt.Sprintf("eth.tx.to in %s", policyAddressList(r.AllowedTokens)))
+	case len(r.Allowlist) > 0:
+		allowed = append(allowed, fmt.Sprintf("eth.tx.to in %s", policyAddressList(r.Allowlist)))
+	case len(r.AllowedTokens) > 0:
+		allowed = append(allowed, "eth.tx.data == '0x'")
+		allowed = append(allowed, fmt.Sprintf("eth.tx.to in %s", policyAddressList(r.AllowedTokens)))
+	}
+	if len(allowed) > 0 {
+		policies = append(policies, TurnkeyPolicy{
+			PolicyName: "Spending policy: allowed destinations",
+			Effect:     turnkeymodels.EffectAllow,
+			Condition:  fmt.Sprintf("%s && (%s)", signTransactionCondition, strings.Join(allowed, " || ")),
+			Notes:      "Transactions are only allowed to these destinations",
+		})
+	}
+	if len(r.Allowlist) > 0 {
+		notMirrored = append(notMirrored, "allowlist checks on token transfer recipients")
+	}
+	if r.DailyLimit != nil {
+		notMirrored = append(notMirrored, RULE_DAILY_LIMIT)
+	}
+	if r.MaxTransaction != nil || r.DailyLimit != nil {
+		notMirrored = append(notMirrored, "refusing token calls while ETH limits are set")
+	}
+	return policies, notMirrored
+}
+
+// Turnkey policy conditions compare lowercase addresses
+func policyAddressList(addresses []common.Address) string {
+	quoted := make([]string, len(addresses))
+	for i, address := range addresses {
+		quoted[i] = "'" + strings.ToLower(address.Hex()) + "'"
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+func parseWei(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	wei, ok := new(big.Int).SetString(s, 10)
+	if !ok || wei.Sign() < 0 {
+		return nil, fmt.Errorf("expected a number of wei. Got %q", s)
+	}
+	return wei, nil
+}
+
+func parseAddressList(s string) ([]common.Address, error) {
+	var addresses []common.Address
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		address, err := ethereum.ParseAddress(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid address %q", item)
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+// Joins addresses the way models.SpendingPolicy stores them
+func FormatAddressList(addresses []common.Address) string {
+	hex := make([]string, len(addresses))
+	for i, address := range addresses {
+		hex[i] = address.Hex()
+	}
+	return strings.Join(hex, ",")
+}
+
+func contains(addresses []common.Address, address common.Address) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats wei as ETH without losing precision, e.g. "0.015"
+func formatEther(wei *big.Int) string {
+	formatted := new(big.Rat).SetFrac(wei, big.NewInt(1e18)).FloatString(18)
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
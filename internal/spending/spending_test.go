@@ -0,0 +1,218 @@
+package spending
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	turnkeymodels "github.com/tkhq/go-sdk/pkg/api/models"
+)
+
+var friend = common.HexToAddress("0x00000000000000000000000000000000000f2e4d")
+var scammer = common.HexToAddress("0x0000000000000000000000000000000000005ca3")
+var stranger = common.HexToAddress("0x000000000000000000000000000000000000beef")
+var token = common.HexToAddress("0x0000000000000000000000000000000000000da1")
+var otherToken = common.HexToAddress("0x000000000000000000000000000000000000abcd")
+
+const tokenAbi = `[
+	{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]},
+	{"type":"function","name":"approve","inputs":[{"name":"spender","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}
+]`
+
+func tokenCall(t *testing.T, method string, recipient common.Address) []byte {
+	parsed, err := abi.JSON(strings.NewReader(tokenAbi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.Pack(method, recipient, big.NewInt(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func eth(t *testing.T, amount string) *big.Int {
+	wei, err := ethereum.ParseEth(amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wei
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(&models.SpendingPolicy{
+		MaxTransaction: "100000000000000000",
+		Allowlist:      friend.Hex() + ", " + strings.ToLower(stranger.Hex()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules.MaxTransaction.Cmp(eth(t, "0.1")) != 0 || rules.DailyLimit != nil {
+		t.Errorf("unexpected limits %v, %v", rules.MaxTransaction, rules.DailyLimit)
+	}
+	if len(rules.Allowlist) != 2 || rules.Allowlist[1] != stranger {
+		t.Errorf("unexpected allowlist %v", rules.Allowlist)
+	}
+
+	for _, policy := range []models.SpendingPolicy{
+		{MaxTransaction: "0.1"},
+		{DailyLimit: "-1"},
+		{Denylist: "0x1234"},
+		// Wrong EIP-55 checksum
+		{AllowedTokens: "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD"},
+	} {
+		if _, err := ParseRules(&policy); err == nil {
+			t.Errorf("expected policy %+v to be rejected", policy)
+		}
+	}
+
+	rules, err = ParseRules(nil)
+	if err != nil || !rules.IsEmpty() {
+		t.Errorf("expected a nil policy to have no rules, got %+v (%v)", rules, err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	oneWei := big.NewInt(1)
+	limit := eth(t, "0.1")
+	overLimit := new(big.Int).Add(limit, oneWei)
+
+	tests := []struct {
+		name  string
+		rules Rules
+		tx    Transaction
+		spent *big.Int
+		// Expected rule violated, "" if the transaction is allowed
+		rule string
+	}{
+		{"no rules", Rules{}, Transaction{To: stranger, Value: eth(t, "1000")}, big.NewInt(0), ""},
+		{"nil value", Rules{MaxTransaction: limit}, Transaction{To: stranger}, big.NewInt(0), ""},
+		{"equal to the maximum", Rules{MaxTransaction: limit}, Transaction{To: stranger, Value: eth(t, "0.1")}, big.NewInt(0), ""},
+		{"one wei over the maximum", Rules{MaxTransaction: limit}, Transaction{To: stranger, Value: overLimit}, big.NewInt(0), RULE_MAX_TRANSACTION},
+		{"reaching the daily limit", Rules{DailyLimit: limit}, Transaction{To: stranger, Value: eth(t, "0.04")}, eth(t, "0.06"), ""},
+		{"one wei over the daily limit", Rules{DailyLimit: limit}, Transaction{To: stranger, Value: new(big.Int).Add(eth(t, "0.04"), oneWei)}, eth(t, "0.06"), RULE_DAILY_LIMIT},
+		{"daily limit already exceeded", Rules{DailyLimit: limit}, Transaction{To: stranger, Value: oneWei}, eth(t, "0.2"), RULE_DAILY_LIMIT},
+		{"denylisted destination", Rules{Denylist: []common.Address{scammer}}, Transaction{To: scammer, Value: oneWei}, big.NewInt(0), RULE_DENYLIST},
+		{"denylisted token recipient", Rules{Denylist: []common.Address{scammer}}, Transaction{To: token, Data: tokenCall(t, "transfer", scammer)}, big.NewInt(0), RULE_DENYLIST},
+		{"denylisted token spender", Rules{Denylist: []common.Address{scammer}}, Transaction{To: token, Data: tokenCall(t, "approve", scammer)}, big.NewInt(0), RULE_DENYLIST},
+		{"token transfer to someone else", Rules{Denylist: []common.Address{scammer}}, Transaction{To: token, Data: tokenCall(t, "transfer", friend)}, big.NewInt(0), ""},
+		{"allowlisted destination", Rules{Allowlist: []common.Address{friend}}, Transaction{To: friend, Value: oneWei}, big.NewInt(0), ""},
+		{"destination not allowlisted", Rules{Allowlist: []common.Address{friend}}, Transaction{To: stranger, Value: oneWei}, big.NewInt(0), RULE_ALLOWLIST},
+		{"allowlisted token recipient", Rules{Allowlist: []common.Address{friend}}, Transaction{To: token, Data: tokenCall(t, "transfer", friend)}, big.NewInt(0), ""},
+		{"token recipient not allowlisted", Rules{Allowlist: []common.Address{friend}}, Transaction{To: token, Data: tokenCall(t, "transfer", stranger)}, big.NewInt(0), RULE_ALLOWLIST},
+		{"allowed token", Rules{AllowedTokens: []common.Address{token}}, Transaction{To: token, Data: tokenCall(t, "transfer", stranger)}, big.NewInt(0), ""},
+		{"token not allowed", Rules{AllowedTokens: []common.Address{token}}, Transaction{To: otherToken, Data: tokenCall(t, "transfer", stranger)}, big.NewInt(0), RULE_ALLOWED_TOKENS},
+		{"ETH transfer with allowed tokens", Rules{AllowedTokens: []common.Address{token}}, Transaction{To: stranger, Value: oneWei}, big.NewInt(0), ""},
+		{"unknown contract call", Rules{Allowlist: []common.Address{friend}}, Transaction{To: friend, Data: []byte{0xde, 0xad, 0xbe, 0xef}}, big.NewInt(0), ""},
+		{"token transfer under a maximum", Rules{MaxTransaction: limit}, Transaction{To: token, Value: big.NewInt(0), Data: tokenCall(t, "transfer", friend)}, big.NewInt(0), RULE_MAX_TRANSACTION},
+		{"token approval under a maximum", Rules{MaxTransaction: limit}, Transaction{To: token, Data: tokenCall(t, "approve", friend)}, big.NewInt(0), RULE_MAX_TRANSACTION},
+		{"token transfer under a daily limit", Rules{DailyLimit: limit}, Transaction{To: token, Data: tokenCall(t, "transfer", friend)}, big.NewInt(0), RULE_DAILY_LIMIT},
+		{"allowed token under a daily limit", Rules{DailyLimit: limit, AllowedTokens: []common.Address{token}}, Transaction{To: token, Data: tokenCall(t, "transfer", friend)}, big.NewInt(0), RULE_DAILY_LIMIT},
+		{"unknown contract call under a daily limit", Rules{DailyLimit: limit}, Transaction{To: friend, Value: oneWei, Data: []byte{0xde, 0xad, 0xbe, 0xef}}, big.NewInt(0), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.rules.Evaluate(test.tx, test.spent)
+			if test.rule == "" {
+				if err != nil {
+					t.Fatalf("expected the transaction to be allowed, got %v", err)
+				}
+				return
+			}
+			violation, ok := err.(*Violation)
+			if !ok {
+				t.Fatalf("expected a %s violation, got %v", test.rule, err)
+			}
+			if violation.Rule != test.rule {
+				t.Errorf("expected a %s violation, got %s (%s)", test.rule, violation.Rule, violation.Message)
+			}
+		})
+	}
+}
+
+func TestEvaluateMessages(t *testing.T) {
+	rules := Rules{MaxTransaction: eth(t, "0.015"), DailyLimit: eth(t, "1")}
+	err := rules.Evaluate(Transaction{To: stranger, Value: eth(t, "0.02")}, big.NewInt(0))
+	if err == nil || err.Error() != "transactions are limited to 0.015 ETH" {
+		t.Errorf("unexpected error %v", err)
+	}
+	err = rules.Evaluate(Transaction{To: stranger, Value: eth(t, "0.01")}, eth(t, "0.995"))
+	if err == nil || err.Error() != "this would exceed the daily limit of 1 ETH (0.005 ETH left)" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestTurnkeyPolicies(t *testing.T) {
+	friendCondition := "'" + strings.ToLower(friend.Hex()) + "'"
+	scammerCondition := "'" + strings.ToLower(scammer.Hex()) + "'"
+	tokenCondition := "'" + strings.ToLower(token.Hex()) + "'"
+
+	tests := []struct {
+		name        string
+		rules       Rules
+		effects     []turnkeymodels.Effect
+		conditions  []string
+		notMirrored []string
+	}{
+		{"no rules", Rules{}, nil, nil, []string{}},
+		{
+			"maximum per transaction",
+			Rules{MaxTransaction: big.NewInt(100)},
+			[]turnkeymodels.Effect{turnkeymodels.EffectDeny},
+			[]string{signTransactionCondition + " && eth.tx.value > 100"},
+			[]string{"refusing token calls while ETH limits are set"},
+		},
+		{
+			"denylist",
+			Rules{Denylist: []common.Address{scammer}},
+			[]turnkeymodels.Effect{turnkeymodels.EffectDeny},
+			[]string{signTransactionCondition + " && eth.tx.to in [" + scammerCondition + "]"},
+			[]string{},
+		},
+		{
+			"allowlist",
+			Rules{Allowlist: []common.Address{friend}},
+			[]turnkeymodels.Effect{turnkeymodels.EffectAllow},
+			[]string{signTransactionCondition + " && (eth.tx.to in [" + friendCondition + "])"},
+			[]string{"allowlist checks on token transfer recipients"},
+		},
+		{
+			"allowed tokens",
+			Rules{AllowedTokens: []common.Address{token}},
+			[]turnkeymodels.Effect{turnkeymodels.EffectAllow},
+			[]string{signTransactionCondition + " && (eth.tx.data == '0x' || eth.tx.to in [" + tokenCondition + "])"},
+			[]string{},
+		},
+		{
+			"allowlist and allowed tokens",
+			Rules{Allowlist: []common.Address{friend}, AllowedTokens: []common.Address{token}},
+			[]turnkeymodels.Effect{turnkeymodels.EffectAllow},
+			[]string{signTransactionCondition + " && ((eth.tx.data == '0x' && eth.tx.to in [" + friendCondition + "]) || eth.tx.to in [" + tokenCondition + "])"},
+			[]string{"allowlist checks on token transfer recipients"},
+		},
+		{"daily limit", Rules{DailyLimit: big.NewInt(100)}, nil, nil, []string{RULE_DAILY_LIMIT, "refusing token calls while ETH limits are set"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policies, notMirrored := test.rules.TurnkeyPolicies()
+			if len(policies) != len(test.conditions) {
+				t.Fatalf("expected %d policies, got %+v", len(test.conditions), policies)
+			}
+			for i, policy := range policies {
+				if policy.Effect != test.effects[i] {
+					t.Errorf("policy %d: expected effect %s, got %s", i, test.effects[i], policy.Effect)
+				}
+				if policy.Condition != test.conditions[i] {
+					t.Errorf("policy %d: expected condition\n%s\ngot\n%s", i, test.conditions[i], policy.Condition)
+				}
+			}
+			if strings.Join(notMirrored, ",") != strings.Join(test.notMirrored, ",") {
+				t.Errorf("expected %v not to be mirrored, got %v", test.notMirrored, notMirrored)
+			}
+		})
+	}
+}
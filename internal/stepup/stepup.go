@@ -0,0 +1,120 @@
+package stepup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/turnkey"
+	"github.com/tkhq/demo-passkey-wallet/internal/types"
+)
+
+// Being logged in isn't enough for sensitive actions such as exporting a wallet: users first "step up" by stamping a
+// whoami request with one of their passkeys. The step-up is recorded on their session and lasts MaxAge.
+type Config struct {
+	MaxAge time.Duration
+	// Minimum delay between two wallet exports of the same user
+	ExportCooldown time.Duration
+}
+
+var DefaultConfig = Config{
+	MaxAge:         5 * time.Minute,
+	ExportCooldown: 10 * time.Minute,
+}
+
+var Settings = DefaultConfig
+
+// Stamps made with passkeys carry this header. Credentials from email auth or recovery stamp with X-Stamp, which doesn't count.
+const WEBAUTHN_STAMP_HEADER_NAME = "X-Stamp-WebAuthn"
+
+const WHOAMI_PATH = "/public/v1/query/whoami"
+
+var ErrPasskeyRequired = errors.New("step-up requires a whoami request stamped with a passkey")
+var ErrNotWhoami = errors.New("step-up requires a signed whoami request")
+var ErrAuthenticationFailed = errors.New("step-up authentication failed")
+
+// Reads STEP_UP_MAX_AGE and WALLET_EXPORT_COOLDOWN (Go durations). Unset variables keep their default value.
+func Init() error {
+	config := DefaultConfig
+	durations := map[string]*time.Duration{
+		"STEP_UP_MAX_AGE":        &config.MaxAge,
+		"WALLET_EXPORT_COOLDOWN": &config.ExportCooldown,
+	}
+	for name, value := range durations {
+		if configured := os.Getenv(name); configured != "" {
+			parsed, err := time.ParseDuration(configured)
+			if err != nil || parsed < 0 {
+				return fmt.Errorf("invalid %s (%q): expected a duration", name, configured)
+			}
+			*value = parsed
+		}
+	}
+	Settings = config
+	return nil
+}
+
+// Returns true if the session stepped up recently enough for sensitive actions
+func IsRecent(session *models.UserSession) bool {
+	return session.SteppedUpAt.Valid && time.Since(session.SteppedUpAt.Time) <= Settings.MaxAge
+}
+
+// Forwards a passkey-stamped whoami request to Turnkey and records the step-up on the session if it resolves
+// to the session user's sub-organization. Returns ErrPasskeyRequired, ErrNotWhoami or ErrAuthenticationFailed
+// when the request doesn't prove passkey ownership.
+func Verify(ctx context.Context, session *models.UserSession, user *models.User, signedWhoamiRequest types.SignedTurnkeyRequest) error {
+	if signedWhoamiRequest.Stamp.StampHeaderName != WEBAUTHN_STAMP_HEADER_NAME {
+		return ErrPasskeyRequired
+	}
+	parsedUrl, err := url.Parse(signedWhoamiRequest.Url)
+	if err != nil || parsedUrl.Path != WHOAMI_PATH {
+		return ErrNotWhoami
+	}
+
+	status, bodyBytes, err := turnkey.Client.ForwardSignedRequest(ctx, signedWhoamiRequest.Url, signedWhoamiRequest.Body, signedWhoamiRequest.Stamp)
+	if err != nil {
+		return errors.Wrap(err, "error while forwarding signed whoami request")
+	}
+	if status == http.StatusUnauthorized {
+		return ErrAuthenticationFailed
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("expected 200 when forwarding whoami request. Got %d", status)
+	}
+
+	organizationId := gjson.GetBytes(bodyBytes, "organizationId").String()
+	if !user.SubOrganizationId.Valid || organizationId != user.SubOrganizationId.String {
+		return ErrAuthenticationFailed
+	}
+	return models.RecordUserSessionStepUp(session)
+}
+
+// Returns how long the user has to wait before exporting their wallet again (zero if they can export now)
+func ExportCooldownRemaining(userId uint) (time.Duration, error) {
+	lastExport, err := models.LastSucceededAuditEvent(userId, models.AUDIT_ACTION_WALLET_EXPORT)
+	if err != nil {
+		return 0, err
+	}
+	if lastExport.IsZero() {
+		return 0, nil
+	}
+	remaining := Settings.ExportCooldown - time.Since(lastExport)
+	if remaining < 0 {
+		return 0, nil
+	}
+	return remaining, nil
+}
+
+// Returns true for errors caused by the user (as opposed to internal failures)
+func IsUserError(err error) bool {
+	switch err {
+	case ErrPasskeyRequired, ErrNotWhoami, ErrAuthenticationFailed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package turnkey
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/go-sdk/pkg/api/models"
+)
+
+// Controls how we poll Turnkey while waiting for activities to complete.
+// The delay between polls starts at InitialDelay and is multiplied by Multiplier after each poll, up to MaxDelay.
+// Polling stops once Timeout has elapsed.
+type PollConfig struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Timeout      time.Duration
+}
+
+var DefaultPollConfig = PollConfig{
+	InitialDelay: 200 * time.Millisecond,
+	MaxDelay:     2 * time.Second,
+	Multiplier:   1.5,
+	Timeout:      15 * time.Second,
+}
+
+// Returned when an activity reached ACTIVITY_STATUS_CONSENSUS_NEEDED.
+// This isn't a failure: the activity completes once enough approvers have voted. Callers can poll it later with its ID.
+type ActivityConsensusNeededError struct {
+	ActivityId     string
+	OrganizationId string
+}
+
+func (e *ActivityConsensusNeededError) Error() string {
+	return fmt.Sprintf("activity %s requires consensus", e.ActivityId)
+}
+
+type ActivityRejectedError struct {
+	ActivityId string
+}
+
+func (e *ActivityRejectedError) Error() string {
+	return fmt.Sprintf("activity %s was rejected", e.ActivityId)
+}
+
+type ActivityFailedError struct {
+	ActivityId string
+}
+
+func (e *ActivityFailedError) Error() string {
+	return fmt.Sprintf("activity %s failed", e.ActivityId)
+}
+
+// Returned when an activity is still pending once the poll timeout is reached
+type ActivityTimeoutError struct {
+	ActivityId string
+	LastStatus string
+	Attempts   int
+}
+
+func (e *ActivityTimeoutError) Error() string {
+	return fmt.Sprintf("activity %s is still in %s status after %d attempts", e.ActivityId, e.LastStatus, e.Attempts)
+}
+
+// Reads TURNKEY_POLL_INITIAL_DELAY, TURNKEY_POLL_MAX_DELAY and TURNKEY_POLL_TIMEOUT (Go durations)
+// as well as TURNKEY_POLL_MULTIPLIER. Unset variables keep their default value.
+func PollConfigFromEnv() (PollConfig, error) {
+	config := DefaultPollConfig
+
+	durations := map[string]*time.Duration{
+		"TURNKEY_POLL_INITIAL_DELAY": &config.InitialDelay,
+		"TURNKEY_POLL_MAX_DELAY":     &config.MaxDelay,
+		"TURNKEY_POLL_TIMEOUT":       &config.Timeout,
+	}
+	for name, value := range durations {
+		if configured := os.Getenv(name); configured != "" {
+			parsed, err := time.ParseDuration(configured)
+			if err != nil || parsed <= 0 {
+				return PollConfig{}, fmt.Errorf("invalid %s (%q): expected a positive duration", name, configured)
+			}
+			*value = parsed
+		}
+	}
+
+	if configured := os.Getenv("TURNKEY_POLL_MULTIPLIER"); configured != "" {
+		parsed, err := strconv.ParseFloat(configured, 64)
+		if err != nil || parsed < 1 {
+			return PollConfig{}, fmt.Errorf("invalid TURNKEY_POLL_MULTIPLIER (%q): expected a number >= 1", configured)
+		}
+		config.Multiplier = parsed
+	}
+	return config, nil
+}
+
+// The outcome of a single poll
+type pollResult struct {
+	ActivityId     string
+	OrganizationId string
+	Status         models.ActivityStatus
+}
+
+// Polls until the activity reaches a terminal status, the configured timeout elapses, or ctx is cancelled.
+// Returns nil once the activity is completed, and a typed error for every other terminal status.
+func poll(ctx context.Context, config PollConfig, fetch func(ctx context.Context) (*pollResult, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+
+	delay := config.InitialDelay
+	var last *pollResult
+	for attempts := 1; ; attempts++ {
+		result, err := fetch(ctx)
+		if err != nil {
+			if last != nil && ctx.Err() == context.DeadlineExceeded {
+				return &ActivityTimeoutError{ActivityId: last.ActivityId, LastStatus: string(last.Status), Attempts: attempts}
+			}
+			return errors.Wrapf(err, "error while polling activity (attempt %d)", attempts)
+		}
+		last = result
+
+		switch result.Status {
+		case models.ActivityStatusCompleted:
+			return nil
+		case models.ActivityStatusConsensusNeeded:
+			return &ActivityConsensusNeededError{ActivityId: result.ActivityId, OrganizationId: result.OrganizationId}
+		case models.ActivityStatusRejected:
+			return &ActivityRejectedError{ActivityId: result.ActivityId}
+		case models.ActivityStatusFailed:
+			return &ActivityFailedError{ActivityId: result.ActivityId}
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return &ActivityTimeoutError{ActivityId: result.ActivityId, LastStatus: string(result.Status), Attempts: attempts}
+			}
+			return errors.Wrapf(err, "stopped waiting for activity %s", result.ActivityId)
+		}
+		delay = time.Duration(float64(delay) * config.Multiplier)
+		if delay > config.MaxDelay {
+			delay = config.MaxDelay
+		}
+	}
+}
+
+// Sleeps for the given duration, or less if the context is cancelled first (in which case the context error is returned).
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package turnkey
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tkhq/go-sdk/pkg/api/models"
+)
+
+var testPollConfig = PollConfig{
+	InitialDelay: time.Millisecond,
+	MaxDelay:     5 * time.Millisecond,
+	Multiplier:   2,
+	Timeout:      100 * time.Millisecond,
+}
+
+func TestPoll(t *testing.T) {
+	tests := []struct {
+		name string
+		// Statuses returned by successive polls; the last one repeats
+		statuses []models.ActivityStatus
+		// Cancels the parent context during the first poll
+		cancel bool
+		check  func(t *testing.T, err error)
+	}{
+		{
+			name:     "completed",
+			statuses: []models.ActivityStatus{models.ActivityStatusCompleted},
+			check: func(t *testing.T, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "completed after pending",
+			statuses: []models.ActivityStatus{models.ActivityStatusCreated, models.ActivityStatusPending, models.ActivityStatusCompleted},
+			check: func(t *testing.T, err error) {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "consensus needed",
+			statuses: []models.ActivityStatus{models.ActivityStatusPending, models.ActivityStatusConsensusNeeded},
+			check: func(t *testing.T, err error) {
+				var consensusNeeded *ActivityConsensusNeededError
+				if !errors.As(err, &consensusNeeded) {
+					t.Fatalf("expected ActivityConsensusNeededError, got %v", err)
+				}
+				if consensusNeeded.ActivityId != "activity" || consensusNeeded.OrganizationId != "organization" {
+					t.Errorf("unexpected error %+v", consensusNeeded)
+				}
+			},
+		},
+		{
+			name:     "rejected",
+			statuses: []models.ActivityStatus{models.ActivityStatusRejected},
+			check: func(t *testing.T, err error) {
+				var rejected *ActivityRejectedError
+				if !errors.As(err, &rejected) || rejected.ActivityId != "activity" {
+					t.Fatalf("expected ActivityRejectedError, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "failed",
+			statuses: []models.ActivityStatus{models.ActivityStatusFailed},
+			check: func(t *testing.T, err error) {
+				var failed *ActivityFailedError
+				if !errors.As(err, &failed) || failed.ActivityId != "activity" {
+					t.Fatalf("expected ActivityFailedError, got %v", err)
+				}
+			},
+		},
+		{
+			name:     "timeout while pending",
+			statuses: []models.ActivityStatus{models.ActivityStatusPending},
+			check: func(t *testing.T, err error) {
+				var timeout *ActivityTimeoutError
+				if !errors.As(err, &timeout) {
+					t.Fatalf("expected ActivityTimeoutError, got %v", err)
+				}
+				if timeout.ActivityId != "activity" || timeout.LastStatus != string(models.ActivityStatusPending) || timeout.Attempts < 2 {
+					t.Errorf("unexpected error %+v", timeout)
+				}
+			},
+		},
+		{
+			name:     "parent context cancelled",
+			statuses: []models.ActivityStatus{models.ActivityStatusPending},
+			cancel:   true,
+			check: func(t *testing.T, err error) {
+				var timeout *ActivityTimeoutError
+				if errors.As(err, &timeout) {
+					t.Fatalf("cancellation isn't a timeout, got %v", err)
+				}
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected context.Canceled, got %v", err)
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			polls := 0
+			err := poll(ctx, testPollConfig, func(ctx context.Context) (*pollResult, error) {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				status := test.statuses[len(test.statuses)-1]
+				if polls < len(test.statuses) {
+					status = test.statuses[polls]
+				}
+				polls++
+				if test.cancel {
+					cancel()
+				}
+				return &pollResult{ActivityId: "activity", OrganizationId: "organization", Status: status}, nil
+			})
+			test.check(t, err)
+			if !test.cancel && polls < len(test.statuses) {
+				t.Errorf("expected at least %d polls, got %d", len(test.statuses), polls)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package turnkey
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"strings"
 	"time"
 
@@ -14,12 +17,15 @@ import (
 	"github.com/tkhq/go-sdk"
 	"github.com/tkhq/go-sdk/pkg/api/client"
 	"github.com/tkhq/go-sdk/pkg/api/client/activities"
+	"github.com/tkhq/go-sdk/pkg/api/client/authenticators"
+	"github.com/tkhq/go-sdk/pkg/api/client/consensus"
 	"github.com/tkhq/go-sdk/pkg/api/client/organizations"
 	"github.com/tkhq/go-sdk/pkg/api/client/private_keys"
 	"github.com/tkhq/go-sdk/pkg/api/client/sessions"
 	"github.com/tkhq/go-sdk/pkg/api/client/signing"
 	"github.com/tkhq/go-sdk/pkg/api/client/user_auth"
 	"github.com/tkhq/go-sdk/pkg/api/client/user_recovery"
+	"github.com/tkhq/go-sdk/pkg/api/client/users"
 
 	"github.com/tkhq/go-sdk/pkg/api/models"
 	"github.com/tkhq/go-sdk/pkg/apikey"
@@ -28,6 +34,18 @@ import (
 
 var Client *TurnkeyApiClient
 
+// Name given to the passkey used during registration, when users don't pick one
+const DEFAULT_AUTHENTICATOR_NAME = "End-User Passkey"
+
+// Browsers report transports as WebAuthn strings ("usb", "internal", ...). Turnkey has its own enum.
+var webauthnTransports = map[string]models.AuthenticatorTransport{
+	"usb":      models.AuthenticatorTransportUsb,
+	"nfc":      models.AuthenticatorTransportNfc,
+	"ble":      models.AuthenticatorTransportBle,
+	"internal": models.AuthenticatorTransportInternal,
+	"hybrid":   models.AuthenticatorTransportHybrid,
+}
+
 type TurnkeyApiClient struct {
 	// APIKey is the structure
 	APIKey *apikey.Key
@@ -39,6 +57,9 @@ type TurnkeyApiClient struct {
 	OrganizationID string
 
 	TurnkeyApiHost string
+
+	// How we wait for activities to complete
+	PollConfig PollConfig
 }
 
 // Custom type to hold results from a sub-org creation result
@@ -50,6 +71,31 @@ type CreateSubOrganizationResult struct {
 	EthereumAddress string
 }
 
+// Maps transports reported by the browser to Turnkey transports. Values already in Turnkey's format are accepted too.
+// Unknown transports are an error. Browsers which report no transports at all get "hybrid", which was our historical default.
+func ParseAuthenticatorTransports(transports []string) ([]models.AuthenticatorTransport, error) {
+	if len(transports) == 0 {
+		return []models.AuthenticatorTransport{models.AuthenticatorTransportHybrid}, nil
+	}
+
+	parsed := []models.AuthenticatorTransport{}
+	seen := map[models.AuthenticatorTransport]bool{}
+	for _, t := range transports {
+		transport, ok := webauthnTransports[strings.ToLower(strings.TrimSpace(t))]
+		if !ok {
+			transport = models.AuthenticatorTransport(t)
+			if err := transport.Validate(nil); err != nil {
+				return nil, fmt.Errorf("unsupported authenticator transport %q", t)
+			}
+		}
+		if !seen[transport] {
+			seen[transport] = true
+			parsed = append(parsed, transport)
+		}
+	}
+	return parsed, nil
+}
+
 // Creates a Turnkey SDK client from a Turnkey API key
 func Init(turnkeyApiHost, turnkeyApiPrivateKey, organizationID string) error {
 	apiKey, err := apikey.FromTurnkeyPrivateKey(turnkeyApiPrivateKey)
@@ -66,12 +112,13 @@ func Init(turnkeyApiHost, turnkeyApiPrivateKey, organizationID string) error {
 		Client:         publicApiClient,
 		OrganizationID: organizationID,
 		TurnkeyApiHost: turnkeyApiHost,
+		PollConfig:     DefaultPollConfig,
 	}
 	return nil
 }
 
-func (c *TurnkeyApiClient) Whoami() (string, error) {
-	p := sessions.NewGetWhoamiParams().WithBody(&models.GetWhoamiRequest{
+func (c *TurnkeyApiClient) Whoami(ctx context.Context) (string, error) {
+	p := sessions.NewGetWhoamiParamsWithContext(ctx).WithBody(&models.GetWhoamiRequest{
 		OrganizationID: &c.OrganizationID,
 	})
 	resp, err := c.Client.Sessions.GetWhoami(p, c.GetAuthenticator())
@@ -83,9 +130,13 @@ func (c *TurnkeyApiClient) Whoami() (string, error) {
 
 // Method to forward signed requests to Turnkey.
 // TODO: should be part of the Go SDK!
-func (c *TurnkeyApiClient) ForwardSignedRequest(url string, requestBody string, stamp types.TurnkeyStamp) (int, []byte, error) {
+func (c *TurnkeyApiClient) ForwardSignedRequest(ctx context.Context, url string, requestBody string, stamp types.TurnkeyStamp) (int, []byte, error) {
+	// Responses to forwarded requests are trusted (e.g. whoami responses log users in): only ever talk to Turnkey
+	if err := c.validateForwardUrl(url); err != nil {
+		return 0, []byte{}, err
+	}
 	bodyBytes := []byte(requestBody)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(bodyBytes))
 	if err != nil {
 		return 0, []byte{}, errors.Wrap(err, "cannot create HTTP request")
 	}
@@ -108,20 +159,26 @@ func (c *TurnkeyApiClient) ForwardSignedRequest(url string, requestBody string,
 	return res.StatusCode, responseBody, nil
 }
 
-// TODO: should be part of the Go SDK!
-// This function does something similar to `ForwardSignedRequest`, except it also polls until the activity is COMPLETE or FAILED
-// If that's not the case after 3 attempts, give up.
-func (c *TurnkeyApiClient) ForwardSignedActivity(url string, requestBody string, stamp types.TurnkeyStamp) ([]byte, error) {
-	activityStatus := "UNKNOWN"
-
-	delay := 200
-	maxAttempts := 5
-
-	for attempts := 0; attempts <= maxAttempts; attempts++ {
-		// Sleep for an increasing duration on each attempt, starting with 200ms and increasing by 200ms each time.
-		time.Sleep(time.Duration(delay*(attempts+1)) * time.Millisecond)
+func (c *TurnkeyApiClient) validateForwardUrl(forwardUrl string) error {
+	parsed, err := neturl.Parse(forwardUrl)
+	if err != nil {
+		return errors.Wrapf(err, "invalid URL for signed request: %q", forwardUrl)
+	}
+	if parsed.Scheme != "https" || parsed.Host != c.TurnkeyApiHost {
+		return fmt.Errorf("signed requests can only be forwarded to https://%s. Got %q", c.TurnkeyApiHost, forwardUrl)
+	}
+	return nil
+}
 
-		status, bodyBytes, err := c.ForwardSignedRequest(url, requestBody, stamp)
+// TODO: should be part of the Go SDK!
+// This function does something similar to `ForwardSignedRequest`, except it also polls until the activity is COMPLETE,
+// by forwarding the same signed request again (Turnkey returns the existing activity for identical requests).
+// Other terminal statuses are returned as typed errors (see poller.go).
+func (c *TurnkeyApiClient) ForwardSignedActivity(ctx context.Context, url string, requestBody string, stamp types.TurnkeyStamp) ([]byte, error) {
+	var completedBody []byte
+
+	err := poll(ctx, c.PollConfig, func(ctx context.Context) (*pollResult, error) {
+		status, bodyBytes, err := c.ForwardSignedRequest(ctx, url, requestBody, stamp)
 		if err != nil {
 			return nil, errors.Wrap(err, "error while forwarding signed request")
 		}
@@ -130,30 +187,102 @@ func (c *TurnkeyApiClient) ForwardSignedActivity(url string, requestBody string,
 			return nil, fmt.Errorf("expected 200 when forwarding signed activity. Got status %d: %s", status, bodyBytes)
 		}
 
-		activityStatus = gjson.Get(string(bodyBytes), "activity.status").String()
-		switch activityStatus {
-		case string(models.ActivityStatusCreated):
-			continue
-		case string(models.ActivityStatusPending):
-			continue
-		case string(models.ActivityStatusCompleted):
-			return bodyBytes, nil
-		case string(models.ActivityStatusConsensusNeeded):
-			return nil, fmt.Errorf("forwarded activity requires consensus after %d attempts", attempts+1)
-		case string(models.ActivityStatusRejected):
-			return nil, fmt.Errorf("forwarded activity was rejected after %d attempts", attempts+1)
-		case string(models.ActivityStatusFailed):
-			return nil, fmt.Errorf("forwarded activity failed after %d attempts", attempts+1)
+		completedBody = bodyBytes
+		return &pollResult{
+			ActivityId:     gjson.GetBytes(bodyBytes, "activity.id").String(),
+			OrganizationId: gjson.GetBytes(bodyBytes, "activity.organizationId").String(),
+			Status:         models.ActivityStatus(gjson.GetBytes(bodyBytes, "activity.status").String()),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return completedBody, nil
+}
+
+// Forwards a signed activity once, then waits for it to complete using our API key (parent organizations have read
+// access to sub-organizations). Unlike ForwardSignedActivity, this works for activities which remove the credential
+// that stamped them, such as DELETE_AUTHENTICATORS for the user's last passkey: forwarding them again to poll would
+// fail with a 401 once they complete.
+func (c *TurnkeyApiClient) SubmitSignedActivity(ctx context.Context, url string, requestBody string, stamp types.TurnkeyStamp) (*models.Result, error) {
+	status, bodyBytes, err := c.ForwardSignedRequest(ctx, url, requestBody, stamp)
+	if err != nil {
+		return nil, err
+	}
+	if status != 200 {
+		return nil, fmt.Errorf("expected 200 when forwarding signed activity. Got status %d: %s", status, bodyBytes)
+	}
+	activityId := gjson.GetBytes(bodyBytes, "activity.id").String()
+	organizationId := gjson.GetBytes(bodyBytes, "activity.organizationId").String()
+	if activityId == "" || organizationId == "" {
+		return nil, fmt.Errorf("unable to get activity from response: %s", bodyBytes)
+	}
+	return c.WaitForResult(ctx, organizationId, activityId)
+}
+
+// Removes our co-signer (see CoSignerPublicKey) from a sub-organization, with a DELETE_USERS activity stamped by our
+// API key. Returns false if the co-signer isn't a member of the sub-organization.
+// Where the root quorum needs several approvals, the activity waits for them (ActivityConsensusNeededError): the
+// co-signer can't act on its own there anyway.
+func (c *TurnkeyApiClient) RemoveCoSigner(ctx context.Context, organizationId string) (bool, error) {
+	subOrganizationUsers, err := c.GetUsers(ctx, organizationId)
+	if err != nil {
+		return false, err
+	}
+	coSignerUserId := ""
+	for _, u := range subOrganizationUsers {
+		for _, apiKey := range u.APIKeys {
+			if apiKey.Credential != nil && apiKey.Credential.PublicKey != nil && *apiKey.Credential.PublicKey == c.CoSignerPublicKey() {
+				coSignerUserId = *u.UserID
+			}
 		}
 	}
+	if coSignerUserId == "" {
+		return false, nil
+	}
 
-	return nil, fmt.Errorf("unable to forward request, activity is in %s status", activityStatus)
+	// The SDK has no client for DELETE_USERS: the request is stamped by hand
+	body, err := json.Marshal(map[string]interface{}{
+		"type":           "ACTIVITY_TYPE_DELETE_USERS",
+		"timestampMs":    *util.RequestTimestamp(),
+		"organizationId": organizationId,
+		"parameters": models.DeleteUsersIntent{
+			UserIds: []string{coSignerUserId},
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	stamp, err := apikey.Stamp(body, c.APIKey)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to stamp DELETE_USERS request")
+	}
+	url := fmt.Sprintf("https://%s/public/v1/submit/delete_users", c.TurnkeyApiHost)
+	if _, err := c.SubmitSignedActivity(ctx, url, string(body), types.TurnkeyStamp{StampHeaderName: "X-Stamp", StampHeaderValue: stamp}); err != nil {
+		return false, errors.Wrapf(err, "unable to remove co-signer from organization %s", organizationId)
+	}
+	return true, nil
 }
 
 // This function creates a new sub-organization for a given user email.
 // Turnkey's CREATE_SUB_ORGANIZATION activity supports creating a sub-org and private key(s) at once, atomically.
 // We use this to our advantage here!
-func (c *TurnkeyApiClient) CreateUserSubOrganization(userEmail string, attestation types.Attestation, challenge string) (*CreateSubOrganizationResult, error) {
+func (c *TurnkeyApiClient) CreateUserSubOrganization(ctx context.Context, userEmail string, attestation types.Attestation, challenge string, authenticatorName string) (*CreateSubOrganizationResult, error) {
+	activityId, err := c.RequestUserSubOrganization(ctx, userEmail, attestation, challenge, authenticatorName)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetUserSubOrganizationResult(ctx, activityId)
+}
+
+// Submits the CREATE_SUB_ORGANIZATION activity for a new user and returns its ID without waiting for completion.
+// Callers who persist the activity ID can resume with GetUserSubOrganizationResult if they're interrupted.
+func (c *TurnkeyApiClient) RequestUserSubOrganization(ctx context.Context, userEmail string, attestation types.Attestation, challenge string, authenticatorName string) (string, error) {
+	transports, err := ParseAuthenticatorTransports(attestation.Transports)
+	if err != nil {
+		return "", err
+	}
+
 	fmt.Printf("Creating sub-org for user %s...\n", userEmail)
 	sanitizedEmail := strings.ReplaceAll(userEmail, "@", "-at-")
 	sanitizedEmail = strings.ReplaceAll(sanitizedEmail, "+", "-plus-")
@@ -173,7 +302,7 @@ func (c *TurnkeyApiClient) CreateUserSubOrganization(userEmail string, attestati
 	ethereumWalletName := fmt.Sprintf("Ethereum Wallet - %s", *timestamp)
 	ethereumDerivationPath := "m/44'/60'/0'/0/0"
 
-	p := organizations.NewCreateSubOrganizationParams().WithBody(&models.CreateSubOrganizationRequest{
+	p := organizations.NewCreateSubOrganizationParamsWithContext(ctx).WithBody(&models.CreateSubOrganizationRequest{
 		OrganizationID: &c.OrganizationID,
 		Parameters: &models.CreateSubOrganizationIntentV4{
 			SubOrganizationName: &subOrganizationName,
@@ -203,11 +332,9 @@ func (c *TurnkeyApiClient) CreateUserSubOrganization(userEmail string, attestati
 								AttestationObject: &attestationObject,
 								ClientDataJSON:    &clientDataJson,
 								CredentialID:      &credentialId,
-								Transports: []models.AuthenticatorTransport{
-									models.AuthenticatorTransportHybrid,
-								},
+								Transports:        transports,
 							},
-							AuthenticatorName: func() *string { s := "End-User Passkey"; return &s }(),
+							AuthenticatorName: &authenticatorName,
 						},
 					},
 				},
@@ -220,18 +347,90 @@ func (c *TurnkeyApiClient) CreateUserSubOrganization(userEmail string, attestati
 
 	response, err := c.Client.Organizations.CreateSubOrganization(p, c.GetAuthenticator())
 	if err != nil {
-		return nil, errors.Wrap(err, "error while creating CREATE_SUB_ORGANIZATION activity")
+		return "", errors.Wrap(err, "error while creating CREATE_SUB_ORGANIZATION activity")
 	}
 	if response == nil || response.Payload == nil || response.Payload.Activity == nil || response.Payload.Activity.ID == nil {
-		return nil, fmt.Errorf("unable to get activity ID from activity response: %v", response)
+		return "", fmt.Errorf("unable to get activity ID from activity response: %v", response)
 	}
+	return *response.Payload.Activity.ID, nil
+}
 
-	result, err := c.WaitForResult(c.OrganizationID, *response.Payload.Activity.ID)
+// Waits for a CREATE_SUB_ORGANIZATION activity submitted by RequestUserSubOrganization and extracts its result.
+// Terminal failures surface as the typed errors returned by WaitForResult (ActivityFailedError, ...).
+func (c *TurnkeyApiClient) GetUserSubOrganizationResult(ctx context.Context, activityId string) (*CreateSubOrganizationResult, error) {
+	result, err := c.WaitForResult(ctx, c.OrganizationID, activityId)
 	if err != nil {
 		return nil, errors.Wrap(err, "error while waiting for activity result")
 	}
-	fmt.Printf("activity %s completed\n", *response.Payload.Activity.ID)
+	fmt.Printf("activity %s completed\n", activityId)
+	return parseSubOrganizationResult(result)
+}
+
+// A sub-organization created by a completed CREATE_SUB_ORGANIZATION activity in our organization
+type CreatedSubOrganization struct {
+	CreateSubOrganizationResult
+	ActivityId string
+	// Email of the root user the sub-organization was created for
+	UserEmail string
+	// Registration challenge and credential ID of the root user's passkey
+	Challenge    string
+	CredentialId string
+}
+
+// Page size used when listing activities (Turnkey's maximum)
+const ACTIVITIES_PAGE_SIZE = 100
+
+// Lists the sub-organizations created in our organization, according to completed activities.
+// This lets us find sub-organizations which were created on the Turnkey side but never recorded locally.
+func (c *TurnkeyApiClient) ListCreatedSubOrganizations(ctx context.Context) ([]CreatedSubOrganization, error) {
+	created := []CreatedSubOrganization{}
+	after := ""
+	for {
+		p := activities.NewGetActivitiesParamsWithContext(ctx).WithBody(&models.GetActivitiesRequest{
+			OrganizationID:    &c.OrganizationID,
+			FilterByStatus:    []models.ActivityStatus{models.ActivityStatusCompleted},
+			FilterByType:      []models.ActivityType{models.ActivityTypeCreateSubOrganizationV4},
+			PaginationOptions: &models.Pagination{Limit: fmt.Sprint(ACTIVITIES_PAGE_SIZE), After: after},
+		})
+		resp, err := c.Client.Activities.GetActivities(p, c.GetAuthenticator())
+		if err != nil {
+			return nil, errors.Wrapf(err, "error while listing CREATE_SUB_ORGANIZATION activities in organization %s", c.OrganizationID)
+		}
+
+		for _, activity := range resp.Payload.Activities {
+			result, err := parseSubOrganizationResult(activity.Result)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unexpected result for activity %s", *activity.ID)
+			}
+			subOrganization := CreatedSubOrganization{
+				CreateSubOrganizationResult: *result,
+				ActivityId:                  *activity.ID,
+			}
+			if activity.Intent != nil && activity.Intent.CreateSubOrganizationIntentV4 != nil && len(activity.Intent.CreateSubOrganizationIntentV4.RootUsers) > 0 {
+				rootUser := activity.Intent.CreateSubOrganizationIntentV4.RootUsers[0]
+				subOrganization.UserEmail = rootUser.UserEmail
+				if len(rootUser.Authenticators) > 0 {
+					authenticator := rootUser.Authenticators[0]
+					if authenticator.Challenge != nil {
+						subOrganization.Challenge = *authenticator.Challenge
+					}
+					if authenticator.Attestation != nil && authenticator.Attestation.CredentialID != nil {
+						subOrganization.CredentialId = *authenticator.Attestation.CredentialID
+					}
+				}
+			}
+			created = append(created, subOrganization)
+		}
+
+		count := len(resp.Payload.Activities)
+		if count < ACTIVITIES_PAGE_SIZE || *resp.Payload.Activities[count-1].ID == after {
+			return created, nil
+		}
+		after = *resp.Payload.Activities[count-1].ID
+	}
+}
 
+func parseSubOrganizationResult(result *models.Result) (*CreateSubOrganizationResult, error) {
 	if result == nil || result.CreateSubOrganizationResultV4 == nil || result.CreateSubOrganizationResultV4.SubOrganizationID == nil {
 		return nil, fmt.Errorf("expected a non-empty CreateSubOrganizationResultV4. Got: %+v", result)
 	}
@@ -252,10 +451,10 @@ func (c *TurnkeyApiClient) CreateUserSubOrganization(userEmail string, attestati
 
 // Takes an unsigned ETH payload and tries to sign it.
 // On success, the signed transaction is returned. On failure, an error is returned.
-func (c *TurnkeyApiClient) SignTransaction(organizationId string, signWith string, unsignedTransaction string) (string, error) {
+func (c *TurnkeyApiClient) SignTransaction(ctx context.Context, organizationId string, signWith string, unsignedTransaction string) (string, error) {
 	timestamp := util.RequestTimestamp()
 
-	p := signing.NewSignTransactionParams().WithBody(&models.SignTransactionRequest{
+	p := signing.NewSignTransactionParamsWithContext(ctx).WithBody(&models.SignTransactionRequest{
 		OrganizationID: &organizationId,
 		Parameters: &models.SignTransactionIntentV2{
 			SignWith:            &signWith,
@@ -271,7 +470,7 @@ func (c *TurnkeyApiClient) SignTransaction(organizationId string, signWith strin
 		return "", err
 	}
 
-	result, err := c.WaitForResult(organizationId, *activityResponse.Payload.Activity.ID)
+	result, err := c.WaitForResult(ctx, organizationId, *activityResponse.Payload.Activity.ID)
 	if err != nil {
 		return "", err
 	}
@@ -281,8 +480,8 @@ func (c *TurnkeyApiClient) SignTransaction(organizationId string, signWith strin
 
 // Gets the Ethereum address for a private key ID created on Turnkey
 // This is only used to pull one address: the warchest private key address
-func (c *TurnkeyApiClient) GetEthereumAddress(organizationId, privateKeyId string) (string, error) {
-	p := private_keys.NewGetPrivateKeyParams().WithBody(&models.GetPrivateKeyRequest{
+func (c *TurnkeyApiClient) GetEthereumAddress(ctx context.Context, organizationId, privateKeyId string) (string, error) {
+	p := private_keys.NewGetPrivateKeyParamsWithContext(ctx).WithBody(&models.GetPrivateKeyRequest{
 		OrganizationID: &organizationId,
 		PrivateKeyID:   &privateKeyId,
 	})
@@ -298,8 +497,8 @@ func (c *TurnkeyApiClient) GetEthereumAddress(organizationId, privateKeyId strin
 // Starts recovery for a given sub-organization
 // The backend API key can do this because parent organizations are allowed to initiate recovery for their sub-orgs.
 // Any (API) user which has the ability to perform ACTIVITY_TYPE_INIT_USER_EMAIL_RECOVERY in the parent can thus target the sub-organizations as well.
-func (c *TurnkeyApiClient) InitRecovery(subOrganizationId, email, targetPublicKey string) (string, error) {
-	p := user_recovery.NewInitUserEmailRecoveryParams().WithBody(&models.InitUserEmailRecoveryRequest{
+func (c *TurnkeyApiClient) InitRecovery(ctx context.Context, subOrganizationId, email, targetPublicKey string) (string, error) {
+	p := user_recovery.NewInitUserEmailRecoveryParamsWithContext(ctx).WithBody(&models.InitUserEmailRecoveryRequest{
 		OrganizationID: &subOrganizationId,
 		Parameters: &models.InitUserEmailRecoveryIntent{
 			Email:           &email,
@@ -314,7 +513,7 @@ func (c *TurnkeyApiClient) InitRecovery(subOrganizationId, email, targetPublicKe
 		return "", err
 	}
 
-	result, err := c.WaitForResult(subOrganizationId, *activityResponse.Payload.Activity.ID)
+	result, err := c.WaitForResult(ctx, subOrganizationId, *activityResponse.Payload.Activity.ID)
 	if err != nil {
 		return "", err
 	}
@@ -323,8 +522,8 @@ func (c *TurnkeyApiClient) InitRecovery(subOrganizationId, email, targetPublicKe
 }
 
 // Initiates email auth for a given sub-organization user
-func (c *TurnkeyApiClient) EmailAuth(subOrganizationId, email, targetPublicKey string) (string, string, error) {
-	p := user_auth.NewEmailAuthParams().WithBody(&models.EmailAuthRequest{
+func (c *TurnkeyApiClient) EmailAuth(ctx context.Context, subOrganizationId, email, targetPublicKey string) (string, string, error) {
+	p := user_auth.NewEmailAuthParamsWithContext(ctx).WithBody(&models.EmailAuthRequest{
 		OrganizationID: &subOrganizationId,
 		Parameters: &models.EmailAuthIntent{
 			Email:           &email,
@@ -339,7 +538,7 @@ func (c *TurnkeyApiClient) EmailAuth(subOrganizationId, email, targetPublicKey s
 		return "", "", err
 	}
 
-	result, err := c.WaitForResult(subOrganizationId, *activityResponse.Payload.Activity.ID)
+	result, err := c.WaitForResult(ctx, subOrganizationId, *activityResponse.Payload.Activity.ID)
 	if err != nil {
 		return "", "", err
 	}
@@ -347,42 +546,146 @@ func (c *TurnkeyApiClient) EmailAuth(subOrganizationId, email, targetPublicKey s
 	return *result.EmailAuthResult.UserID, *result.EmailAuthResult.APIKeyID, nil
 }
 
-// Utility to wait for an activity result
-func (c *TurnkeyApiClient) WaitForResult(organizationId, activityId string) (*models.Result, error) {
-	delay := 200
-	maxAttempts := 5
-
-	for attempts := 0; attempts <= maxAttempts; attempts++ {
-		// Sleep for an increasing duration on each attempt, starting with 200ms and increasing by 200ms each time.
-		time.Sleep(time.Duration(delay*(attempts+1)) * time.Millisecond)
+// Lists users in an organization. Used to show who can approve activities in a user's sub-organization.
+func (c *TurnkeyApiClient) GetUsers(ctx context.Context, organizationId string) ([]*models.User, error) {
+	p := users.NewGetUsersParamsWithContext(ctx).WithBody(&models.GetUsersRequest{
+		OrganizationID: &organizationId,
+	})
+	resp, err := c.Client.Users.GetUsers(p, c.GetAuthenticator())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while listing users in organization %s", organizationId)
+	}
+	return resp.Payload.Users, nil
+}
 
-		params := activities.NewGetActivityParams().WithBody(&models.GetActivityRequest{
-			ActivityID:     func() *string { return &activityId }(),
-			OrganizationID: &organizationId,
-		})
-		resp, err := c.Client.Activities.GetActivity(params, c.GetAuthenticator())
+// Lists the authenticators (passkeys) of a user in an organization
+func (c *TurnkeyApiClient) GetAuthenticators(ctx context.Context, organizationId, userId string) ([]*models.Authenticator, error) {
+	p := authenticators.NewGetAuthenticatorsParamsWithContext(ctx).WithBody(&models.GetAuthenticatorsRequest{
+		OrganizationID: &organizationId,
+		UserID:         &userId,
+	})
+	resp, err := c.Client.Authenticators.GetAuthenticators(p, c.GetAuthenticator())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while listing authenticators for user %s", userId)
+	}
+	return resp.Payload.Authenticators, nil
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to get activity result after %d attempts: %v", attempts+1, err)
+// Finds an authenticator by credential ID among all users of an organization.
+// Returns nil (and no error) if there's no such authenticator.
+func (c *TurnkeyApiClient) FindAuthenticatorByCredentialId(ctx context.Context, organizationId, credentialId string) (*models.Authenticator, error) {
+	organizationUsers, err := c.GetUsers(ctx, organizationId)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range organizationUsers {
+		for _, a := range u.Authenticators {
+			if a.CredentialID != nil && *a.CredentialID == credentialId {
+				return a, nil
+			}
 		}
+	}
+	return nil, nil
+}
 
-		switch *resp.Payload.Activity.Status {
-		case models.ActivityStatusCreated:
-			continue
-		case models.ActivityStatusPending:
+// Finds the completed RECOVER_USER activity which added a given credential to a sub-organization.
+// Returns nil if there is none: the recovery didn't happen (or not in this sub-organization).
+func (c *TurnkeyApiClient) FindCompletedRecovery(ctx context.Context, organizationId, credentialId string) (*models.Activity, error) {
+	p := activities.NewGetActivitiesParamsWithContext(ctx).WithBody(&models.GetActivitiesRequest{
+		OrganizationID: &organizationId,
+		FilterByStatus: []models.ActivityStatus{models.ActivityStatusCompleted},
+		FilterByType:   []models.ActivityType{models.ActivityTypeRecoverUser},
+	})
+	resp, err := c.Client.Activities.GetActivities(p, c.GetAuthenticator())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while listing RECOVER_USER activities in organization %s", organizationId)
+	}
+	for _, activity := range resp.Payload.Activities {
+		if activity.Intent == nil || activity.Intent.RecoverUserIntent == nil {
 			continue
-		case models.ActivityStatusCompleted:
-			return resp.Payload.Activity.Result, nil
-		case models.ActivityStatusConsensusNeeded:
-			return nil, fmt.Errorf("activity requires consensus after %d attempts", attempts+1)
-		case models.ActivityStatusRejected:
-			return nil, fmt.Errorf("activity was rejected after %d attempts", attempts+1)
-		case models.ActivityStatusFailed:
-			return nil, fmt.Errorf("activity failed after %d attempts", attempts+1)
+		}
+		authenticator := activity.Intent.RecoverUserIntent.Authenticator
+		if authenticator != nil && authenticator.Attestation != nil && authenticator.Attestation.CredentialID != nil && *authenticator.Attestation.CredentialID == credentialId {
+			return activity, nil
 		}
 	}
+	return nil, nil
+}
 
-	return nil, fmt.Errorf("activity %+v has not completed after %d attempts", activityId, maxAttempts)
+// Lists activities waiting for approvals in an organization
+func (c *TurnkeyApiClient) ListActivitiesAwaitingConsensus(ctx context.Context, organizationId string) ([]*models.Activity, error) {
+	p := activities.NewGetActivitiesParamsWithContext(ctx).WithBody(&models.GetActivitiesRequest{
+		OrganizationID: &organizationId,
+		FilterByStatus: []models.ActivityStatus{models.ActivityStatusConsensusNeeded},
+	})
+	resp, err := c.Client.Activities.GetActivities(p, c.GetAuthenticator())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error while listing activities in organization %s", organizationId)
+	}
+	return resp.Payload.Activities, nil
+}
+
+// The public key users add to their sub-organization to enroll our backend as a co-signer.
+// It's the public part of our API key: once it belongs to a user in a sub-organization, our requests to that
+// sub-organization are authenticated as this user.
+func (c *TurnkeyApiClient) CoSignerPublicKey() string {
+	return c.APIKey.TkPublicKey
+}
+
+// Approves an activity as the backend co-signer. This only works in sub-organizations where our public key
+// (see CoSignerPublicKey) belongs to a root quorum member.
+func (c *TurnkeyApiClient) ApproveActivity(ctx context.Context, organizationId, fingerprint string) error {
+	p := consensus.NewApproveActivityParamsWithContext(ctx).WithBody(&models.ApproveActivityRequest{
+		OrganizationID: &organizationId,
+		Parameters: &models.ApproveActivityIntent{
+			Fingerprint: &fingerprint,
+		},
+		TimestampMs: util.RequestTimestamp(),
+		Type:        (*string)(models.ActivityTypeApproveActivity.Pointer()),
+	})
+
+	activityResponse, err := c.Client.Consensus.ApproveActivity(p, c.GetAuthenticator())
+	if err != nil {
+		return errors.Wrap(err, "error while creating APPROVE_ACTIVITY activity")
+	}
+
+	_, err = c.WaitForResult(ctx, organizationId, *activityResponse.Payload.Activity.ID)
+	return err
+}
+
+// Utility to wait for an activity result
+func (c *TurnkeyApiClient) WaitForResult(ctx context.Context, organizationId, activityId string) (*models.Result, error) {
+	var result *models.Result
+
+	err := poll(ctx, c.PollConfig, func(ctx context.Context) (*pollResult, error) {
+		activity, err := c.GetActivity(ctx, organizationId, activityId)
+		if err != nil {
+			return nil, err
+		}
+		result = activity.Result
+		return &pollResult{
+			ActivityId:     activityId,
+			OrganizationId: organizationId,
+			Status:         *activity.Status,
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fetches an activity with our API key. This works for sub-organizations too: parent organizations have read access to them.
+func (c *TurnkeyApiClient) GetActivity(ctx context.Context, organizationId, activityId string) (*models.Activity, error) {
+	params := activities.NewGetActivityParamsWithContext(ctx).WithBody(&models.GetActivityRequest{
+		ActivityID:     &activityId,
+		OrganizationID: &organizationId,
+	})
+	resp, err := c.Client.Activities.GetActivity(params, c.GetAuthenticator())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get activity %s", activityId)
+	}
+	return resp.Payload.Activity, nil
 }
 
 func (c *TurnkeyApiClient) GetAuthenticator() *sdk.Authenticator {
@@ -1,9 +1,22 @@
 package types
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type RegistrationRequest struct {
 	Email       string
 	Attestation Attestation
 	Challenge   string
+	// Optional. Defaults to turnkey.DEFAULT_AUTHENTICATOR_NAME
+	AuthenticatorName string
+	// Optional. Code sent by /api/registration/claim, to replace an unverified account using this email
+	ClaimCode string
+}
+
+type ClaimEmailParams struct {
+	Email string `json:"email" binding:"required"`
 }
 
 type Attestation struct {
@@ -15,18 +28,72 @@ type Attestation struct {
 
 type AuthenticationRequest struct {
 	SignedWhoamiRequest SignedTurnkeyRequest
-	SubOrganizationId   string // optional; for email auth
 }
 
+// Destination is a checksummed (or all-lowercase) address, or an ENS name. Alternatively, ContactID sends to a saved contact.
+// Plain transfers only need Destination and Amount (in ETH). Contract calls pass either raw calldata (Data, 0x-prefixed hex),
+// or an ABI fragment with the function to call and its arguments. FunctionName is optional when the fragment has a
+// single function. An ABI passed along with Data is used to describe the calldata.
 type ConstructTxParams struct {
-	Destination string `json:"destination" binding:"required"`
-	Amount      string `json:"amount" binding:"required"`
+	Destination  string            `json:"destination"`
+	ContactID    uint              `json:"contactId"`
+	Amount       string            `json:"amount"`
+	Data         string            `json:"data"`
+	Abi          json.RawMessage   `json:"abi"`
+	FunctionName string            `json:"functionName"`
+	Args         []json.RawMessage `json:"args"`
 }
 
 type SendTxParams struct {
 	SignedSendTx SignedTurnkeyRequest `json:"signedSendTx" binding:"required"`
 }
 
+// Message is set for personal_sign payloads: plain text, or bytes when hex-encoded with a 0x prefix (like eth JSON-RPC).
+// TypedData is set for eth_signTypedData_v4 payloads.
+type SignPayloadParams struct {
+	Kind      string          `json:"kind" binding:"required,oneof=personal_sign eth_signTypedData_v4"`
+	Message   string          `json:"message"`
+	TypedData json.RawMessage `json:"typedData"`
+}
+
+type SignPayloadRequest struct {
+	SignPayloadParams
+	SignedSignRawPayloadRequest SignedTurnkeyRequest `json:"signedSignRawPayloadRequest" binding:"required"`
+}
+
+// Builds a SIWE message for the current user's wallet. Nonce and expiration are optional:
+// the nonce defaults to one issued by /api/siwe/nonce.
+type SiweMessageParams struct {
+	Domain         string     `json:"domain" binding:"required"`
+	Uri            string     `json:"uri" binding:"required"`
+	Statement      string     `json:"statement"`
+	Nonce          string     `json:"nonce"`
+	ExpirationTime *time.Time `json:"expirationTime"`
+	RequestId      string     `json:"requestId"`
+	Resources      []string   `json:"resources"`
+}
+
+// Domain and Nonce are optional. When Nonce isn't set, the message's nonce must have been issued by /api/siwe/nonce.
+type SiweVerifyParams struct {
+	Message   string `json:"message" binding:"required"`
+	Signature string `json:"signature" binding:"required"`
+	Domain    string `json:"domain" binding:"required"`
+	Nonce     string `json:"nonce"`
+}
+
+// Destination is an address or an ENS name, like ConstructTxParams.Destination
+type SweepTxParams struct {
+	Destination string `json:"destination" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	SignedDeleteAuthenticatorsRequest SignedTurnkeyRequest `json:"signedDeleteAuthenticatorsRequest" binding:"required"`
+	// Optional: signed SIGN_TRANSACTION activity for the transaction built by /api/account/sweep-tx
+	SignedSweepRequest *SignedTurnkeyRequest `json:"signedSweepRequest"`
+	// Required to delete an account whose wallet still holds funds, when they're not swept
+	AcknowledgeBalanceLoss bool `json:"acknowledgeBalanceLoss"`
+}
+
 type BroadcastTxParams struct {
 	SignedSendTx string `json:"signedSendTx" binding:"required"`
 }
@@ -59,7 +126,82 @@ type WhoamiRequest struct {
 	SignedWhoamiRequest SignedTurnkeyRequest `json:"signedWhoamiRequest" binding:"required"`
 }
 
+type VerifyEmailParams struct {
+	Code string `json:"code" binding:"required"`
+}
+
 type EmailAuthParams struct {
 	Email           string `json:"email" binding:"required"`
 	TargetPublicKey string `json:"targetPublicKey" binding:"required"`
 }
+
+type NotificationPreferencesParams struct {
+	EmailDeposits *bool   `json:"emailDeposits"`
+	WebhookUrl    *string `json:"webhookUrl"` // an empty string removes the webhook
+}
+
+type CreateWebhookSubscriptionParams struct {
+	Url        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"eventTypes" binding:"required,min=1"`
+}
+
+type UpdateWebhookSubscriptionParams struct {
+	Active *bool `json:"active" binding:"required"`
+}
+
+type AddApproverRequest struct {
+	SignedCreateUsersRequest SignedTurnkeyRequest `json:"signedCreateUsersRequest" binding:"required"`
+}
+
+type UpdateQuorumRequest struct {
+	SignedUpdateRootQuorumRequest SignedTurnkeyRequest `json:"signedUpdateRootQuorumRequest" binding:"required"`
+}
+
+type ApproveActivityRequest struct {
+	SignedApproveRequest SignedTurnkeyRequest `json:"signedApproveRequest" binding:"required"`
+}
+
+type RejectActivityRequest struct {
+	SignedRejectRequest SignedTurnkeyRequest `json:"signedRejectRequest" binding:"required"`
+}
+
+type CreateAuthenticatorsRequest struct {
+	SignedCreateAuthenticatorsRequest SignedTurnkeyRequest `json:"signedCreateAuthenticatorsRequest" binding:"required"`
+}
+
+type DeleteAuthenticatorsRequest struct {
+	SignedDeleteAuthenticatorsRequest SignedTurnkeyRequest `json:"signedDeleteAuthenticatorsRequest" binding:"required"`
+}
+
+type RenameAuthenticatorParams struct {
+	Name string `json:"name" binding:"required,max=255"`
+}
+
+// Address is an address or an ENS name (resolved once, when saved). ChainID defaults to the wallet's chain.
+// Contacts can also be created from a history entry by passing its TransferHash instead of an address:
+// the address is the other side of the transfer.
+type ContactParams struct {
+	Label        string `json:"label" binding:"required,max=255"`
+	Address      string `json:"address"`
+	ChainID      int64  `json:"chainId"`
+	Notes        string `json:"notes" binding:"max=1024"`
+	TransferHash string `json:"transferHash"`
+}
+
+// Limits are exact ETH amounts (e.g. "0.5"), empty for no limit. Lists hold addresses; empty lists don't restrict anything.
+type SpendingPolicyParams struct {
+	MaxTransaction string   `json:"maxTransaction"`
+	DailyLimit     string   `json:"dailyLimit"`
+	Allowlist      []string `json:"allowlist"`
+	Denylist       []string `json:"denylist"`
+	AllowedTokens  []string `json:"allowedTokens"`
+}
+
+type CreateTurnkeyPolicyRequest struct {
+	SignedCreatePolicyRequest SignedTurnkeyRequest `json:"signedCreatePolicyRequest" binding:"required"`
+}
+
+// Signed SIGN_TRANSACTION requests for the payments of a batch, in any order. Payments are matched by unsigned transaction.
+type SubmitBatchRequest struct {
+	SignedTransactions []SignedTurnkeyRequest `json:"signedTransactions" binding:"required,min=1"`
+}
@@ -0,0 +1,89 @@
+package usersessions
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+)
+
+// Session cookies are signed (and optionally encrypted) with the keys in SESSION_KEYS: a comma-separated list of
+// "<authentication key>[:<encryption key>]" pairs, hex-encoded. The first pair signs new cookies; the others are
+// still accepted, so a new pair can be prepended and the old one dropped once its sessions have expired.
+const MIN_AUTHENTICATION_KEY_LENGTH = 32
+
+// Parses SESSION_KEYS into the key pairs expected by the session store
+func KeyPairsFromEnv() ([][]byte, error) {
+	configured := os.Getenv("SESSION_KEYS")
+	if configured == "" {
+		return nil, fmt.Errorf("SESSION_KEYS must be set")
+	}
+
+	var keyPairs [][]byte
+	for i, pair := range strings.Split(configured, ",") {
+		authenticationPart, encryptionPart, hasEncryption := strings.Cut(strings.TrimSpace(pair), ":")
+		authenticationKey, err := hex.DecodeString(authenticationPart)
+		if err != nil || len(authenticationKey) < MIN_AUTHENTICATION_KEY_LENGTH {
+			return nil, fmt.Errorf("invalid SESSION_KEYS: authentication key %d must be at least %d hex-encoded bytes", i+1, MIN_AUTHENTICATION_KEY_LENGTH)
+		}
+
+		// A nil encryption key leaves cookies signed but not encrypted
+		var encryptionKey []byte
+		if hasEncryption {
+			encryptionKey, err = hex.DecodeString(encryptionPart)
+			if err != nil || (len(encryptionKey) != 16 && len(encryptionKey) != 24 && len(encryptionKey) != 32) {
+				return nil, fmt.Errorf("invalid SESSION_KEYS: encryption key %d must be 16, 24 or 32 hex-encoded bytes", i+1)
+			}
+		}
+		keyPairs = append(keyPairs, authenticationKey, encryptionKey)
+	}
+	return keyPairs, nil
+}
+
+// Reads session cookie attributes from SESSION_COOKIE_SECURE, SESSION_COOKIE_HTTP_ONLY (both default to true),
+// SESSION_COOKIE_SAME_SITE (lax, strict or none; defaults to lax) and SESSION_COOKIE_DOMAIN (defaults to the backend's host).
+// Cookies last as long as the absolute session timeout, so Init must run first.
+func CookieOptionsFromEnv() (sessions.Options, error) {
+	options := sessions.Options{
+		Path:     "/",
+		Domain:   os.Getenv("SESSION_COOKIE_DOMAIN"),
+		MaxAge:   int(Settings.AbsoluteTimeout.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	flags := map[string]*bool{
+		"SESSION_COOKIE_SECURE":    &options.Secure,
+		"SESSION_COOKIE_HTTP_ONLY": &options.HttpOnly,
+	}
+	for name, value := range flags {
+		if configured := os.Getenv(name); configured != "" {
+			parsed, err := strconv.ParseBool(configured)
+			if err != nil {
+				return options, fmt.Errorf("invalid %s (%q): expected true or false", name, configured)
+			}
+			*value = parsed
+		}
+	}
+
+	switch sameSite := strings.ToLower(os.Getenv("SESSION_COOKIE_SAME_SITE")); sameSite {
+	case "", "lax":
+		options.SameSite = http.SameSiteLaxMode
+	case "strict":
+		options.SameSite = http.SameSiteStrictMode
+	case "none":
+		// Browsers reject SameSite=None cookies which aren't also Secure
+		if !options.Secure {
+			return options, fmt.Errorf("SESSION_COOKIE_SAME_SITE=none requires SESSION_COOKIE_SECURE=true")
+		}
+		options.SameSite = http.SameSiteNoneMode
+	default:
+		return options, fmt.Errorf("invalid SESSION_COOKIE_SAME_SITE (%q): expected lax, strict or none", sameSite)
+	}
+	return options, nil
+}
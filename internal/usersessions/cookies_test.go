@@ -0,0 +1,76 @@
+package usersessions
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKeyPairsFromEnv(t *testing.T) {
+	authenticationKey := strings.Repeat("ab", MIN_AUTHENTICATION_KEY_LENGTH)
+	otherAuthenticationKey := strings.Repeat("cd", MIN_AUTHENTICATION_KEY_LENGTH)
+	encryptionKey := strings.Repeat("ef", 32)
+
+	tests := []struct {
+		name       string
+		configured string
+		// Expected number of pairs, or -1 if SESSION_KEYS should be rejected
+		pairs      int
+		encryption []bool
+	}{
+		{"unset", "", -1, nil},
+		{"authentication key only", authenticationKey, 1, []bool{false}},
+		{"with encryption key", authenticationKey + ":" + encryptionKey, 1, []bool{true}},
+		{"16-byte encryption key", authenticationKey + ":" + strings.Repeat("ef", 16), 1, []bool{true}},
+		{"24-byte encryption key", authenticationKey + ":" + strings.Repeat("ef", 24), 1, []bool{true}},
+		{"rotation", authenticationKey + ":" + encryptionKey + ", " + otherAuthenticationKey, 2, []bool{true, false}},
+		{"short authentication key", strings.Repeat("ab", MIN_AUTHENTICATION_KEY_LENGTH-1), -1, nil},
+		{"authentication key not hex", strings.Repeat("zz", MIN_AUTHENTICATION_KEY_LENGTH), -1, nil},
+		{"encryption key of invalid length", authenticationKey + ":" + strings.Repeat("ef", 20), -1, nil},
+		{"empty encryption key", authenticationKey + ":", -1, nil},
+		{"encryption key not hex", authenticationKey + ":" + strings.Repeat("zz", 32), -1, nil},
+		{"invalid second pair", authenticationKey + ",abcd", -1, nil},
+		{"trailing comma", authenticationKey + ",", -1, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("SESSION_KEYS", test.configured)
+			keyPairs, err := KeyPairsFromEnv()
+			if test.pairs < 0 {
+				if err == nil {
+					t.Fatalf("expected SESSION_KEYS %q to be rejected", test.configured)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keyPairs) != 2*test.pairs {
+				t.Fatalf("expected %d key pairs, got %d keys", test.pairs, len(keyPairs))
+			}
+			for i, encrypted := range test.encryption {
+				if len(keyPairs[2*i]) < MIN_AUTHENTICATION_KEY_LENGTH {
+					t.Errorf("pair %d: authentication key is only %d bytes", i+1, len(keyPairs[2*i]))
+				}
+				if (keyPairs[2*i+1] != nil) != encrypted {
+					t.Errorf("pair %d: expected encryption %t", i+1, encrypted)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyPairsFromEnvOrder(t *testing.T) {
+	t.Setenv("SESSION_KEYS", strings.Repeat("ab", MIN_AUTHENTICATION_KEY_LENGTH)+","+strings.Repeat("cd", MIN_AUTHENTICATION_KEY_LENGTH))
+	keyPairs, err := KeyPairsFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first pair signs new cookies
+	if !bytes.Equal(keyPairs[0], bytes.Repeat([]byte{0xab}, MIN_AUTHENTICATION_KEY_LENGTH)) {
+		t.Errorf("expected the first configured key to come first, got %x", keyPairs[0])
+	}
+	if !bytes.Equal(keyPairs[2], bytes.Repeat([]byte{0xcd}, MIN_AUTHENTICATION_KEY_LENGTH)) {
+		t.Errorf("expected the second configured key to come second, got %x", keyPairs[2])
+	}
+}
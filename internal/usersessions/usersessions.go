@@ -0,0 +1,125 @@
+package usersessions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"gorm.io/gorm"
+)
+
+// Sessions end AbsoluteTimeout after login no matter what, or after IdleTimeout without requests.
+type Config struct {
+	AbsoluteTimeout time.Duration
+	IdleTimeout     time.Duration
+	CleanupInterval time.Duration
+}
+
+var DefaultConfig = Config{
+	AbsoluteTimeout: 24 * time.Hour,
+	IdleTimeout:     2 * time.Hour,
+	CleanupInterval: time.Hour,
+}
+
+var Settings = DefaultConfig
+
+// Last-seen times are only written once per interval, rather than on every request
+const TOUCH_INTERVAL = time.Minute
+
+var ErrSessionEnded = errors.New("session is expired or revoked")
+
+// Reads SESSION_ABSOLUTE_TIMEOUT, SESSION_IDLE_TIMEOUT and SESSION_CLEANUP_INTERVAL (Go durations).
+// Unset variables keep their default value.
+func Init() error {
+	config := DefaultConfig
+	durations := map[string]*time.Duration{
+		"SESSION_ABSOLUTE_TIMEOUT": &config.AbsoluteTimeout,
+		"SESSION_IDLE_TIMEOUT":     &config.IdleTimeout,
+		"SESSION_CLEANUP_INTERVAL": &config.CleanupInterval,
+	}
+	for name, value := range durations {
+		if configured := os.Getenv(name); configured != "" {
+			parsed, err := time.ParseDuration(configured)
+			if err != nil || parsed <= 0 {
+				return fmt.Errorf("invalid %s (%q): expected a positive duration", name, configured)
+			}
+			*value = parsed
+		}
+	}
+	if config.IdleTimeout > config.AbsoluteTimeout {
+		return fmt.Errorf("SESSION_IDLE_TIMEOUT (%s) cannot be longer than SESSION_ABSOLUTE_TIMEOUT (%s)", config.IdleTimeout, config.AbsoluteTimeout)
+	}
+	Settings = config
+	return nil
+}
+
+// Records a new session for a user who just logged in. The returned session's token goes in the session cookie.
+func Create(userId uint, userAgent, ipAddress string) (*models.UserSession, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, errors.Wrap(err, "unable to generate session token")
+	}
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+
+	now := time.Now()
+	session := models.UserSession{
+		UserID:     userId,
+		Token:      hex.EncodeToString(token),
+		UserAgent:  userAgent,
+		IpAddress:  ipAddress,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(Settings.AbsoluteTimeout),
+	}
+	if err := models.CreateUserSession(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Looks up the session for a token, checking it's still valid, and records activity on it.
+// Returns ErrSessionEnded for revoked, expired and idle sessions.
+func Validate(token, ipAddress string) (*models.UserSession, error) {
+	session, err := models.FindUserSessionByToken(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionEnded
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to look up session")
+	}
+
+	now := time.Now()
+	if session.RevokedAt.Valid || now.After(session.ExpiresAt) || now.Sub(session.LastSeenAt) > Settings.IdleTimeout {
+		return nil, ErrSessionEnded
+	}
+	if now.Sub(session.LastSeenAt) > TOUCH_INTERVAL || session.IpAddress != ipAddress {
+		if err := models.TouchUserSession(session, ipAddress); err != nil {
+			log.Printf("unable to record activity on session %d: %s", session.ID, err.Error())
+		}
+	}
+	return session, nil
+}
+
+// Periodically deletes expired and revoked sessions
+func StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(Settings.CleanupInterval)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			deleted, err := models.DeleteExpiredUserSessions(now, now.Add(-Settings.IdleTimeout))
+			if err != nil {
+				log.Printf("unable to clean up sessions: %s", err.Error())
+			} else if deleted > 0 {
+				log.Printf("cleaned up %d expired sessions", deleted)
+			}
+			<-ticker.C
+		}
+	}()
+}
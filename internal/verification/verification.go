@@ -0,0 +1,196 @@
+package verification
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tkhq/demo-passkey-wallet/internal/mailer"
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"gorm.io/gorm"
+)
+
+// Users prove they own their email by typing a one-time code we send them
+const CODE_LENGTH = 6
+const CODE_TTL = 15 * time.Minute
+
+// After this many wrong codes, users have to request a new one
+const MAX_ATTEMPTS = 5
+
+// Minimum delay between two codes sent to the same user
+const RESEND_COOLDOWN = time.Minute
+
+var ErrAlreadyVerified = errors.New("email is already verified")
+var ErrResendTooSoon = errors.New("a verification code was sent recently. Please wait before requesting another one")
+var ErrNoPendingVerification = errors.New("no pending verification. Request a new code")
+var ErrCodeExpired = errors.New("verification code expired. Request a new code")
+var ErrTooManyAttempts = errors.New("too many incorrect codes. Request a new code")
+var ErrInvalidCode = errors.New("incorrect verification code")
+
+// Sends a new verification code to the user's email, replacing any previous code
+func Send(user *models.User) error {
+	if user.IsVerified() {
+		return ErrAlreadyVerified
+	}
+	existing, err := models.FindEmailVerificationForUser(user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrapf(err, "unable to look up email verification for user %d", user.ID)
+	}
+	if existing != nil && time.Since(existing.CreatedAt) < RESEND_COOLDOWN {
+		return ErrResendTooSoon
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	err = models.SaveEmailVerification(&models.EmailVerification{
+		UserID:    user.ID,
+		Email:     user.Email,
+		CodeHash:  hashCode(code),
+		ExpiresAt: time.Now().Add(CODE_TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	subject := "Verify your email"
+	body := fmt.Sprintf("Your Demo Passkey Wallet verification code is %s\n\nIt expires in %d minutes. If you didn't create an account, you can ignore this email.\n", code, int(CODE_TTL.Minutes()))
+	if err := mailer.Client.Send(user.Email, subject, body); err != nil {
+		return errors.Wrapf(err, "unable to send verification email to user %d", user.ID)
+	}
+	return nil
+}
+
+// Checks a code sent with Send, and marks the user as verified if it's correct
+func Verify(user *models.User, code string) error {
+	if user.IsVerified() {
+		return ErrAlreadyVerified
+	}
+	verification, err := models.FindEmailVerificationForUser(user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && verification.Email != user.Email) {
+		return ErrNoPendingVerification
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to look up email verification for user %d", user.ID)
+	}
+	if err := checkCode(code, verification.CodeHash, verification.ExpiresAt, verification.Attempts, time.Now()); err != nil {
+		if err == ErrInvalidCode {
+			if err := models.IncrementEmailVerificationAttempts(verification); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	if err := models.MarkUserVerified(user.ID); err != nil {
+		return err
+	}
+	return models.DeleteEmailVerificationForUser(user.ID)
+}
+
+// Sends a code to an email used by an account which was never verified. Typing the code back at registration (see
+// CheckClaim) proves control of the email, and replaces the unverified account: otherwise anyone could lock owners
+// out of their email by registering it first.
+// Emails without unverified account are silently ignored, so that callers can't tell which emails are registered.
+func SendClaim(email string) error {
+	email = models.NormalizeEmail(email)
+	if _, err := models.FindUnverifiedAccountByEmail(email); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return errors.Wrap(err, "unable to look up unverified account")
+	}
+	existing, err := models.FindEmailClaim(email)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return errors.Wrap(err, "unable to look up email claim")
+	}
+	if existing != nil && time.Since(existing.CreatedAt) < RESEND_COOLDOWN {
+		return ErrResendTooSoon
+	}
+
+	code, err := generateCode()
+	if err != nil {
+		return err
+	}
+	err = models.SaveEmailClaim(&models.EmailClaim{
+		Email:     email,
+		CodeHash:  hashCode(code),
+		ExpiresAt: time.Now().Add(CODE_TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	subject := "Register with your email"
+	body := fmt.Sprintf("Someone (hopefully you) wants to register a Demo Passkey Wallet with this email. An account already uses it, but it was never verified.\n\n"+
+		"Registering with code %s replaces that account: it's deleted, and its wallet can't be accessed through this app anymore.\n\n"+
+		"The code expires in %d minutes. If you didn't ask for it, you can ignore this email.\n", code, int(CODE_TTL.Minutes()))
+	if err := mailer.Client.Send(email, subject, body); err != nil {
+		return errors.Wrap(err, "unable to send claim email")
+	}
+	return nil
+}
+
+// Checks a code sent with SendClaim. Correct codes are consumed.
+func CheckClaim(email, code string) error {
+	email = models.NormalizeEmail(email)
+	claim, err := models.FindEmailClaim(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNoPendingVerification
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to look up email claim")
+	}
+	if err := checkCode(code, claim.CodeHash, claim.ExpiresAt, claim.Attempts, time.Now()); err != nil {
+		if err == ErrInvalidCode {
+			if err := models.IncrementEmailClaimAttempts(claim); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+	return models.DeleteEmailClaim(email)
+}
+
+// Checks a code against a pending code's hash, expiry and number of wrong attempts so far
+func checkCode(code, codeHash string, expiresAt time.Time, attempts int, now time.Time) error {
+	if now.After(expiresAt) {
+		return ErrCodeExpired
+	}
+	if attempts >= MAX_ATTEMPTS {
+		return ErrTooManyAttempts
+	}
+	if subtle.ConstantTimeCompare([]byte(hashCode(code)), []byte(codeHash)) != 1 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// Returns true for errors caused by the user (as opposed to internal failures)
+func IsUserError(err error) bool {
+	switch err {
+	case ErrAlreadyVerified, ErrResendTooSoon, ErrNoPendingVerification, ErrCodeExpired, ErrTooManyAttempts, ErrInvalidCode:
+		return true
+	}
+	return false
+}
+
+func generateCode() (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(CODE_LENGTH), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate verification code")
+	}
+	return fmt.Sprintf("%0*d", CODE_LENGTH, n), nil
+}
+
+func hashCode(code string) string {
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
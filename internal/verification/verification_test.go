@@ -0,0 +1,47 @@
+package verification
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestCheckCode(t *testing.T) {
+	now := time.Now()
+	codeHash := hashCode("123456")
+
+	tests := []struct {
+		name      string
+		code      string
+		expiresAt time.Time
+		attempts  int
+		expected  error
+	}{
+		{"correct code", "123456", now.Add(time.Minute), 0, nil},
+		{"correct code after wrong attempts", "123456", now.Add(time.Minute), MAX_ATTEMPTS - 1, nil},
+		{"wrong code", "654321", now.Add(time.Minute), 0, ErrInvalidCode},
+		{"empty code", "", now.Add(time.Minute), 0, ErrInvalidCode},
+		{"expired code", "123456", now.Add(-time.Second), 0, ErrCodeExpired},
+		{"too many attempts", "123456", now.Add(time.Minute), MAX_ATTEMPTS, ErrTooManyAttempts},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkCode(test.code, codeHash, test.expiresAt, test.attempts, now); err != test.expected {
+				t.Errorf("checkCode() = %v, expected %v", err, test.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 100; i++ {
+		code, err := generateCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) {
+			t.Fatalf("unexpected code %q", code)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/tkhq/demo-passkey-wallet/internal/models"
+)
+
+// Event types sent to integrator webhooks
+const EVENT_USER_REGISTERED = "user.registered"
+const EVENT_SUB_ORGANIZATION_CREATED = "sub_organization.created"
+const EVENT_DROP_SENT = "drop.sent"
+const EVENT_TRANSACTION_CONFIRMED = "transaction.confirmed"
+const EVENT_RECOVERY_COMPLETED = "recovery.completed"
+const EVENT_DEPOSIT_RECEIVED = "deposit.received"
+const EVENT_ACCOUNT_DELETED = "account.deleted"
+
+var eventTypes = []string{
+	EVENT_USER_REGISTERED,
+	EVENT_SUB_ORGANIZATION_CREATED,
+	EVENT_DROP_SENT,
+	EVENT_TRANSACTION_CONFIRMED,
+	EVENT_RECOVERY_COMPLETED,
+	EVENT_DEPOSIT_RECEIVED,
+	EVENT_ACCOUNT_DELETED,
+}
+
+// Failed deliveries are retried after 30s, 1m, 2m, 4m, ... up to MAX_DELIVERY_ATTEMPTS attempts in total (~2 hours).
+// After that, they're moved to the dead-letter table.
+const MAX_DELIVERY_ATTEMPTS = 9
+const BASE_RETRY_DELAY = 30 * time.Second
+
+const WORKER_POLL_INTERVAL = 5 * time.Second
+const WORKER_BATCH_SIZE = 20
+
+// Claimed deliveries aren't picked up again by other workers for this long
+const DELIVERY_LEASE = 2 * DELIVERY_TIMEOUT
+
+// Envelope for all webhook payloads
+type Payload struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt int64       `json:"createdAt"`
+	Data      interface{} `json:"data"`
+}
+
+// Emit wakes up the worker so new deliveries don't wait for the next poll
+var wake = make(chan struct{}, 1)
+
+// Returns true for event types integrators can subscribe to (including the "*" wildcard)
+func IsValidEventType(eventType string) bool {
+	if eventType == models.WEBHOOK_ALL_EVENTS {
+		return true
+	}
+	for _, t := range eventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func NewPayload(eventType string, data interface{}) (*Payload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Payload{
+		ID:        "evt_" + hex.EncodeToString(id),
+		Type:      eventType,
+		CreatedAt: time.Now().Unix(),
+		Data:      data,
+	}, nil
+}
+
+// Queues an event for delivery to every active subscription interested in it.
+// Errors are logged rather than returned: webhooks should never fail the request emitting them.
+func Emit(eventType string, data interface{}) {
+	payload, err := NewPayload(eventType, data)
+	if err != nil {
+		log.Printf("unable to create %s webhook payload: %s", eventType, err.Error())
+		return
+	}
+	serializedPayload, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("unable to serialize %s webhook payload: %s", eventType, err.Error())
+		return
+	}
+
+	subscriptions, err := models.ListActiveWebhookSubscriptions()
+	if err != nil {
+		log.Printf("unable to list webhook subscriptions for %s event: %s", eventType, err.Error())
+		return
+	}
+
+	queued := false
+	for _, subscription := range subscriptions {
+		if !subscription.Accepts(eventType) {
+			continue
+		}
+		err := models.CreateWebhookDelivery(&models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        payload.ID,
+			EventType:      eventType,
+			Payload:        string(serializedPayload),
+		})
+		if err != nil {
+			log.Printf("unable to queue %s webhook for subscription %d: %s", eventType, subscription.ID, err.Error())
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		Wake()
+	}
+}
+
+// Asks the worker to look for due deliveries right away
+func Wake() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// Starts the background worker delivering queued webhooks
+func StartWorker() {
+	go func() {
+		ticker := time.NewTicker(WORKER_POLL_INTERVAL)
+		defer ticker.Stop()
+		for {
+			deliverDue()
+			select {
+			case <-ticker.C:
+			case <-wake:
+			}
+		}
+	}()
+}
+
+func deliverDue() {
+	deliveries, err := models.ClaimDueWebhookDeliveries(WORKER_BATCH_SIZE, DELIVERY_LEASE)
+	if err != nil {
+		log.Printf("unable to claim due webhook deliveries: %s", err.Error())
+		return
+	}
+	for i := range deliveries {
+		attemptDelivery(&deliveries[i])
+	}
+}
+
+func attemptDelivery(delivery *models.WebhookDelivery) {
+	subscription := delivery.Subscription
+
+	attempt := models.WebhookDeliveryAttempt{}
+	var retryAt *time.Time
+
+	if !subscription.Active {
+		// The subscription was disabled after this delivery was queued
+		attempt.Error = "subscription is inactive"
+	} else {
+		start := time.Now()
+		statusCode, err := Deliver(context.Background(), subscription.Url, subscription.Secret, []byte(delivery.Payload))
+		attempt.DurationMs = time.Since(start).Milliseconds()
+		if statusCode != 0 {
+			attempt.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+		}
+		if err != nil {
+			attempt.Error = err.Error()
+			if delay, ok := retryDelay(delivery.Attempts + 1); ok {
+				next := time.Now().Add(delay)
+				retryAt = &next
+			}
+		}
+	}
+
+	if attempt.Error != "" {
+		log.Printf("webhook delivery %d (%s) failed: %s", delivery.ID, delivery.EventType, attempt.Error)
+	}
+	if err := models.RecordWebhookDeliveryAttempt(delivery, attempt, retryAt); err != nil {
+		log.Printf("unable to record attempt for webhook delivery %d: %s", delivery.ID, err.Error())
+	}
+}
+
+// Returns how long to wait before retrying a delivery which failed `attempts` times, or false once it should be
+// dead-lettered
+func retryDelay(attempts int) (time.Duration, bool) {
+	if attempts >= MAX_DELIVERY_ATTEMPTS {
+		return 0, false
+	}
+	return BASE_RETRY_DELAY * time.Duration(1<<(attempts-1)), true
+}
@@ -0,0 +1,62 @@
+package webhooks
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		delay    time.Duration
+		retry    bool
+	}{
+		{1, 30 * time.Second, true},
+		{2, time.Minute, true},
+		{3, 2 * time.Minute, true},
+		{MAX_DELIVERY_ATTEMPTS - 1, 64 * time.Minute, true},
+		{MAX_DELIVERY_ATTEMPTS, 0, false},
+	}
+	for _, test := range tests {
+		delay, retry := retryDelay(test.attempts)
+		if delay != test.delay || retry != test.retry {
+			t.Errorf("retryDelay(%d) = (%s, %t), expected (%s, %t)", test.attempts, delay, retry, test.delay, test.retry)
+		}
+	}
+}
+
+func TestIsValidEventType(t *testing.T) {
+	tests := map[string]bool{
+		EVENT_DEPOSIT_RECEIVED:   true,
+		EVENT_RECOVERY_COMPLETED: true,
+		"*":                      true,
+		"deposit":                false,
+		"":                       false,
+	}
+	for eventType, valid := range tests {
+		if IsValidEventType(eventType) != valid {
+			t.Errorf("IsValidEventType(%q) = %t, expected %t", eventType, !valid, valid)
+		}
+	}
+}
+
+func TestNewPayload(t *testing.T) {
+	first, err := NewPayload(EVENT_DROP_SENT, map[string]string{"hash": "0x1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewPayload(EVENT_DROP_SENT, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(first.ID, "evt_") || len(first.ID) != len("evt_")+32 {
+		t.Errorf("unexpected event ID %q", first.ID)
+	}
+	if first.ID == second.ID {
+		t.Error("expected event IDs to be unique")
+	}
+	if first.Type != EVENT_DROP_SENT || first.CreatedAt == 0 {
+		t.Errorf("unexpected payload %+v", first)
+	}
+}
@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const SIGNATURE_HEADER = "X-Webhook-Signature"
+const TIMESTAMP_HEADER = "X-Webhook-Timestamp"
+
+const DELIVERY_TIMEOUT = 10 * time.Second
+
+// Computes the signature sent alongside webhook payloads.
+// Receivers should recompute it with their secret and compare: hex(HMAC-SHA256(secret, "<timestamp>.<body>")).
+// The timestamp is part of the signed content so that receivers can reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Checks a signature computed by Sign, in constant time. Receivers written in Go can use it as is.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// POSTs a signed JSON payload to a webhook URL.
+// The HTTP status is returned along with an error for anything other than a 2xx response.
+func Deliver(ctx context.Context, webhookUrl, secret string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot create webhook request")
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SIGNATURE_HEADER, Sign(secret, timestamp, body))
+
+	client := http.Client{
+		Timeout:   DELIVERY_TIMEOUT,
+		Transport: transport,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error while delivering webhook to %s", webhookUrl)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return res.StatusCode, fmt.Errorf("webhook endpoint %s responded with status %d: %s", webhookUrl, res.StatusCode, responseBody)
+	}
+	return res.StatusCode, nil
+}
+
+// Generates a random secret used to sign webhook payloads
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.Wrap(err, "unable to generate webhook secret")
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+// Webhook URLs must use HTTPS and point to public hosts. Plain HTTP and local or private addresses are only accepted
+// when WEBHOOK_ALLOW_PRIVATE_NETWORKS is set, to make local testing easy.
+// Hostnames can resolve to anything: deliveries check the addresses they connect to as well (see checkDialAddress).
+func ValidateUrl(webhookUrl string) error {
+	parsed, err := url.Parse(webhookUrl)
+	if err != nil {
+		return errors.Wrapf(err, "invalid webhook URL %q", webhookUrl)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: missing host", webhookUrl)
+	}
+	if allowPrivateNetworks() {
+		if parsed.Scheme != "https" && parsed.Scheme != "http" {
+			return fmt.Errorf("invalid webhook URL %q: unsupported scheme %q", webhookUrl, parsed.Scheme)
+		}
+		return nil
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("invalid webhook URL %q: only HTTPS URLs are accepted", webhookUrl)
+	}
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") || (ip != nil && !isPublicIP(ip)) {
+		return fmt.Errorf("invalid webhook URL %q: local and private addresses aren't accepted", webhookUrl)
+	}
+	return nil
+}
+
+// Deliveries connect through a dialer refusing non-public addresses. The check runs on the resolved address of each
+// connection, so hostnames resolving (or re-resolving, with DNS rebinding) to internal addresses are refused too.
+// Proxies from the environment aren't used: they would make the dialed address meaningless.
+var transport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout: DELIVERY_TIMEOUT,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkDialAddress(address)
+		},
+	}).DialContext,
+	TLSHandshakeTimeout: DELIVERY_TIMEOUT,
+	ForceAttemptHTTP2:   true,
+}
+
+func checkDialAddress(address string) error {
+	if allowPrivateNetworks() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("webhook deliveries to %s aren't allowed: not a public address", host)
+	}
+	return nil
+}
+
+// Shared address space (RFC 6598), used by carrier-grade NATs and some cloud providers internally
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+func allowPrivateNetworks() bool {
+	return os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true"
+}
@@ -0,0 +1,116 @@
+package webhooks
+
+import (
+	"testing"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"id":"evt_1"}' | openssl dgst -sha256 -hmac whsec_test
+	signature := Sign("whsec_test", 1700000000, []byte(`{"id":"evt_1"}`))
+	if signature != "sha256=c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925" {
+		t.Errorf("unexpected signature %s", signature)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	signature := Sign("whsec_test", 1700000000, body)
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      []byte
+		signature string
+		valid     bool
+	}{
+		{"valid", "whsec_test", 1700000000, body, signature, true},
+		{"wrong secret", "whsec_other", 1700000000, body, signature, false},
+		{"replayed with another timestamp", "whsec_test", 1700000001, body, signature, false},
+		{"tampered body", "whsec_test", 1700000000, []byte(`{"id":"evt_2"}`), signature, false},
+		{"missing prefix", "whsec_test", 1700000000, body, signature[len("sha256="):], false},
+		{"empty signature", "whsec_test", 1700000000, body, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := Verify(test.secret, test.timestamp, test.body, test.signature); valid != test.valid {
+				t.Errorf("Verify() = %t, expected %t", valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateUrl(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://example.com/webhooks", true},
+		{"https://93.184.216.34/webhooks", true},
+		{"http://example.com/webhooks", false},
+		{"http://localhost:8080/webhooks", false},
+		{"https://localhost/webhooks", false},
+		{"https://api.localhost/webhooks", false},
+		{"https://127.0.0.1/webhooks", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://10.0.0.1/webhooks", false},
+		{"https://192.168.1.1/webhooks", false},
+		{"https://[::1]/webhooks", false},
+		{"https://0.0.0.0/webhooks", false},
+		{"ftp://example.com/webhooks", false},
+		{"https:///webhooks", false},
+	}
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			err := ValidateUrl(test.url)
+			if test.valid && err != nil {
+				t.Errorf("expected %s to be accepted: %s", test.url, err.Error())
+			}
+			if !test.valid && err == nil {
+				t.Errorf("expected %s to be refused", test.url)
+			}
+		})
+	}
+}
+
+func TestValidateUrlAllowingPrivateNetworks(t *testing.T) {
+	t.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	if err := ValidateUrl("http://localhost:8080/webhooks"); err != nil {
+		t.Errorf("expected local URLs to be accepted: %s", err.Error())
+	}
+	if err := ValidateUrl("ftp://localhost/webhooks"); err == nil {
+		t.Error("expected unsupported schemes to be refused")
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"10.1.2.3:443", false},
+		{"172.16.0.1:443", false},
+		{"192.168.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:443", false},
+		{"0.0.0.0:443", false},
+		{"[::1]:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"[fe80::1]:443", false},
+		{"[fd00::1]:443", false},
+	}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			err := checkDialAddress(test.address)
+			if test.allowed && err != nil {
+				t.Errorf("expected %s to be allowed: %s", test.address, err.Error())
+			}
+			if !test.allowed && err == nil {
+				t.Errorf("expected %s to be refused", test.address)
+			}
+		})
+	}
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/big"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sessions"
 	gormsessions "github.com/gin-contrib/sessions/gorm"
@@ -20,30 +30,84 @@ import (
 	"github.com/pkg/errors"
 	"github.com/tidwall/gjson"
 	"github.com/tkhq/demo-passkey-wallet/internal/alchemy"
+	"github.com/tkhq/demo-passkey-wallet/internal/batches"
 	"github.com/tkhq/demo-passkey-wallet/internal/db"
+	"github.com/tkhq/demo-passkey-wallet/internal/ens"
 	"github.com/tkhq/demo-passkey-wallet/internal/ethereum"
+	"github.com/tkhq/demo-passkey-wallet/internal/events"
+	"github.com/tkhq/demo-passkey-wallet/internal/mailer"
 	"github.com/tkhq/demo-passkey-wallet/internal/models"
+	"github.com/tkhq/demo-passkey-wallet/internal/notifications"
+	"github.com/tkhq/demo-passkey-wallet/internal/ratelimit"
+	"github.com/tkhq/demo-passkey-wallet/internal/registration"
+	"github.com/tkhq/demo-passkey-wallet/internal/spending"
+	"github.com/tkhq/demo-passkey-wallet/internal/stepup"
 	"github.com/tkhq/demo-passkey-wallet/internal/turnkey"
 	"github.com/tkhq/demo-passkey-wallet/internal/types"
+	"github.com/tkhq/demo-passkey-wallet/internal/usersessions"
+	"github.com/tkhq/demo-passkey-wallet/internal/verification"
+	"github.com/tkhq/demo-passkey-wallet/internal/webhooks"
+	"github.com/tkhq/demo-passkey-wallet/pkg/siwe"
+	turnkeymodels "github.com/tkhq/go-sdk/pkg/api/models"
+	"gorm.io/gorm"
 )
 
 const SESSION_NAME = "demo_session"
-const SESSION_SALT = "demo_session_salt"
 const SESSION_USER_ID_KEY = "user_id"
 
+// Identifies the models.UserSession behind a cookie session
+const SESSION_TOKEN_KEY = "session_token"
+
+const SSE_KEEP_ALIVE_INTERVAL = 15 * time.Second
+
+// Default number of account lookups (discovery, recovery and email auth requests) allowed per IP and per minute
+const DEFAULT_DISCOVERY_RATE_LIMIT = 20
+
+// Signed requests closing an account must be at most this old
+const ACCOUNT_DELETION_MAX_REQUEST_AGE = 5 * time.Minute
+
+// Background Turnkey activities started by email challenges get this long to complete
+const EMAIL_CHALLENGE_TIMEOUT = 30 * time.Second
+
+// Number of audit events returned by the admin audit endpoint
+const AUDIT_EVENTS_PAGE_SIZE = 100
+
+// Number of recent batches returned when listing batch payments
+const BATCHES_PAGE_SIZE = 20
+
+// Matches the limit on renamed passkeys (see types.RenameAuthenticatorParams)
+const MAX_AUTHENTICATOR_NAME_LENGTH = 255
+
 const DROP_AMOUNT_IN_WEI = 50000000000000000
-const ONE_ETH_IN_WEI = int64(1000000000000000000)
+
+// Nonces issued by /api/siwe/nonce stay valid this long
+const SIWE_NONCE_TTL = 10 * time.Minute
+
+var siweNonces = siwe.NewMemoryNonceStore(SIWE_NONCE_TTL)
+
+// Attributes of the session cookie, read from the environment at startup (see usersessions.CookieOptionsFromEnv)
+var sessionCookieOptions sessions.Options
 
 type bodyLogWriter struct {
 	gin.ResponseWriter
 	body *bytes.Buffer
 }
 
+// Only error bodies are kept: other responses, such as event streams, can be long-lived and arbitrarily large
 func (w bodyLogWriter) Write(b []byte) (int, error) {
-	w.body.Write(b)
+	if w.Status() >= 500 {
+		w.body.Write(b)
+	}
 	return w.ResponseWriter.Write(b)
 }
 
+func (w bodyLogWriter) WriteString(s string) (int, error) {
+	if w.Status() >= 500 {
+		w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
 func ginErrorLogMiddleware(c *gin.Context) {
 	blw := &bodyLogWriter{body: bytes.NewBufferString(""), ResponseWriter: c.Writer}
 	c.Writer = blw
@@ -59,7 +123,19 @@ func ginErrorLogMiddleware(c *gin.Context) {
 func main() {
 	loadEnv()
 	loadDatabase()
-	ethereum.Init()
+	if err := ethereum.Init(); err != nil {
+		log.Fatalf("Unable to initialize Ethereum RPC client: %+v", err)
+	}
+	if err := ens.Init(ethereum.Client); err != nil {
+		log.Fatalf("Unable to initialize ENS resolver: %+v", err)
+	}
+	if err := mailer.Init(); err != nil {
+		log.Fatalf("Unable to initialize mailer: %+v", err)
+	}
+	ethereum.StartBlockWatcher(ethereum.DEFAULT_BLOCK_POLL_INTERVAL)
+	events.Start()
+	notifications.Start()
+	webhooks.StartWorker()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -74,6 +150,15 @@ func main() {
 	origins := strings.Split(clientOrigins, ",")
 
 	router := gin.New()
+	// Rate limits are per client IP. X-Forwarded-For is only honored for requests coming through TRUSTED_PROXIES:
+	// otherwise clients could pick their own IP.
+	var trustedProxies []string
+	if configured := os.Getenv("TRUSTED_PROXIES"); configured != "" {
+		trustedProxies = strings.Split(configured, ",")
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %+v", err)
+	}
 	router.Use(gin.Recovery())
 
 	router.Use(gin.Logger())
@@ -81,17 +166,33 @@ func main() {
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     origins,
-		AllowMethods:     []string{"GET", "POST"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
 		AllowHeaders:     []string{"content-type"},
 		AllowCredentials: true,
 		MaxAge:           600,
 	}))
 
-	store := gormsessions.NewStore(db.Database, true, []byte(SESSION_SALT))
-	store.Options(sessions.Options{MaxAge: 60 * 60 * 24}) // sessions expire daily
+	if err := usersessions.Init(); err != nil {
+		log.Fatalf("Unable to configure sessions: %+v", err)
+	}
+	if err := stepup.Init(); err != nil {
+		log.Fatalf("Unable to configure step-up authentication: %+v", err)
+	}
+	sessionKeyPairs, err := usersessions.KeyPairsFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure session keys: %+v", err)
+	}
+	sessionCookieOptions, err = usersessions.CookieOptionsFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure session cookies: %+v", err)
+	}
+	// Expired rows of the store are cleaned up along with user sessions (see usersessions.StartCleanup)
+	store := gormsessions.NewStore(db.Database, false, sessionKeyPairs...)
+	store.Options(sessionCookieOptions)
 	router.Use(sessions.Sessions(SESSION_NAME, store))
+	usersessions.StartCleanup()
 
-	err := turnkey.Init(
+	err = turnkey.Init(
 		os.Getenv("TURNKEY_API_HOST"),
 		os.Getenv("TURNKEY_API_PRIVATE_KEY"),
 		os.Getenv("TURNKEY_ORGANIZATION_ID"),
@@ -99,8 +200,21 @@ func main() {
 	if err != nil {
 		log.Fatalf("Unable to initialize Turnkey client: %+v", err)
 	}
+	turnkey.Client.PollConfig, err = turnkey.PollConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure Turnkey activity polling: %+v", err)
+	}
+	discoveryLimiter, err := ratelimit.NewLimiterFromEnv("DISCOVERY_RATE_LIMIT", DEFAULT_DISCOVERY_RATE_LIMIT, time.Minute)
+	if err != nil {
+		log.Fatalf("Unable to configure rate limits: %+v", err)
+	}
+	reconciliationInterval, err := registration.ReconciliationIntervalFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure registration reconciliation: %+v", err)
+	}
+	registration.StartReconciliation(reconciliationInterval)
 
-	userID, err := turnkey.Client.Whoami()
+	userID, err := turnkey.Client.Whoami(context.Background())
 	if err != nil {
 		log.Fatalf("Unable to use Turnkey client for whoami request: %+v", err)
 	}
@@ -110,7 +224,7 @@ func main() {
 	if turnkeyWarchestOrganizationId == "" || turnkeyWarchestPrivateKeyId == "" || err != nil {
 		log.Fatal("Cannot find configuration for Turnkey Warchest org or private key ID! Drop functionality depends on it")
 	}
-	turnkeyWarchestPrivateKeyAddress, err := turnkey.Client.GetEthereumAddress(turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId)
+	turnkeyWarchestPrivateKeyAddress, err := turnkey.Client.GetEthereumAddress(context.Background(), turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId)
 	if err != nil {
 		log.Fatalf("Unable to get Turnkey Warchest address: %s", err.Error())
 	}
@@ -131,6 +245,7 @@ func main() {
 			ctx.JSON(http.StatusOK, map[string]interface{}{
 				"userId":            user.ID,
 				"email":             user.Email,
+				"emailVerified":     user.IsVerified(),
 				"subOrganizationId": subOrganizationId,
 			})
 		} else {
@@ -149,7 +264,7 @@ func main() {
 			return
 		}
 
-		status, bodyBytes, err := turnkey.Client.ForwardSignedRequest(req.SignedWhoamiRequest.Url, req.SignedWhoamiRequest.Body, req.SignedWhoamiRequest.Stamp)
+		status, bodyBytes, err := turnkey.Client.ForwardSignedRequest(ctx.Request.Context(), req.SignedWhoamiRequest.Url, req.SignedWhoamiRequest.Body, req.SignedWhoamiRequest.Stamp)
 		if err != nil {
 			err = errors.Wrap(err, "error while forwarding signed send transaction request")
 			ctx.JSON(http.StatusInternalServerError, err.Error())
@@ -174,17 +289,29 @@ func main() {
 		})
 	})
 
-	router.GET("/api/registration/:email", func(ctx *gin.Context) {
-		email := ctx.Param("email")
-		user, err := models.FindUserByEmail(email)
-		if err == nil {
-			ctx.JSON(http.StatusOK, map[string]interface{}{
-				"userId":            user.ID,
-				"subOrganizationId": user.SubOrganizationId.String,
-			})
-		} else {
-			ctx.JSON(http.StatusNoContent, nil)
+	// Account discovery. The response is the same whether or not the email is registered: we never reveal which emails have accounts,
+	// nor their sub-organization IDs. Clients stamp a whoami request for our (parent) organization instead; Turnkey resolves
+	// the sub-organization of the passkey or email credential used, and /api/authenticate reads it from Turnkey's response.
+	router.GET("/api/registration/:email", discoveryLimiter.Middleware, func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"organizationId": turnkey.Client.OrganizationID,
+		})
+	})
+
+	// Emails used by accounts which were never verified can be claimed by their owner: we send a code to the email,
+	// and registering with it replaces the unverified account. The response doesn't depend on the email.
+	router.POST("/api/registration/claim", discoveryLimiter.Middleware, func(ctx *gin.Context) {
+		var params types.ClaimEmailParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
 		}
+		if err := verification.SendClaim(params.Email); err != nil {
+			log.Printf("unable to send claim code: %s", err.Error())
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"message": "if this email is used by an unverified account, we sent it a code. Register with it to replace the account.",
+		})
 	})
 
 	router.POST("/api/register", func(ctx *gin.Context) {
@@ -194,30 +321,52 @@ func main() {
 			return
 		}
 
-		user, err := models.CreateUser(requestBody.Email)
+		transports, err := turnkey.ParseAuthenticatorTransports(requestBody.Attestation.Transports)
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, err)
+			ctx.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
-
-		subOrgResult, err := turnkey.Client.CreateUserSubOrganization(requestBody.Email, requestBody.Attestation, requestBody.Challenge)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, err.Error())
-			return
+		authenticatorName := strings.TrimSpace(requestBody.AuthenticatorName)
+		if authenticatorName == "" {
+			authenticatorName = turnkey.DEFAULT_AUTHENTICATOR_NAME
 		}
-
-		if _, err = models.UpdateUserTurnkeySubOrganization(user.ID, subOrgResult.SubOrganizationId); err != nil {
-			ctx.JSON(http.StatusInternalServerError, err.Error())
+		if len(authenticatorName) > MAX_AUTHENTICATOR_NAME_LENGTH {
+			ctx.JSON(http.StatusBadRequest, fmt.Sprintf("authenticator name cannot be longer than %d characters", MAX_AUTHENTICATOR_NAME_LENGTH))
 			return
 		}
 
-		pk, err := models.SaveWalletForUser(user, subOrgResult.WalletId, subOrgResult.EthereumAddress)
+		// Retrying with the same passkey (same challenge) resumes a registration which failed half-way
+		reg, err := registration.Run(ctx.Request.Context(), registration.Params{
+			Email:             requestBody.Email,
+			Attestation:       requestBody.Attestation,
+			Challenge:         requestBody.Challenge,
+			AuthenticatorName: authenticatorName,
+			Transports:        transports,
+			ClaimCode:         strings.TrimSpace(requestBody.ClaimCode),
+		})
 		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, err.Error())
+			var timeoutErr *turnkey.ActivityTimeoutError
+			switch {
+			case err == models.ErrEmailAlreadyRegistered || err == models.ErrRegistrationInProgress:
+				ctx.JSON(http.StatusConflict, err.Error())
+			case verification.IsUserError(err):
+				ctx.JSON(http.StatusBadRequest, err.Error())
+			case errors.As(err, &timeoutErr):
+				ctx.JSON(http.StatusAccepted, map[string]interface{}{
+					"message": "sub-organization creation is taking longer than expected. Retry with the same passkey to resume registration.",
+				})
+			default:
+				ctx.JSON(http.StatusInternalServerError, err.Error())
+			}
 			return
 		}
-		log.Printf("Wallet account successfully saved: %+v", pk)
+		user := reg.User
 
+		if !user.IsVerified() {
+			if err := verification.Send(&user); err != nil {
+				log.Printf("unable to send verification code to user %d: %s", user.ID, err.Error())
+			}
+		}
 		startUserLoginSession(ctx, user.ID)
 		ctx.String(http.StatusOK, "Account successfully created")
 	})
@@ -231,26 +380,23 @@ func main() {
 
 		log.Printf("Current request: %v\n", req)
 
-		var subOrganizationId string
-
-		if req.SubOrganizationId != "" {
-			subOrganizationId = req.SubOrganizationId
-		} else {
-			status, bodyBytes, err := turnkey.Client.ForwardSignedRequest(req.SignedWhoamiRequest.Url, req.SignedWhoamiRequest.Body, req.SignedWhoamiRequest.Stamp)
-			if err != nil {
-				err = errors.Wrap(err, "error while forwarding signed whoami request")
-				ctx.JSON(http.StatusInternalServerError, err.Error())
-				return
-			}
-
-			if status != 200 {
-				ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("expected 200 when forwarding whoami request. Got %d", status))
-				return
-			}
-
-			subOrganizationId = gjson.Get(string(bodyBytes), "organizationId").String()
+		// The sub-organization always comes from Turnkey's response: a valid stamp is the proof of passkey (or email) ownership
+		status, bodyBytes, err := turnkey.Client.ForwardSignedRequest(ctx.Request.Context(), req.SignedWhoamiRequest.Url, req.SignedWhoamiRequest.Body, req.SignedWhoamiRequest.Stamp)
+		if err != nil {
+			err = errors.Wrap(err, "error while forwarding signed whoami request")
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if status == http.StatusUnauthorized {
+			ctx.JSON(http.StatusUnauthorized, "authentication failed")
+			return
+		}
+		if status != 200 {
+			ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("expected 200 when forwarding whoami request. Got %d", status))
+			return
 		}
 
+		subOrganizationId := gjson.Get(string(bodyBytes), "organizationId").String()
 		log.Printf("Suborganization ID: %v\n", subOrganizationId)
 
 		user, err := models.FindUserBySubOrganizationId(subOrganizationId)
@@ -258,6 +404,10 @@ func main() {
 			ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("Unable to find user for suborg ID %s", subOrganizationId))
 			return
 		}
+		if user.ID == 0 || subOrganizationId == "" {
+			ctx.JSON(http.StatusUnauthorized, "authentication failed")
+			return
+		}
 
 		log.Printf("Current user: %v\n", user)
 
@@ -270,6 +420,118 @@ func main() {
 		ctx.String(http.StatusNoContent, "")
 	})
 
+	// Step-up authentication: sensitive endpoints (exports) require the user to have stamped a whoami with one of their
+	// passkeys within the last few minutes, on top of being logged in
+	router.POST("/api/step-up", func(ctx *gin.Context) {
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		var req types.WhoamiRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		err := stepup.Verify(ctx.Request.Context(), userSession, user, req.SignedWhoamiRequest)
+		if err != nil {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_STEP_UP, models.AUDIT_OUTCOME_FAILED, err.Error())
+			if stepup.IsUserError(err) {
+				ctx.String(http.StatusUnauthorized, err.Error())
+			} else {
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_STEP_UP, models.AUDIT_OUTCOME_SUCCEEDED, "")
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"steppedUpAt": userSession.SteppedUpAt.Time,
+			"expiresAt":   userSession.SteppedUpAt.Time.Add(stepup.Settings.MaxAge),
+		})
+	})
+
+	// Lists the current user's active sessions (devices they're logged in on), flagging the one making the request
+	router.GET("/api/sessions", func(ctx *gin.Context) {
+		currentSession := getCurrentUserSession(ctx)
+		if currentSession == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		userSessions, err := models.ListActiveUserSessions(currentSession.UserID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		response := []map[string]interface{}{}
+		for _, s := range userSessions {
+			// Idle sessions can't be used anymore, even though cleanup hasn't deleted them yet
+			if time.Since(s.LastSeenAt) > usersessions.Settings.IdleTimeout {
+				continue
+			}
+			response = append(response, map[string]interface{}{
+				"id":         s.ID,
+				"userAgent":  s.UserAgent,
+				"ipAddress":  s.IpAddress,
+				"createdAt":  s.CreatedAt,
+				"lastSeenAt": s.LastSeenAt,
+				"expiresAt":  s.ExpiresAt,
+				"current":    s.ID == currentSession.ID,
+			})
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"sessions": response,
+		})
+	})
+
+	// Logs one of the current user's sessions out
+	router.POST("/api/sessions/:id/revoke", func(ctx *gin.Context) {
+		currentSession := getCurrentUserSession(ctx)
+		if currentSession == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		sessionId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid session ID")
+			return
+		}
+		if uint(sessionId) == currentSession.ID {
+			endUserSession(ctx)
+			ctx.String(http.StatusNoContent, "")
+			return
+		}
+
+		err = models.RevokeUserSession(currentSession.UserID, uint(sessionId))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "session not found")
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.String(http.StatusNoContent, "")
+	})
+
+	// Logs the current user out everywhere but here
+	router.POST("/api/sessions/revoke-others", func(ctx *gin.Context) {
+		currentSession := getCurrentUserSession(ctx)
+		if currentSession == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		revoked, err := models.RevokeUserSessions(currentSession.UserID, currentSession.Token)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"revoked": revoked,
+		})
+	})
+
 	router.GET("/api/wallet", func(ctx *gin.Context) {
 		user := getCurrentUser(ctx)
 		if user == nil {
@@ -282,7 +544,7 @@ func main() {
 			return
 		}
 
-		balance, err := ethereum.GetBalance(wallet.EthereumAddress)
+		balance, err := ethereum.GetBalance(ctx.Request.Context(), wallet.EthereumAddress)
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve balance").Error())
 			return
@@ -291,7 +553,7 @@ func main() {
 		ctx.JSON(http.StatusOK, map[string]interface{}{
 			"address":     wallet.EthereumAddress,
 			"turnkeyUuid": wallet.TurnkeyUUID,
-			"balance":     formatBalance(balance),
+			"balance":     ethereum.FormatEth(balance),
 			"dropsLeft":   wallet.DropsLeft(),
 		})
 	})
@@ -313,19 +575,19 @@ func main() {
 			return
 		}
 
-		unsignedDropTx, err := ethereum.ConstructTransfer(turnkeyWarchestPrivateKeyAddress, wallet.EthereumAddress, big.NewInt(DROP_AMOUNT_IN_WEI), nil)
+		unsignedDropTx, err := ethereum.ConstructTransfer(ctx.Request.Context(), turnkeyWarchestPrivateKeyAddress, wallet.EthereumAddress, big.NewInt(DROP_AMOUNT_IN_WEI), nil)
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to construct drop transfer").Error())
 			return
 		}
 
-		signedDropTx, err := turnkey.Client.SignTransaction(turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId, hex.EncodeToString(unsignedDropTx))
+		signedDropTx, err := turnkey.Client.SignTransaction(ctx.Request.Context(), turnkeyWarchestOrganizationId, turnkeyWarchestPrivateKeyId, hex.EncodeToString(unsignedDropTx))
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to sign drop transfer").Error())
 			return
 		}
 
-		txHash, err := ethereum.BroadcastTransaction(signedDropTx)
+		txHash, err := ethereum.BroadcastTransaction(ctx.Request.Context(), signedDropTx)
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to broadcast drop transfer").Error())
 			return
@@ -336,6 +598,12 @@ func main() {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to persist drop in DB").Error())
 			return
 		}
+		webhooks.Emit(webhooks.EVENT_DROP_SENT, map[string]interface{}{
+			"userId":  user.ID,
+			"address": wallet.EthereumAddress,
+			"amount":  ethereum.FormatEth(big.NewInt(DROP_AMOUNT_IN_WEI)),
+			"hash":    txHash,
+		})
 
 		ctx.JSON(http.StatusOK, map[string]interface{}{
 			"hash": txHash,
@@ -366,23 +634,125 @@ func main() {
 			return
 		}
 
-		amount, err := strconv.ParseFloat(params.Amount, 64)
+		var contact *models.Contact
+		var destination common.Address
+		var ensName string
+		switch {
+		case params.ContactID != 0 && params.Destination != "":
+			ctx.String(http.StatusBadRequest, "pass either destination or contactId, not both")
+			return
+		case params.ContactID != 0:
+			contact, err = models.FindContactForUser(user.ID, params.ContactID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				ctx.String(http.StatusNotFound, "contact not found")
+				return
+			}
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if contact.ChainID != ethereum.ChainID.Int64() {
+				ctx.String(http.StatusBadRequest, fmt.Sprintf("contact %q is on chain %d, the wallet is on chain %d", contact.Label, contact.ChainID, ethereum.ChainID.Int64()))
+				return
+			}
+			destination = common.HexToAddress(contact.Address)
+		case params.Destination != "":
+			destination, ensName, err = resolveDestination(ctx.Request.Context(), params.Destination)
+			if err != nil {
+				ctx.String(destinationErrorStatus(err), err.Error())
+				return
+			}
+			contact, err = models.FindContactByAddress(user.ID, destination.Hex(), ethereum.ChainID.Int64())
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		default:
+			ctx.String(http.StatusBadRequest, "destination or contactId is required")
+			return
+		}
+
+		calldata, summary, err := constructTxCalldata(params)
 		if err != nil {
-			ctx.String(http.StatusBadRequest, errors.Wrapf(err, "unable to convert amount (%q) to float", params.Amount).Error())
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		// Contract calls often don't transfer any ETH
+		if params.Amount == "" && calldata == nil {
+			ctx.String(http.StatusBadRequest, "amount is required for transfers")
+			return
+		}
+		value := big.NewInt(0)
+		if params.Amount != "" {
+			value, err = ethereum.ParseEth(params.Amount)
+			if err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if value.Sign() == 0 && calldata == nil {
+			ctx.String(http.StatusBadRequest, "amount must be greater than zero")
+			return
+		}
+
+		if err := checkSpendingPolicy(user, spending.Transaction{To: destination, Value: value, Data: calldata}); err != nil {
+			respondWithSpendingPolicyError(ctx, err)
 			return
 		}
 
-		unsignedTransaction, err := ethereum.ConstructTransfer(wallet.EthereumAddress, params.Destination, big.NewInt(int64(amount*float64(ONE_ETH_IN_WEI))), nil)
+		var unsignedTransaction []byte
+		gasLimit := ethereum.TRANSFER_GAS_LIMIT
+		if calldata == nil {
+			unsignedTransaction, err = ethereum.ConstructTransfer(ctx.Request.Context(), wallet.EthereumAddress, destination.Hex(), value, nil)
+		} else {
+			unsignedTransaction, gasLimit, err = ethereum.ConstructContractCall(ctx.Request.Context(), wallet.EthereumAddress, destination.Hex(), value, calldata, nil)
+		}
 		if err != nil {
+			if ethereum.IsExecutionError(err) {
+				// Gas estimation reverted: simulate to tell users why
+				response := map[string]interface{}{
+					"message": errors.Wrap(err, "transaction would fail").Error(),
+				}
+				if simulation, simulationErr := ethereum.Simulate(ctx.Request.Context(), wallet.EthereumAddress, destination.Hex(), value, calldata, 0); simulationErr == nil {
+					response["simulation"] = simulation
+				}
+				ctx.JSON(http.StatusBadRequest, response)
+				return
+			}
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to construct transaction").Error())
 			return
 		}
 
-		ctx.JSON(http.StatusOK, map[string]interface{}{
+		// Simulation is informative: users can still sign if it's unavailable
+		simulation, err := ethereum.Simulate(ctx.Request.Context(), wallet.EthereumAddress, destination.Hex(), value, calldata, gasLimit)
+		if err != nil {
+			log.Printf("unable to simulate transaction from %s: %s", wallet.EthereumAddress, err.Error())
+		}
+
+		response := map[string]interface{}{
 			"unsignedTransaction": hex.EncodeToString(unsignedTransaction),
 			"address":             wallet.EthereumAddress,
 			"organizationId":      user.SubOrganizationId.String,
-		})
+			"gasLimit":            gasLimit,
+			"simulation":          simulation,
+			"destination":         destination.Hex(),
+			"contact":             contact,
+			"warnings":            []string{},
+		}
+		if ensName != "" {
+			response["ensName"] = ensName
+		}
+		// A typo'd or swapped address usually shows up as one never sent to before
+		newRecipient := isNewRecipient(ctx.Request.Context(), user, wallet, destination)
+		response["newRecipient"] = newRecipient
+		if newRecipient {
+			response["warnings"] = []string{"You have never sent a transaction to this address. Double-check it before signing."}
+		}
+		if calldata != nil {
+			response["data"] = "0x" + hex.EncodeToString(calldata)
+			response["summary"] = summary
+		}
+		ctx.JSON(http.StatusOK, response)
 	})
 
 	router.POST("/api/wallet/send-tx", func(ctx *gin.Context) {
@@ -399,196 +769,2607 @@ func main() {
 			return
 		}
 
-		status, responseBytes, err := turnkey.Client.ForwardSignedRequest(
-			params.SignedSendTx.Url, params.SignedSendTx.Body, params.SignedSendTx.Stamp)
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
 
+		unlock, err := models.LockSpending(user.ID)
 		if err != nil {
-			err = errors.Wrap(err, "error while forwarding signed send transaction request")
-			ctx.JSON(http.StatusInternalServerError, err.Error())
+			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		defer unlock()
 
-		if status != 200 {
-			ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("expected 200 when forwarding signed transaction request. Got %d", status))
+		// The transaction is checked again here: construct-tx can be skipped
+		if err := checkSignTransactionRequest(user, params.SignedSendTx); err != nil {
+			respondWithSpendingPolicyError(ctx, err)
+			return
+		}
+
+		responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), params.SignedSendTx.Url, params.SignedSendTx.Body, params.SignedSendTx.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed send transaction request")
 			return
 		}
 
 		signedTransaction := gjson.Get(string(responseBytes), "activity.result.signTransactionResult.signedTransaction").String()
 
-		hash, err := ethereum.BroadcastTransaction(signedTransaction)
+		hash, err := ethereum.BroadcastTransaction(ctx.Request.Context(), signedTransaction)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("error while broadcasting signed transaction %q", signedTransaction))
 			return
 		}
 
+		// The transaction is out: failing to track it shouldn't fail the request
+		if err := recordBroadcastTransaction(user, wallet, signedTransaction, hash); err != nil {
+			log.Printf("unable to record broadcast transaction %s: %s", hash, err.Error())
+		}
+
 		ctx.JSON(http.StatusOK, map[string]interface{}{
 			"hash": hash,
 		})
 	})
 
-	router.GET("/api/wallet/history", func(ctx *gin.Context) {
+	// Prepares a personal_sign (EIP-191) or EIP-712 typed-data digest for the current user's wallet to sign.
+	// Clients stamp a SIGN_RAW_PAYLOAD activity for it (hex encoding, no-op hash function: the digest is already hashed).
+	router.POST("/api/wallet/construct-signature", func(ctx *gin.Context) {
+		var params types.SignPayloadParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
 		user := getCurrentUser(ctx)
 		if user == nil {
 			ctx.String(http.StatusForbidden, "no current user")
 			return
 		}
-
 		wallet, err := models.GetWalletForUser(*user)
 		if err != nil {
 			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
 			return
 		}
 
-		history, err := alchemy.TransactionHistory(wallet.EthereumAddress)
+		digest, err := signaturePayloadDigest(params)
 		if err != nil {
-			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to get transaction history").Error())
+			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
-		ctx.JSON(http.StatusOK, history)
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"payload":        hex.EncodeToString(digest),
+			"encoding":       turnkeymodels.PayloadEncodingHexadecimal,
+			"hashFunction":   turnkeymodels.HashFunctionNoOp,
+			"address":        wallet.EthereumAddress,
+			"organizationId": user.SubOrganizationId.String,
+		})
 	})
 
-	router.POST("/api/wallet/export", func(ctx *gin.Context) {
-		var req types.ExportRequest
-		err = ctx.BindJSON(&req)
-		if err != nil {
+	// Forwards a signed SIGN_RAW_PAYLOAD activity for a digest prepared by construct-signature, and returns the
+	// 65-byte Ethereum signature once we've checked it recovers to the wallet's address
+	router.POST("/api/wallet/sign-payload", func(ctx *gin.Context) {
+		var req types.SignPayloadRequest
+		if err := ctx.BindJSON(&req); err != nil {
 			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		bodyBytes, err := turnkey.Client.ForwardSignedActivity(req.SignedExportRequest.Url, req.SignedExportRequest.Body, req.SignedExportRequest.Stamp)
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
 		if err != nil {
-			err = errors.Wrap(err, "error while forwarding signed EXPORT_WALLET activity")
-			ctx.JSON(http.StatusInternalServerError, err.Error())
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
 			return
 		}
-		exportBundle := gjson.Get(string(bodyBytes), "activity.result.exportWalletResult.exportBundle").String()
-
-		ctx.JSON(http.StatusOK, exportBundle)
-	})
 
-	router.POST("/api/init-recovery", func(ctx *gin.Context) {
-		var params types.RecoveryParams
-		err := ctx.BindJSON(&params)
+		digest, err := signaturePayloadDigest(req.SignPayloadParams)
 		if err != nil {
 			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
+		signedRequest := req.SignedSignRawPayloadRequest
+		if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeSignRawPayload); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateSignRawPayloadParameters(signedRequest, wallet.EthereumAddress, digest); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
 
-		user, err := models.FindUserByEmail(params.Email)
+		responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), signedRequest.Url, signedRequest.Body, signedRequest.Stamp)
 		if err != nil {
-			ctx.String(http.StatusForbidden, "no user found")
+			respondWithActivityError(ctx, err, "error while forwarding signed SIGN_RAW_PAYLOAD activity")
 			return
 		}
-		subOrganizationId := user.SubOrganizationId.String
 
-		turnkeyUserUuid, err := turnkey.Client.InitRecovery(subOrganizationId, user.Email, params.TargetPublicKey)
+		result := gjson.GetBytes(responseBytes, "activity.result.signRawPayloadResult")
+		signature, err := ethereum.AssembleSignature(result.Get("r").String(), result.Get("s").String(), result.Get("v").String())
 		if err != nil {
-			ctx.String(http.StatusInternalServerError, fmt.Sprintf("error while initializing recovery: %s", err.Error()))
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := ethereum.VerifySignature(digest, signature, wallet.EthereumAddress); err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to verify signature").Error())
 			return
 		}
 
 		ctx.JSON(http.StatusOK, map[string]interface{}{
-			"userId":         turnkeyUserUuid,
-			"organizationId": subOrganizationId,
+			"signature": "0x" + hex.EncodeToString(signature),
+			"digest":    "0x" + hex.EncodeToString(digest),
+			"address":   wallet.EthereumAddress,
 		})
 	})
 
-	router.POST("/api/recover", func(ctx *gin.Context) {
-		var req types.RecoverRequest
-		err := ctx.BindJSON(&req)
+	// Sign-In With Ethereum (EIP-4361): users log into third-party dapps with their wallet. The message built here is signed
+	// like any personal_sign payload (see construct-signature and sign-payload), then checked by the relying party,
+	// either with the siwe package or with the verify endpoint below.
+	router.GET("/api/siwe/nonce", func(ctx *gin.Context) {
+		nonce, err := siweNonces.Issue()
 		if err != nil {
-			ctx.String(http.StatusBadRequest, err.Error())
+			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"nonce": nonce,
+		})
+	})
 
-		_, err = turnkey.Client.ForwardSignedActivity(req.SignedRecoverRequest.Url, req.SignedRecoverRequest.Body, req.SignedRecoverRequest.Stamp)
-		if err != nil {
-			if strings.Contains(err.Error(), "no valid user found for authenticator") && strings.Contains(err.Error(), "Got status 401") {
-				// This is a tad weird, but while forwarding `RECOVER_USER`` activities, the "success" indicator isn't the usual "ACTIVITY_STATUS_COMPLETE",
-				// because the credential used to authenticate the request (in the stamp) is the _temporary_ recovery credential.
-				// The `RECOVER_USER` activity deletes this temporary credential and adds the new passkey instead, which means it loses any privileges on the org,
-				// which includes having read access to anything! Hence we expect this authentication error to happen when the activity completes.
-				// Another, less awkward way to ensure the activity is actually complete would be to poll with our backend API key since it has read permissions.
-				ctx.JSON(http.StatusOK, map[string]interface{}{})
-				return
-			}
-			err = errors.Wrap(err, "error while forwarding signed RECOVER_USER activity")
-			ctx.JSON(http.StatusInternalServerError, err.Error())
+	router.POST("/api/siwe/message", func(ctx *gin.Context) {
+		var params types.SiweMessageParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
 			return
 		}
 
-		ctx.JSON(http.StatusOK, map[string]interface{}{})
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		nonce := params.Nonce
+		if nonce == "" {
+			nonce, err = siweNonces.Issue()
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		message := siwe.Message{
+			Domain:    params.Domain,
+			Address:   common.HexToAddress(wallet.EthereumAddress).Hex(),
+			Statement: params.Statement,
+			URI:       params.Uri,
+			Version:   siwe.VERSION,
+			ChainID:   ethereum.ChainID.Int64(),
+			Nonce:     nonce,
+			IssuedAt:  time.Now(),
+			RequestID: params.RequestId,
+			Resources: params.Resources,
+		}
+		if params.ExpirationTime != nil {
+			message.ExpirationTime = *params.ExpirationTime
+		}
+		if err := message.Validate(); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"message": message.String(),
+			"nonce":   nonce,
+		})
+	})
+
+	// Verifier for relying parties which don't check signatures themselves. Doesn't require a session. Messages must be
+	// signed for the given domain and our chain.
+	router.POST("/api/siwe/verify", func(ctx *gin.Context) {
+		var params types.SiweVerifyParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		opts := siwe.VerifyOptions{
+			Domain:  params.Domain,
+			ChainID: ethereum.ChainID.Int64(),
+			Nonce:   params.Nonce,
+		}
+		if params.Nonce == "" {
+			opts.Nonces = siweNonces
+		}
+		message, err := siwe.Verify(params.Message, params.Signature, opts)
+		if err != nil {
+			if siwe.IsVerificationError(err) {
+				ctx.String(http.StatusUnauthorized, err.Error())
+			} else {
+				ctx.String(http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+
+		response := map[string]interface{}{
+			"address":  message.Address,
+			"domain":   message.Domain,
+			"chainId":  message.ChainID,
+			"nonce":    message.Nonce,
+			"issuedAt": message.IssuedAt,
+		}
+		if !message.ExpirationTime.IsZero() {
+			response["expirationTime"] = message.ExpirationTime
+		}
+		ctx.JSON(http.StatusOK, response)
+	})
+
+	router.GET("/api/wallet/history", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		history, err := alchemy.TransactionHistory(ctx.Request.Context(), wallet.EthereumAddress)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to get transaction history").Error())
+			return
+		}
+		addHistoryNames(ctx.Request.Context(), history)
+		ctx.JSON(http.StatusOK, history)
+	})
+
+	// Address book. Contacts are saved per chain: the wallet lives on one chain, but users may keep addresses for others.
+	router.GET("/api/contacts", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		contacts, err := models.ListContactsForUser(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"contacts": contacts,
+		})
+	})
+
+	router.POST("/api/contacts", func(ctx *gin.Context) {
+		var params types.ContactParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		contact := models.Contact{
+			UserID: user.ID,
+			Source: models.CONTACT_SOURCE_MANUAL,
+		}
+		if err := applyContactParams(ctx.Request.Context(), wallet, params, &contact); err != nil {
+			ctx.String(destinationErrorStatus(err), err.Error())
+			return
+		}
+		err = models.CreateContact(&contact)
+		if err == models.ErrDuplicateContact {
+			ctx.String(http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusCreated, contact)
+	})
+
+	router.PUT("/api/contacts/:id", func(ctx *gin.Context) {
+		var params types.ContactParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+		contactId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid contact ID")
+			return
+		}
+		contact, err := models.FindContactForUser(user.ID, uint(contactId))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "contact not found")
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if err := applyContactParams(ctx.Request.Context(), wallet, params, contact); err != nil {
+			ctx.String(destinationErrorStatus(err), err.Error())
+			return
+		}
+		err = models.UpdateContact(contact)
+		if err == models.ErrDuplicateContact {
+			ctx.String(http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, contact)
+	})
+
+	router.DELETE("/api/contacts/:id", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		contactId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid contact ID")
+			return
+		}
+		err = models.DeleteContact(user.ID, uint(contactId))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "contact not found")
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.String(http.StatusNoContent, "")
+	})
+
+	// Server-Sent Events stream with live updates for the current user's wallet:
+	// balance changes, incoming transfers, and status changes of transactions sent through send-tx.
+	router.GET("/api/wallet/events", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		walletEvents, unsubscribe := events.Subscribe(user.ID, wallet.EthereumAddress)
+		defer unsubscribe()
+
+		ctx.Header("Cache-Control", "no-cache")
+		ctx.Header("X-Accel-Buffering", "no")
+
+		// Start with the current balance so clients don't need a separate request
+		if balance, err := ethereum.GetBalance(ctx.Request.Context(), wallet.EthereumAddress); err == nil {
+			ctx.SSEvent(events.EVENT_TYPE_BALANCE, events.BalanceEvent{
+				Address: wallet.EthereumAddress,
+				Balance: ethereum.FormatEth(balance),
+			})
+		}
+
+		keepAlive := time.NewTicker(SSE_KEEP_ALIVE_INTERVAL)
+		defer keepAlive.Stop()
+
+		ctx.Stream(func(w io.Writer) bool {
+			select {
+			case <-ctx.Request.Context().Done():
+				return false
+			case event, ok := <-walletEvents:
+				if !ok {
+					return false
+				}
+				ctx.SSEvent(event.Type, event.Data)
+				return true
+			case <-keepAlive.C:
+				ctx.SSEvent("ping", "")
+				return true
+			}
+		})
+	})
+
+	router.GET("/api/notifications/preferences", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		preference, err := models.GetNotificationPreferenceForUser(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, notificationPreferenceResponse(preference, false))
+	})
+
+	router.POST("/api/notifications/preferences", func(ctx *gin.Context) {
+		var params types.NotificationPreferencesParams
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		preference, err := models.GetNotificationPreferenceForUser(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if params.EmailDeposits != nil {
+			preference.EmailDeposits = *params.EmailDeposits
+		}
+
+		// The signing secret is (re)generated whenever the webhook URL changes, and only shown in that response
+		newSecret := false
+		if params.WebhookUrl != nil && *params.WebhookUrl != preference.WebhookUrl.String {
+			if *params.WebhookUrl == "" {
+				preference.WebhookUrl = sql.NullString{}
+				preference.WebhookSecret = ""
+			} else {
+				if err := webhooks.ValidateUrl(*params.WebhookUrl); err != nil {
+					ctx.String(http.StatusBadRequest, err.Error())
+					return
+				}
+				secret, err := webhooks.GenerateSecret()
+				if err != nil {
+					ctx.String(http.StatusInternalServerError, err.Error())
+					return
+				}
+				preference.WebhookUrl = sql.NullString{String: *params.WebhookUrl, Valid: true}
+				preference.WebhookSecret = secret
+				newSecret = true
+			}
+		}
+
+		if err := models.SaveNotificationPreference(preference); err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to save notification preferences").Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, notificationPreferenceResponse(preference, newSecret))
+	})
+
+	// Builds the transaction moving a user's entire balance out before they delete their account.
+	// It's signed like any other transaction, and passed to DELETE /api/account as a signed SIGN_TRANSACTION request.
+	router.POST("/api/account/sweep-tx", func(ctx *gin.Context) {
+		var params types.SweepTxParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		destination, ensName, err := resolveDestination(ctx.Request.Context(), params.Destination)
+		if err != nil {
+			ctx.String(destinationErrorStatus(err), err.Error())
+			return
+		}
+
+		unsignedTransaction, amount, err := ethereum.ConstructSweep(ctx.Request.Context(), wallet.EthereumAddress, destination.Hex())
+		if err == ethereum.ErrNothingToSweep {
+			ctx.String(http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to construct sweep transaction").Error())
+			return
+		}
+		if err := checkSpendingPolicy(user, spending.Transaction{To: destination, Value: amount}); err != nil {
+			respondWithSpendingPolicyError(ctx, err)
+			return
+		}
+
+		response := map[string]interface{}{
+			"unsignedTransaction": hex.EncodeToString(unsignedTransaction),
+			"amount":              ethereum.FormatEth(amount),
+			"address":             wallet.EthereumAddress,
+			"organizationId":      user.SubOrganizationId.String,
+			"destination":         destination.Hex(),
+		}
+		if ensName != "" {
+			response["ensName"] = ensName
+		}
+		ctx.JSON(http.StatusOK, response)
+	})
+
+	// Closes the current user's account. Users prove they're present by signing a DELETE_AUTHENTICATORS activity
+	// removing all their passkeys, which also disables their Turnkey user. Funds left in the wallet become inaccessible:
+	// users either sweep them first (see /api/account/sweep-tx) or explicitly acknowledge the loss.
+	// The steps (sweep, co-signer removal, DELETE_AUTHENTICATORS) can't be undone: when one fails after another went
+	// through, the partial state is audited. Users retry the deletion with a fresh DELETE_AUTHENTICATORS request
+	// (the co-signer is only removed if it's still there, and what a sweep leaves is dust, for acknowledgeBalanceLoss),
+	// or keep their account and re-enroll the co-signer with POST /api/quorum/approvers.
+	router.DELETE("/api/account", func(ctx *gin.Context) {
+		var req types.DeleteAccountRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		signedRequest := req.SignedDeleteAuthenticatorsRequest
+		if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeDeleteAuthenticators); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateFreshSignedRequest(signedRequest, ACCOUNT_DELETION_MAX_REQUEST_AGE); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		turnkeyUserId := gjson.Get(signedRequest.Body, "parameters.userId").String()
+		toDelete := map[string]bool{}
+		for _, id := range gjson.Get(signedRequest.Body, "parameters.authenticatorIds").Array() {
+			toDelete[id.String()] = true
+		}
+		existing, err := turnkey.Client.GetAuthenticators(ctx.Request.Context(), user.SubOrganizationId.String, turnkeyUserId)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, a := range existing {
+			if !toDelete[*a.AuthenticatorID] {
+				ctx.String(http.StatusBadRequest, fmt.Sprintf("all passkeys must be deleted to close the account. Missing %s", *a.AuthenticatorID))
+				return
+			}
+		}
+
+		// Irreversible steps taken so far, for the audit trail if a later one fails
+		var completedSteps []string
+		auditPartialDeletion := func(failedStep string, err error) {
+			if len(completedSteps) == 0 {
+				return
+			}
+			details := fmt.Sprintf("%s failed after %s: %s", failedStep, strings.Join(completedSteps, ", "), err.Error())
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_ACCOUNT_DELETION, models.AUDIT_OUTCOME_FAILED, details)
+		}
+
+		var sweepHash string
+		if req.SignedSweepRequest != nil {
+			if err := validateSignedRequest(*req.SignedSweepRequest, user, turnkeymodels.ActivityTypeSignTransaction); err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			unlock, err := models.LockSpending(user.ID)
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			defer unlock()
+			if err := checkUnsignedTransaction(user, gjson.Get(req.SignedSweepRequest.Body, "parameters.unsignedTransaction").String()); err != nil {
+				respondWithSpendingPolicyError(ctx, err)
+				return
+			}
+			responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedSweepRequest.Url, req.SignedSweepRequest.Body, req.SignedSweepRequest.Stamp)
+			if err != nil {
+				respondWithActivityError(ctx, err, "error while forwarding signed sweep transaction request")
+				return
+			}
+			signedTransaction := gjson.GetBytes(responseBytes, "activity.result.signTransactionResult.signedTransaction").String()
+			sweepHash, err = ethereum.BroadcastTransaction(ctx.Request.Context(), signedTransaction)
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, errors.Wrap(err, "error while broadcasting sweep transaction").Error())
+				return
+			}
+			if err := recordBroadcastTransaction(user, wallet, signedTransaction, sweepHash); err != nil {
+				log.Printf("unable to record sweep transaction %s: %s", sweepHash, err.Error())
+			}
+			completedSteps = append(completedSteps, "sweep "+sweepHash)
+		} else {
+			balance, err := ethereum.GetBalance(ctx.Request.Context(), wallet.EthereumAddress)
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			if balance.Sign() > 0 && !req.AcknowledgeBalanceLoss {
+				ctx.JSON(http.StatusConflict, map[string]interface{}{
+					"message": "the wallet still holds funds, which will be lost. Sweep them first, or set acknowledgeBalanceLoss",
+					"balance": ethereum.FormatEth(balance),
+				})
+				return
+			}
+		}
+
+		// The co-signer goes first, while the user still has passkeys: if the sub-organization needs more approvals
+		// (202 with the activity), they approve it and retry the deletion.
+		if user.SubOrganizationId.Valid {
+			removed, err := turnkey.Client.RemoveCoSigner(ctx.Request.Context(), user.SubOrganizationId.String)
+			if err != nil {
+				auditPartialDeletion("co-signer removal", err)
+				respondWithActivityError(ctx, err, "error while removing co-signer")
+				return
+			}
+			if removed {
+				completedSteps = append(completedSteps, "co-signer removal")
+			}
+		}
+
+		// Deleting the last passkey revokes the stamp of this request: we can't forward it again to poll for the result
+		_, err = turnkey.Client.SubmitSignedActivity(ctx.Request.Context(), signedRequest.Url, signedRequest.Body, signedRequest.Stamp)
+		if err != nil {
+			auditPartialDeletion("DELETE_AUTHENTICATORS", err)
+			respondWithActivityError(ctx, err, "error while forwarding signed DELETE_AUTHENTICATORS activity")
+			return
+		}
+		completedSteps = append(completedSteps, "DELETE_AUTHENTICATORS")
+
+		if err := models.DeleteAccount(user.ID, models.RETIREMENT_REASON_ACCOUNT_DELETED); err != nil {
+			auditPartialDeletion("account deletion", err)
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_ACCOUNT_DELETION, models.AUDIT_OUTCOME_SUCCEEDED, strings.Join(completedSteps, ", "))
+		webhooks.Emit(webhooks.EVENT_ACCOUNT_DELETED, map[string]interface{}{
+			"userId":            user.ID,
+			"subOrganizationId": user.SubOrganizationId.String,
+		})
+
+		// Other sessions stop resolving to a user now that it's deleted, and their rows are soft-deleted with the account
+		endUserSession(ctx)
+		response := map[string]interface{}{
+			"deleted": true,
+		}
+		if sweepHash != "" {
+			response["sweepHash"] = sweepHash
+		}
+		ctx.JSON(http.StatusOK, response)
+	})
+
+	// Everything we store about the current user, as a JSON download
+	router.GET("/api/account/export", func(ctx *gin.Context) {
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if !requireStepUp(ctx, user, userSession, models.AUDIT_ACTION_ACCOUNT_EXPORT) {
+			return
+		}
+
+		export, err := models.ExportAccount(user.ID)
+		if err != nil {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_ACCOUNT_EXPORT, models.AUDIT_OUTCOME_FAILED, err.Error())
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_ACCOUNT_EXPORT, models.AUDIT_OUTCOME_SUCCEEDED, "")
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"account-%d.json\"", user.ID))
+		ctx.JSON(http.StatusOK, export)
+	})
+
+	// Wallet exports reveal the seed phrase: they need a recent step-up, are rate limited per user, and are audited
+	router.POST("/api/wallet/export", func(ctx *gin.Context) {
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		var req types.ExportRequest
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateSignedRequest(req.SignedExportRequest, user, turnkeymodels.ActivityTypeExportWallet); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := validateFreshSignedRequest(req.SignedExportRequest, stepup.Settings.MaxAge); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if !requireStepUp(ctx, user, userSession, models.AUDIT_ACTION_WALLET_EXPORT) {
+			return
+		}
+
+		cooldown, err := stepup.ExportCooldownRemaining(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if cooldown > 0 {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_WALLET_EXPORT, models.AUDIT_OUTCOME_DENIED, "export cooldown")
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(cooldown.Seconds()))))
+			ctx.String(http.StatusTooManyRequests, fmt.Sprintf("the wallet was exported recently. Try again in %s", cooldown.Round(time.Second)))
+			return
+		}
+
+		bodyBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedExportRequest.Url, req.SignedExportRequest.Body, req.SignedExportRequest.Stamp)
+		if err != nil {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_WALLET_EXPORT, models.AUDIT_OUTCOME_FAILED, err.Error())
+			respondWithActivityError(ctx, err, "error while forwarding signed EXPORT_WALLET activity")
+			return
+		}
+		exportBundle := gjson.Get(string(bodyBytes), "activity.result.exportWalletResult.exportBundle").String()
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_WALLET_EXPORT, models.AUDIT_OUTCOME_SUCCEEDED, "wallet "+gjson.Get(req.SignedExportRequest.Body, "parameters.walletId").String())
+
+		ctx.JSON(http.StatusOK, exportBundle)
+	})
+
+	// Like discovery, recovery responds the same way for every email. The recovery email only goes out for verified accounts.
+	// Once the recovery credential is injected, clients find their sub-organization and user with a stamped whoami.
+	router.POST("/api/init-recovery", discoveryLimiter.Middleware, func(ctx *gin.Context) {
+		var params types.RecoveryParams
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		startEmailChallenge(params.Email, "recovery", func(ctx context.Context, user models.User) error {
+			_, err := turnkey.Client.InitRecovery(ctx, user.SubOrganizationId.String, user.Email, params.TargetPublicKey)
+			return err
+		})
+		respondWithEmailChallengeStarted(ctx)
+	})
+
+	router.POST("/api/recover", func(ctx *gin.Context) {
+		var req types.RecoverRequest
+		err := ctx.BindJSON(&req)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedRecoverRequest.Url, req.SignedRecoverRequest.Body, req.SignedRecoverRequest.Stamp)
+		if err != nil {
+			if strings.Contains(err.Error(), "no valid user found for authenticator") && strings.Contains(err.Error(), "Got status 401") {
+				// This is a tad weird, but while forwarding `RECOVER_USER`` activities, the "success" indicator isn't the usual "ACTIVITY_STATUS_COMPLETE",
+				// because the credential used to authenticate the request (in the stamp) is the _temporary_ recovery credential.
+				// The `RECOVER_USER` activity deletes this temporary credential and adds the new passkey instead, which means it loses any privileges on the org,
+				// which includes having read access to anything! Hence we expect this authentication error to happen when the activity completes.
+				// Anyone can get this error with a bogus stamp though: the completed activity is looked up with our backend API key before
+				// announcing anything.
+				organizationId := gjson.Get(req.SignedRecoverRequest.Body, "organizationId").String()
+				credentialId := gjson.Get(req.SignedRecoverRequest.Body, "parameters.authenticator.attestation.credentialId").String()
+				if organizationId != "" && credentialId != "" {
+					activity, err := turnkey.Client.FindCompletedRecovery(ctx.Request.Context(), organizationId, credentialId)
+					if err != nil {
+						log.Printf("unable to look up RECOVER_USER activity in organization %s: %s", organizationId, err.Error())
+					} else if activity != nil {
+						emitRecoveryCompleted(ctx, *activity.OrganizationID)
+					}
+				}
+				ctx.JSON(http.StatusOK, map[string]interface{}{})
+				return
+			}
+			err = errors.Wrap(err, "error while forwarding signed RECOVER_USER activity")
+			ctx.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		activityType := gjson.GetBytes(responseBytes, "activity.type").String()
+		if activityType == string(turnkeymodels.ActivityTypeRecoverUser) {
+			emitRecoveryCompleted(ctx, gjson.GetBytes(responseBytes, "activity.organizationId").String())
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{})
+	})
+
+	router.POST("/api/email-auth", discoveryLimiter.Middleware, func(ctx *gin.Context) {
+		var params types.EmailAuthParams
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		startEmailChallenge(params.Email, "email auth", func(ctx context.Context, user models.User) error {
+			_, _, err := turnkey.Client.EmailAuth(ctx, user.SubOrganizationId.String, user.Email, params.TargetPublicKey)
+			return err
+		})
+		respondWithEmailChallengeStarted(ctx)
+	})
+
+	// Sends a new one-time code to the current user's email. A first code is sent at registration.
+	router.POST("/api/email/verification", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		if err := verification.Send(user); err != nil {
+			if err == verification.ErrResendTooSoon {
+				ctx.String(http.StatusTooManyRequests, err.Error())
+			} else if verification.IsUserError(err) {
+				ctx.String(http.StatusBadRequest, err.Error())
+			} else {
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		ctx.String(http.StatusNoContent, "")
+	})
+
+	router.POST("/api/email/verify", func(ctx *gin.Context) {
+		var params types.VerifyEmailParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		if err := verification.Verify(user, strings.TrimSpace(params.Code)); err != nil {
+			if verification.IsUserError(err) {
+				ctx.String(http.StatusBadRequest, err.Error())
+			} else {
+				ctx.String(http.StatusInternalServerError, err.Error())
+			}
+			return
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"emailVerified": true,
+		})
+	})
+
+	// Lets clients follow up on activities which couldn't complete right away (see respondWithActivityError).
+	// Activities are looked up in the current user's sub-organization only.
+	router.GET("/api/activities/:id", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		activity, err := turnkey.Client.GetActivity(ctx.Request.Context(), user.SubOrganizationId.String, ctx.Param("id"))
+		if err != nil {
+			ctx.String(http.StatusNotFound, errors.Wrap(err, "unable to get activity").Error())
+			return
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"id":             activity.ID,
+			"organizationId": activity.OrganizationID,
+			"type":           activity.Type,
+			"status":         activity.Status,
+			"result":         activity.Result,
+		})
+	})
+
+	// Passkey management. Authenticators live in the user's sub-organization: adding or removing one requires
+	// a CREATE_AUTHENTICATORS or DELETE_AUTHENTICATORS activity stamped by an existing passkey, which we forward.
+	router.GET("/api/authenticators", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		subOrganizationAuthenticators, err := listSubOrganizationAuthenticators(ctx.Request.Context(), user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		localAuthenticators, err := models.ListAuthenticatorsForUser(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to list local authenticators").Error())
+			return
+		}
+		localByCredentialId := map[string]models.Authenticator{}
+		for _, a := range localAuthenticators {
+			localByCredentialId[a.CredentialId] = a
+		}
+
+		response := []map[string]interface{}{}
+		for _, a := range subOrganizationAuthenticators {
+			name := *a.authenticator.AuthenticatorName
+			aaguid := a.authenticator.Aaguid
+			if local, ok := localByCredentialId[*a.authenticator.CredentialID]; ok {
+				if local.Name != "" {
+					name = local.Name
+				}
+				if local.Aaguid != "" {
+					aaguid = &local.Aaguid
+				}
+			}
+			response = append(response, map[string]interface{}{
+				"authenticatorId": a.authenticator.AuthenticatorID,
+				"userId":          a.turnkeyUserId,
+				"credentialId":    a.authenticator.CredentialID,
+				"name":            name,
+				"aaguid":          aaguid,
+				"model":           a.authenticator.Model,
+				"transports":      a.authenticator.Transports,
+				"createdAt":       a.authenticator.CreatedAt,
+			})
+		}
+		ctx.JSON(http.StatusOK, response)
+	})
+
+	router.POST("/api/authenticators", func(ctx *gin.Context) {
+		var req types.CreateAuthenticatorsRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		signedRequest := req.SignedCreateAuthenticatorsRequest
+		if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeCreateAuthenticators); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		bodyBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), signedRequest.Url, signedRequest.Body, signedRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed CREATE_AUTHENTICATORS activity")
+			return
+		}
+
+		for _, a := range gjson.Get(signedRequest.Body, "parameters.authenticators").Array() {
+			var transports []turnkeymodels.AuthenticatorTransport
+			for _, t := range a.Get("attestation.transports").Array() {
+				transports = append(transports, turnkeymodels.AuthenticatorTransport(t.String()))
+			}
+			registration.SaveLocalAuthenticator(ctx.Request.Context(), user.ID, user.SubOrganizationId.String, a.Get("attestation.credentialId").String(), a.Get("authenticatorName").String(), transports)
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"authenticatorIds": gjson.GetBytes(bodyBytes, "activity.result.createAuthenticatorsResult.authenticatorIds").Value(),
+		})
+	})
+
+	router.POST("/api/authenticators/delete", func(ctx *gin.Context) {
+		var req types.DeleteAuthenticatorsRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		signedRequest := req.SignedDeleteAuthenticatorsRequest
+		if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeDeleteAuthenticators); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		turnkeyUserId := gjson.Get(signedRequest.Body, "parameters.userId").String()
+		toDelete := map[string]bool{}
+		for _, id := range gjson.Get(signedRequest.Body, "parameters.authenticatorIds").Array() {
+			toDelete[id.String()] = true
+		}
+
+		// Users locked out of their sub-organization can only get back in through email recovery. Don't let that happen by accident.
+		existing, err := turnkey.Client.GetAuthenticators(ctx.Request.Context(), user.SubOrganizationId.String, turnkeyUserId)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		var deletedCredentialIds []string
+		for _, a := range existing {
+			if toDelete[*a.AuthenticatorID] {
+				deletedCredentialIds = append(deletedCredentialIds, *a.CredentialID)
+			}
+		}
+		if len(deletedCredentialIds) >= len(existing) {
+			ctx.String(http.StatusConflict, "cannot delete the last remaining passkey")
+			return
+		}
+
+		_, err = turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), signedRequest.Url, signedRequest.Body, signedRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed DELETE_AUTHENTICATORS activity")
+			return
+		}
+
+		if err := models.DeleteAuthenticatorsForUser(user.ID, deletedCredentialIds); err != nil {
+			log.Printf("unable to delete local authenticators for user %d: %s", user.ID, err.Error())
+		}
+		ctx.String(http.StatusNoContent, "")
+	})
+
+	// Names are stored locally: they're only meant to help users tell their devices apart in this app.
+	router.POST("/api/authenticators/:id/rename", func(ctx *gin.Context) {
+		var params types.RenameAuthenticatorParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		subOrganizationAuthenticators, err := listSubOrganizationAuthenticators(ctx.Request.Context(), user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, a := range subOrganizationAuthenticators {
+			if *a.authenticator.AuthenticatorID != ctx.Param("id") {
+				continue
+			}
+			if err := models.RenameAuthenticator(user.ID, *a.authenticator.CredentialID, params.Name); err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			ctx.JSON(http.StatusOK, map[string]interface{}{
+				"authenticatorId": a.authenticator.AuthenticatorID,
+				"name":            params.Name,
+			})
+			return
+		}
+		ctx.String(http.StatusNotFound, "authenticator not found")
+	})
+
+	// Spending policy of the current user, along with the Turnkey policies mirroring it
+	router.GET("/api/spending-policy", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		policy, err := models.FindSpendingPolicy(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		rules, err := spending.ParseRules(policy)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		spent, err := models.SumTransactionValueSince(user.ID, time.Now().Add(-spending.DAILY_LIMIT_WINDOW))
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		mirrored, err := models.ListTurnkeyPolicies(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		turnkeyPolicies, notMirrored := rules.TurnkeyPolicies()
+		mirroredResponse := []map[string]interface{}{}
+		for _, m := range mirrored {
+			current := false
+			for _, p := range turnkeyPolicies {
+				current = current || (string(p.Effect) == m.Effect && p.Condition == m.Condition)
+			}
+			mirroredResponse = append(mirroredResponse, map[string]interface{}{
+				"policyId":  m.PolicyID,
+				"name":      m.Name,
+				"effect":    m.Effect,
+				"condition": m.Condition,
+				"createdAt": m.CreatedAt,
+				// Stale policies mirror rules which changed since: delete them in Turnkey
+				"current": current,
+			})
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"policy":           policy,
+			"spentInWindow":    spent.String(),
+			"turnkeyPolicies":  turnkeyPolicies,
+			"notMirrored":      notMirrored,
+			"mirroredPolicies": mirroredResponse,
+		})
+	})
+
+	// Replaces the current user's spending policy. Otherwise a stolen session could lift the limits: this takes a step-up.
+	router.PUT("/api/spending-policy", func(ctx *gin.Context) {
+		var params types.SpendingPolicyParams
+		if err := ctx.BindJSON(&params); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if !requireStepUp(ctx, user, userSession, models.AUDIT_ACTION_SPENDING_POLICY_UPDATE) {
+			return
+		}
+
+		policy, err := spendingPolicyFromParams(user, params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := models.SaveSpendingPolicy(policy); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_SPENDING_POLICY_UPDATE, models.AUDIT_OUTCOME_SUCCEEDED, "")
+
+		rules, err := spending.ParseRules(policy)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		turnkeyPolicies, notMirrored := rules.TurnkeyPolicies()
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"policy":          policy,
+			"turnkeyPolicies": turnkeyPolicies,
+			"notMirrored":     notMirrored,
+		})
+	})
+
+	// Creates one of the Turnkey policies mirroring the current user's spending policy (see GET /api/spending-policy),
+	// from a CREATE_POLICY activity stamped by the user. Mirrored policies keep holding if our backend is bypassed.
+	router.POST("/api/spending-policy/turnkey-policies", func(ctx *gin.Context) {
+		var req types.CreateTurnkeyPolicyRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if err := validateSignedRequest(req.SignedCreatePolicyRequest, user, turnkeymodels.ActivityTypeCreatePolicy); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		policy, err := models.FindSpendingPolicy(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		rules, err := spending.ParseRules(policy)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		turnkeyPolicies, _ := rules.TurnkeyPolicies()
+		effect := gjson.Get(req.SignedCreatePolicyRequest.Body, "parameters.effect").String()
+		condition := gjson.Get(req.SignedCreatePolicyRequest.Body, "parameters.condition").String()
+		var mirrored *spending.TurnkeyPolicy
+		for i, p := range turnkeyPolicies {
+			if string(p.Effect) == effect && p.Condition == condition {
+				mirrored = &turnkeyPolicies[i]
+			}
+		}
+		if mirrored == nil {
+			ctx.String(http.StatusBadRequest, "signed policy doesn't mirror the current spending policy")
+			return
+		}
+
+		responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedCreatePolicyRequest.Url, req.SignedCreatePolicyRequest.Body, req.SignedCreatePolicyRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed CREATE_POLICY activity")
+			return
+		}
+		turnkeyPolicy := models.TurnkeyPolicy{
+			UserID:    user.ID,
+			PolicyID:  gjson.GetBytes(responseBytes, "activity.result.createPolicyResult.policyId").String(),
+			Name:      mirrored.PolicyName,
+			Effect:    string(mirrored.Effect),
+			Condition: mirrored.Condition,
+		}
+		if err := models.RecordTurnkeyPolicy(&turnkeyPolicy); err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, turnkeyPolicy)
+	})
+
+	// Batch payments: a CSV file of (address, amount, optional token, memo) rows is validated as a whole, then turned
+	// into one transaction per row with sequential nonces. Users stamp each of them, and submit them together.
+	// The file is sent as the "file" field of a multipart form, or as the request body (text/csv).
+	router.POST("/api/batches", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		var file io.Reader = ctx.Request.Body
+		if strings.HasPrefix(ctx.ContentType(), "multipart/") {
+			fileHeader, err := ctx.FormFile("file")
+			if err != nil {
+				ctx.String(http.StatusBadRequest, errors.Wrap(err, "expected a CSV file in the \"file\" field").Error())
+				return
+			}
+			if fileHeader.Size > batches.MAX_FILE_SIZE {
+				ctx.String(http.StatusRequestEntityTooLarge, fmt.Sprintf("files are limited to %d bytes", batches.MAX_FILE_SIZE))
+				return
+			}
+			opened, err := fileHeader.Open()
+			if err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			defer opened.Close()
+			file = opened
+		}
+		rows, err := batches.ParseCSV(file)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		policy, err := models.FindSpendingPolicy(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		rules, err := spending.ParseRules(policy)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		spent, err := models.SumTransactionValueSince(user.ID, time.Now().Add(-spending.DAILY_LIMIT_WINDOW))
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		source := common.HexToAddress(wallet.EthereumAddress)
+		payments, err := batches.Validate(ctx.Request.Context(), source, rows, rules, spent)
+		if err == nil {
+			var batch *models.PaymentBatch
+			batch, err = batches.Construct(ctx.Request.Context(), user, wallet.EthereumAddress, payments)
+			if err == nil {
+				if err := models.CreatePaymentBatch(batch); err != nil {
+					ctx.String(http.StatusInternalServerError, err.Error())
+					return
+				}
+				ctx.JSON(http.StatusCreated, map[string]interface{}{
+					"batchId":        batch.ID,
+					"address":        wallet.EthereumAddress,
+					"organizationId": user.SubOrganizationId.String,
+					"payments":       batch.Payments,
+				})
+				return
+			}
+		}
+		var validationErr *batches.ValidationError
+		if errors.As(err, &validationErr) {
+			ctx.JSON(http.StatusBadRequest, map[string]interface{}{
+				"message": "the batch is invalid: nothing was constructed",
+				"errors":  validationErr.Rows,
+			})
+			return
+		}
+		ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to construct batch").Error())
+	})
+
+	router.GET("/api/batches", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		userBatches, err := models.ListPaymentBatches(user.ID, BATCHES_PAGE_SIZE)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		response := []*batches.Progress{}
+		for i := range userBatches {
+			progress, err := batches.GetProgress(&userBatches[i])
+			if err != nil {
+				ctx.String(http.StatusInternalServerError, err.Error())
+				return
+			}
+			response = append(response, progress)
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"batches": response,
+		})
+	})
+
+	// Broadcast and confirmation progress of a batch
+	router.GET("/api/batches/:id", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		batch, ok := findPaymentBatch(ctx, user)
+		if !ok {
+			return
+		}
+		progress, err := batches.GetProgress(batch)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, progress)
+	})
+
+	// Signs and broadcasts the payments of a batch, in nonce order. Payments submitted earlier are skipped, so a batch
+	// can be submitted in several goes. Processing stops at the first failure: later nonces couldn't be mined anyway.
+	router.POST("/api/batches/:id/submit", func(ctx *gin.Context) {
+		var req types.SubmitBatchRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+		batch, ok := findPaymentBatch(ctx, user)
+		if !ok {
+			return
+		}
+
+		signedByTransaction := map[string]types.SignedTurnkeyRequest{}
+		for _, signedRequest := range req.SignedTransactions {
+			if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeSignTransaction); err != nil {
+				ctx.String(http.StatusBadRequest, err.Error())
+				return
+			}
+			unsignedTransaction := strings.ToLower(strings.TrimPrefix(gjson.Get(signedRequest.Body, "parameters.unsignedTransaction").String(), "0x"))
+			signedByTransaction[unsignedTransaction] = signedRequest
+		}
+		matched := 0
+		for _, payment := range batch.Payments {
+			if _, ok := signedByTransaction[payment.UnsignedTransaction]; ok {
+				matched++
+			}
+		}
+		if matched != len(signedByTransaction) {
+			ctx.String(http.StatusBadRequest, "signed transactions don't match the batch's payments")
+			return
+		}
+
+		// Each payment is checked against the daily limit with the previous ones recorded
+		unlock, err := models.LockSpending(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer unlock()
+
+		for i := range batch.Payments {
+			payment := &batch.Payments[i]
+			if payment.Status == models.BATCH_PAYMENT_STATUS_BROADCAST {
+				continue
+			}
+			signedRequest, ok := signedByTransaction[payment.UnsignedTransaction]
+			if !ok {
+				break
+			}
+			hash, err := submitBatchPayment(ctx.Request.Context(), user, wallet, signedRequest)
+			if err != nil {
+				payment.Status = models.BATCH_PAYMENT_STATUS_FAILED
+				payment.Error = err.Error()
+			} else {
+				payment.Status = models.BATCH_PAYMENT_STATUS_BROADCAST
+				payment.Hash = hash
+				payment.Error = ""
+			}
+			if updateErr := models.UpdateBatchPayment(payment); updateErr != nil {
+				log.Printf("unable to record outcome of batch payment %d: %s", payment.ID, updateErr.Error())
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		progress, err := batches.GetProgress(batch)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, progress)
+	})
+
+	// Multi-approver ("quorum") mode. Sub-organizations start with a single root user and a threshold of 1.
+	// Users can add a second approver (another passkey owner, or our backend as co-signer) with a stamped CREATE_USERS
+	// activity, then require several approvals with a stamped UPDATE_ROOT_QUORUM activity.
+	router.GET("/api/quorum", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		subOrganizationUsers, err := turnkey.Client.GetUsers(ctx.Request.Context(), user.SubOrganizationId.String)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		coSignerPublicKey := turnkey.Client.CoSignerPublicKey()
+		approvers := []map[string]interface{}{}
+		for _, u := range subOrganizationUsers {
+			isCoSigner := false
+			for _, apiKey := range u.APIKeys {
+				if apiKey.Credential != nil && apiKey.Credential.PublicKey != nil && *apiKey.Credential.PublicKey == coSignerPublicKey {
+					isCoSigner = true
+				}
+			}
+			approvers = append(approvers, map[string]interface{}{
+				"userId":         u.UserID,
+				"userName":       u.UserName,
+				"authenticators": len(u.Authenticators),
+				"isCoSigner":     isCoSigner,
+			})
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"approvers":         approvers,
+			"coSignerPublicKey": coSignerPublicKey,
+		})
+	})
+
+	router.POST("/api/quorum/approvers", func(ctx *gin.Context) {
+		var req types.AddApproverRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if err := validateSignedRequest(req.SignedCreateUsersRequest, user, turnkeymodels.ActivityTypeCreateUsers); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		bodyBytes, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedCreateUsersRequest.Url, req.SignedCreateUsersRequest.Body, req.SignedCreateUsersRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed CREATE_USERS activity")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"userIds": gjson.GetBytes(bodyBytes, "activity.result.createUsersResult.userIds").Value(),
+		})
+	})
+
+	router.POST("/api/quorum/threshold", func(ctx *gin.Context) {
+		var req types.UpdateQuorumRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if err := validateSignedRequest(req.SignedUpdateRootQuorumRequest, user, turnkeymodels.ActivityTypeUpdateRootQuorum); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// Turnkey would reject these too, but a clear error message is nicer
+		threshold := gjson.Get(req.SignedUpdateRootQuorumRequest.Body, "parameters.threshold").Int()
+		quorumUsers := gjson.Get(req.SignedUpdateRootQuorumRequest.Body, "parameters.userIds").Array()
+		if threshold < 1 || threshold > int64(len(quorumUsers)) {
+			ctx.String(http.StatusBadRequest, fmt.Sprintf("threshold must be between 1 and the number of quorum users (%d). Got %d", len(quorumUsers), threshold))
+			return
+		}
+
+		_, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedUpdateRootQuorumRequest.Url, req.SignedUpdateRootQuorumRequest.Body, req.SignedUpdateRootQuorumRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed UPDATE_ROOT_QUORUM activity")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"threshold": threshold,
+			"userIds":   gjson.Get(req.SignedUpdateRootQuorumRequest.Body, "parameters.userIds").Value(),
+		})
+	})
+
+	// Lists activities in the current user's sub-organization which are waiting for approvals
+	router.GET("/api/activities", func(ctx *gin.Context) {
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+
+		pendingActivities, err := turnkey.Client.ListActivitiesAwaitingConsensus(ctx.Request.Context(), user.SubOrganizationId.String)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		activities := []map[string]interface{}{}
+		for _, activity := range pendingActivities {
+			activities = append(activities, map[string]interface{}{
+				"id":          activity.ID,
+				"type":        activity.Type,
+				"status":      activity.Status,
+				"fingerprint": activity.Fingerprint,
+				"intent":      activity.Intent,
+				"votes":       activity.Votes,
+				"createdAt":   activity.CreatedAt,
+			})
+		}
+		ctx.JSON(http.StatusOK, activities)
+	})
+
+	router.POST("/api/activities/:id/approve", func(ctx *gin.Context) {
+		var req types.ApproveActivityRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if err := validateVoteRequest(ctx.Request.Context(), req.SignedApproveRequest, user, ctx.Param("id"), turnkeymodels.ActivityTypeApproveActivity); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// The approval may complete a transaction: it's recorded before other spending checks can run
+		unlock, err := models.LockSpending(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer unlock()
+
+		_, err = turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedApproveRequest.Url, req.SignedApproveRequest.Body, req.SignedApproveRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed APPROVE_ACTIVITY activity")
+			return
+		}
+
+		respondWithApprovedActivity(ctx, user, ctx.Param("id"))
+	})
+
+	router.POST("/api/activities/:id/reject", func(ctx *gin.Context) {
+		var req types.RejectActivityRequest
+		if err := ctx.BindJSON(&req); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		user := getCurrentUser(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if err := validateVoteRequest(ctx.Request.Context(), req.SignedRejectRequest, user, ctx.Param("id"), turnkeymodels.ActivityTypeRejectActivity); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		_, err := turnkey.Client.ForwardSignedActivity(ctx.Request.Context(), req.SignedRejectRequest.Url, req.SignedRejectRequest.Body, req.SignedRejectRequest.Stamp)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while forwarding signed REJECT_ACTIVITY activity")
+			return
+		}
+
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"id":     ctx.Param("id"),
+			"status": turnkeymodels.ActivityStatusRejected,
+		})
+	})
+
+	// Approves an activity with our backend co-signer key, for sub-organizations which enrolled it as an approver.
+	// We only co-sign transactions passing the spending policy (see coSignableActivityTypes), after a recent step-up:
+	// a session cookie alone isn't enough to get our approval.
+	router.POST("/api/activities/:id/co-sign", func(ctx *gin.Context) {
+		user, userSession := getCurrentUserAndSession(ctx)
+		if user == nil {
+			ctx.String(http.StatusForbidden, "no current user")
+			return
+		}
+		if !requireStepUp(ctx, user, userSession, models.AUDIT_ACTION_CO_SIGN) {
+			return
+		}
+
+		activity, err := turnkey.Client.GetActivity(ctx.Request.Context(), user.SubOrganizationId.String, ctx.Param("id"))
+		if err != nil {
+			ctx.String(http.StatusNotFound, errors.Wrap(err, "unable to get activity").Error())
+			return
+		}
+		if *activity.Status != turnkeymodels.ActivityStatusConsensusNeeded {
+			ctx.String(http.StatusConflict, fmt.Sprintf("activity is not awaiting approvals (status: %s)", *activity.Status))
+			return
+		}
+		if !coSignableActivityTypes[*activity.Type] {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_CO_SIGN, models.AUDIT_OUTCOME_DENIED, string(*activity.Type))
+			ctx.String(http.StatusForbidden, fmt.Sprintf("the co-signer doesn't approve %s activities", *activity.Type))
+			return
+		}
+		// Held until respondWithApprovedActivity records the transaction
+		unlock, err := models.LockSpending(user.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		defer unlock()
+		// Our approval is what makes spending policies hold in quorum mode: never co-sign transactions breaking them
+		if err := checkUnsignedTransaction(user, activityUnsignedTransaction(activity)); err != nil {
+			recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_CO_SIGN, models.AUDIT_OUTCOME_DENIED, err.Error())
+			respondWithSpendingPolicyError(ctx, err)
+			return
+		}
+
+		err = turnkey.Client.ApproveActivity(ctx.Request.Context(), user.SubOrganizationId.String, *activity.Fingerprint)
+		if err != nil {
+			respondWithActivityError(ctx, err, "error while co-signing activity")
+			return
+		}
+		recordAuditEvent(ctx, user.ID, userSession, models.AUDIT_ACTION_CO_SIGN, models.AUDIT_OUTCOME_SUCCEEDED, *activity.ID)
+
+		respondWithApprovedActivity(ctx, user, ctx.Param("id"))
+	})
+
+	// Admin endpoints, authenticated with ADMIN_API_TOKEN. These are meant for our own team, not end-users.
+	admin := router.Group("/api/admin", adminAuthMiddleware)
+
+	// Runs registration reconciliation right away and returns its report. It also runs periodically in the background.
+	admin.POST("/registrations/reconcile", func(ctx *gin.Context) {
+		report, err := registration.Reconcile(ctx.Request.Context())
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, report)
+	})
+
+	// Accounts created before emails were normalized may collide (e.g. "Alice@x.com" and "alice@x.com").
+	// The case-insensitive unique index on emails is only created once this list is empty.
+	admin.GET("/users/email-collisions", func(ctx *gin.Context) {
+		collisions, err := models.FindEmailCollisions()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, collisions)
+	})
+
+	// Recent security-sensitive actions of a user (step-ups, exports, recoveries)
+	admin.GET("/users/:id/audit-events", func(ctx *gin.Context) {
+		userId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid user ID")
+			return
+		}
+		events, err := models.ListAuditEventsForUser(uint(userId), AUDIT_EVENTS_PAGE_SIZE)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, events)
+	})
+
+	admin.GET("/webhooks/subscriptions", func(ctx *gin.Context) {
+		subscriptions, err := models.ListWebhookSubscriptions()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, subscriptions)
+	})
+
+	admin.POST("/webhooks/subscriptions", func(ctx *gin.Context) {
+		var params types.CreateWebhookSubscriptionParams
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := webhooks.ValidateUrl(params.Url); err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		for _, eventType := range params.EventTypes {
+			if !webhooks.IsValidEventType(eventType) {
+				ctx.String(http.StatusBadRequest, fmt.Sprintf("unknown event type %q", eventType))
+				return
+			}
+		}
+
+		secret, err := webhooks.GenerateSecret()
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		subscription, err := models.CreateWebhookSubscription(params.Url, secret, params.EventTypes)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to create webhook subscription").Error())
+			return
+		}
+
+		// The secret is only ever returned here
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"subscription": subscription,
+			"secret":       secret,
+		})
+	})
+
+	admin.POST("/webhooks/subscriptions/:id/active", func(ctx *gin.Context) {
+		var params types.UpdateWebhookSubscriptionParams
+		err := ctx.BindJSON(&params)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		subscriptionId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid subscription ID")
+			return
+		}
+
+		err = models.SetWebhookSubscriptionActive(uint(subscriptionId), *params.Active)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "subscription not found")
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.String(http.StatusNoContent, "")
+	})
+
+	admin.GET("/webhooks/deliveries", func(ctx *gin.Context) {
+		limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 || limit > 500 {
+			ctx.String(http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+
+		deliveries, err := models.ListWebhookDeliveries(ctx.Query("status"), limit)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, deliveries)
+	})
+
+	admin.GET("/webhooks/deliveries/:id", func(ctx *gin.Context) {
+		deliveryId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+		if err != nil {
+			ctx.String(http.StatusBadRequest, "invalid delivery ID")
+			return
+		}
+
+		delivery, err := models.FindWebhookDeliveryById(uint(deliveryId))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "delivery not found")
+			return
+		}
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		attempts, err := models.ListWebhookDeliveryAttempts(delivery.ID)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, map[string]interface{}{
+			"delivery": delivery,
+			"attempts": attempts,
+		})
 	})
 
-	router.POST("/api/email-auth", func(ctx *gin.Context) {
-		var params types.EmailAuthParams
-		err := ctx.BindJSON(&params)
+	admin.GET("/webhooks/dead-letters", func(ctx *gin.Context) {
+		deadLetters, err := models.ListWebhookDeadLetters(ctx.Query("includeReplayed") == "true")
 		if err != nil {
-			ctx.String(http.StatusBadRequest, err.Error())
+			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		ctx.JSON(http.StatusOK, deadLetters)
+	})
 
-		user, err := models.FindUserByEmail(params.Email)
+	admin.POST("/webhooks/deliveries/:id/replay", func(ctx *gin.Context) {
+		deliveryId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 		if err != nil {
-			ctx.String(http.StatusForbidden, "no user found")
+			ctx.String(http.StatusBadRequest, "invalid delivery ID")
 			return
 		}
-		subOrganizationId := user.SubOrganizationId.String
 
-		turnkeyUserUuid, turnkeyUserApiKeyId, err := turnkey.Client.EmailAuth(subOrganizationId, user.Email, params.TargetPublicKey)
+		replay, err := models.ReplayWebhookDelivery(uint(deliveryId))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.String(http.StatusNotFound, "delivery not found")
+			return
+		}
 		if err != nil {
-			ctx.String(http.StatusInternalServerError, fmt.Sprintf("error while performing email auth: %s", err.Error()))
-
+			ctx.String(http.StatusInternalServerError, err.Error())
 			return
 		}
+		webhooks.Wake()
 
-		ctx.JSON(http.StatusOK, map[string]interface{}{
-			"userId":         turnkeyUserUuid,
-			"organizationId": subOrganizationId,
-			"apiKeyId":       turnkeyUserApiKeyId,
-		})
+		ctx.JSON(http.StatusOK, replay)
 	})
 
 	router.Run(":" + port)
 }
 
-// Transform a bigint (representing a wei amount) into a readable
-// string ("1.23") representing an amount in ETH.
-func formatBalance(balance *big.Int) string {
-	b := big.NewRat(balance.Int64(), ONE_ETH_IN_WEI)
-	return b.FloatString(2)
+// Sends a Turnkey email challenge (recovery or email auth) to the user with this email, if they have a verified account.
+// The Turnkey activity runs in the background so that response times don't reveal whether the account exists.
+func startEmailChallenge(email string, description string, start func(ctx context.Context, user models.User) error) {
+	user, err := models.FindUserByEmail(email)
+	// Otherwise anyone registering someone else's email could receive their emails
+	if err != nil || !user.SubOrganizationId.Valid || !user.IsVerified() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), EMAIL_CHALLENGE_TIMEOUT)
+		defer cancel()
+		if err := start(ctx, user); err != nil {
+			log.Printf("unable to start %s for user %d: %s", description, user.ID, err.Error())
+		}
+	}()
+}
+
+func respondWithEmailChallengeStarted(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, map[string]interface{}{
+		"message":        "If this email belongs to a verified account, an email is on its way.",
+		"organizationId": turnkey.Client.OrganizationID,
+	})
+}
+
+// Responds to a request whose Turnkey activity didn't complete.
+// Activities waiting for consensus aren't errors: we respond with 202 and the activity ID,
+// and clients can poll GET /api/activities/:id until the activity is completed.
+func respondWithActivityError(ctx *gin.Context, err error, message string) {
+	var consensusNeeded *turnkey.ActivityConsensusNeededError
+	if errors.As(err, &consensusNeeded) {
+		ctx.JSON(http.StatusAccepted, map[string]interface{}{
+			"activityId":     consensusNeeded.ActivityId,
+			"organizationId": consensusNeeded.OrganizationId,
+			"status":         turnkeymodels.ActivityStatusConsensusNeeded,
+		})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, errors.Wrap(err, message).Error())
+}
+
+type subOrganizationAuthenticator struct {
+	turnkeyUserId string
+	authenticator *turnkeymodels.Authenticator
+}
+
+// Lists passkeys across all users of the current user's sub-organization
+func listSubOrganizationAuthenticators(ctx context.Context, user *models.User) ([]subOrganizationAuthenticator, error) {
+	subOrganizationUsers, err := turnkey.Client.GetUsers(ctx, user.SubOrganizationId.String)
+	if err != nil {
+		return nil, err
+	}
+	var authenticators []subOrganizationAuthenticator
+	for _, u := range subOrganizationUsers {
+		for _, a := range u.Authenticators {
+			authenticators = append(authenticators, subOrganizationAuthenticator{turnkeyUserId: *u.UserID, authenticator: a})
+		}
+	}
+	return authenticators, nil
+}
+
+// Checks that a signed Turnkey request targets the current user's sub-organization with the expected activity type.
+// Versioned activity types (e.g. ACTIVITY_TYPE_CREATE_USERS_V2) are accepted as well.
+func validateSignedRequest(signedRequest types.SignedTurnkeyRequest, user *models.User, activityType turnkeymodels.ActivityType) error {
+	organizationId := gjson.Get(signedRequest.Body, "organizationId").String()
+	if !user.SubOrganizationId.Valid || organizationId != user.SubOrganizationId.String {
+		return fmt.Errorf("signed request targets organization %q instead of the current user's sub-organization", organizationId)
+	}
+
+	requestType := gjson.Get(signedRequest.Body, "type").String()
+	if requestType != string(activityType) && !strings.HasPrefix(requestType, string(activityType)+"_V") {
+		return fmt.Errorf("expected a signed %s request. Got %q", activityType, requestType)
+	}
+	return nil
+}
+
+// Resolves a destination given either as an address or as an ENS name.
+// Returns the address, and the normalized name when one was given.
+func resolveDestination(ctx context.Context, destination string) (common.Address, string, error) {
+	if !ens.IsName(destination) {
+		address, err := ethereum.ParseAddress(destination)
+		if err != nil {
+			return common.Address{}, "", errors.Wrapf(err, "invalid destination %q", destination)
+		}
+		return address, "", nil
+	}
+	name, err := ens.Normalize(destination)
+	if err != nil {
+		return common.Address{}, "", errors.Wrapf(err, "invalid destination %q", destination)
+	}
+	address, err := ens.Client.Resolve(ctx, name)
+	if err != nil {
+		return common.Address{}, "", errors.Wrapf(err, "unable to resolve %s", name)
+	}
+	return address, name, nil
+}
+
+// Bad addresses and names which don't resolve are the user's doing, RPC failures aren't
+func destinationErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case ethereum.ErrInvalidAddress, ethereum.ErrInvalidAddressChecksum, ens.ErrInvalidName, ens.ErrNameNotFound, errInvalidContact:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+var errInvalidContact = errors.New("invalid contact")
+
+// Sets a contact's fields from create/update parameters. The address is resolved once: contacts saved from an ENS name
+// keep pointing to the same address if the name changes hands.
+func applyContactParams(ctx context.Context, wallet *models.Wallet, params types.ContactParams, contact *models.Contact) error {
+	chainId := params.ChainID
+	if chainId == 0 {
+		chainId = ethereum.ChainID.Int64()
+	}
+	if chainId < 0 {
+		return errors.Wrap(errInvalidContact, "chainId must be positive")
+	}
+
+	var address common.Address
+	switch {
+	case params.Address != "" && params.TransferHash != "":
+		return errors.Wrap(errInvalidContact, "pass either address or transferHash, not both")
+	case params.Address != "":
+		var err error
+		address, _, err = resolveDestination(ctx, params.Address)
+		if err != nil {
+			return err
+		}
+	case params.TransferHash != "":
+		// History only covers the wallet's own chain
+		if chainId != ethereum.ChainID.Int64() {
+			return errors.Wrapf(errInvalidContact, "history entries are on chain %d", ethereum.ChainID.Int64())
+		}
+		history, err := alchemy.TransactionHistory(ctx, wallet.EthereumAddress)
+		if err != nil {
+			return errors.Wrap(err, "unable to get transaction history")
+		}
+		var counterparty string
+		for _, transfer := range history {
+			if strings.EqualFold(transfer.Hash, params.TransferHash) {
+				counterparty = transfer.Source
+				if transfer.Type == "withdrawal" {
+					counterparty = transfer.Destination
+				}
+				break
+			}
+		}
+		if counterparty == "" {
+			return errors.Wrapf(errInvalidContact, "transfer %s isn't in the wallet's history", params.TransferHash)
+		}
+		address = common.HexToAddress(counterparty)
+		contact.Source = models.CONTACT_SOURCE_HISTORY
+		contact.TransferHash = params.TransferHash
+	case contact.Address != "":
+		// Updates may leave the address as it is
+		address = common.HexToAddress(contact.Address)
+	default:
+		return errors.Wrap(errInvalidContact, "address or transferHash is required")
+	}
+
+	contact.Label = strings.TrimSpace(params.Label)
+	if contact.Label == "" {
+		return errors.Wrap(errInvalidContact, "label is required")
+	}
+	contact.Address = address.Hex()
+	contact.ChainID = chainId
+	contact.Notes = params.Notes
+	return nil
+}
+
+// Returns true if the wallet never sent anything to destination. Lookup failures count as new recipients:
+// a needless warning beats a missing one.
+func isNewRecipient(ctx context.Context, user *models.User, wallet *models.Wallet, destination common.Address) bool {
+	sent, err := models.HasSentTransactionTo(user.ID, destination.Hex())
+	if err != nil {
+		log.Printf("unable to look up past transactions to %s: %s", destination.Hex(), err.Error())
+		return true
+	}
+	if sent {
+		return false
+	}
+	// Transactions sent before we started recording them, or from elsewhere
+	sent, err = alchemy.HasSentTo(ctx, wallet.EthereumAddress, destination.Hex())
+	if err != nil {
+		log.Printf("unable to look up past transfers to %s: %s", destination.Hex(), err.Error())
+		return true
+	}
+	return !sent
+}
+
+// Fills in the primary ENS names of history counterparties. Names are best-effort: failed lookups are logged and skipped.
+func addHistoryNames(ctx context.Context, history []*alchemy.Transfer) {
+	names := map[common.Address]string{}
+	lookup := func(s string) string {
+		address, err := ethereum.ParseAddress(s)
+		if err != nil {
+			return ""
+		}
+		if name, ok := names[address]; ok {
+			return name
+		}
+		name, err := ens.Client.LookupAddress(ctx, address)
+		if err != nil {
+			log.Printf("unable to look up ENS name of %s: %s", address.Hex(), err.Error())
+		}
+		names[address] = name
+		return name
+	}
+	for _, transfer := range history {
+		transfer.SourceName = lookup(transfer.Source)
+		transfer.DestinationName = lookup(transfer.Destination)
+	}
+}
+
+// Checks a transaction against the user's spending policy. Returns a *spending.Violation when it breaks a rule.
+// Before forwarding the transaction, callers hold models.LockSpending until it's recorded (see recordBroadcastTransaction).
+func checkSpendingPolicy(user *models.User, tx spending.Transaction) error {
+	policy, err := models.FindSpendingPolicy(user.ID)
+	if err != nil {
+		return err
+	}
+	rules, err := spending.ParseRules(policy)
+	if err != nil {
+		return err
+	}
+	if rules.IsEmpty() {
+		return nil
+	}
+	spent, err := models.SumTransactionValueSince(user.ID, time.Now().Add(-spending.DAILY_LIMIT_WINDOW))
+	if err != nil {
+		return err
+	}
+	return rules.Evaluate(tx, spent)
+}
+
+// Activities the backend co-signer approves. Anything else (raw payload signatures, which could be the hash of any
+// transaction, quorum and user changes which could remove the co-signer, exports) is left to the user's own approvers.
+var coSignableActivityTypes = map[turnkeymodels.ActivityType]bool{
+	turnkeymodels.ActivityTypeSignTransactionV2: true,
+}
+
+var errUncheckableTransaction = errors.New("unable to check the transaction against the spending policy")
+
+// Checks the transaction of a signed SIGN_TRANSACTION request against the user's spending policy
+func checkSignTransactionRequest(user *models.User, signedRequest types.SignedTurnkeyRequest) error {
+	if err := validateSignedRequest(signedRequest, user, turnkeymodels.ActivityTypeSignTransaction); err != nil {
+		return errors.Wrap(errUncheckableTransaction, err.Error())
+	}
+	return checkUnsignedTransaction(user, gjson.Get(signedRequest.Body, "parameters.unsignedTransaction").String())
+}
+
+// Transactions which can't be decoded can't be checked: they're refused
+func checkUnsignedTransaction(user *models.User, unsignedTransaction string) error {
+	tx, err := ethereum.DecodeUnsignedTransaction(unsignedTransaction)
+	if err != nil {
+		return errors.Wrap(errUncheckableTransaction, err.Error())
+	}
+	if tx.To() == nil {
+		return errors.Wrap(errUncheckableTransaction, "contract deployments aren't supported")
+	}
+	return checkSpendingPolicy(user, spending.Transaction{To: *tx.To(), Value: tx.Value(), Data: tx.Data()})
+}
+
+// Returns the unsigned transaction of a SIGN_TRANSACTION activity, or "" for other activities
+func activityUnsignedTransaction(activity *turnkeymodels.Activity) string {
+	if activity.Intent == nil {
+		return ""
+	}
+	if intent := activity.Intent.SignTransactionIntentV2; intent != nil && intent.UnsignedTransaction != nil {
+		return *intent.UnsignedTransaction
+	}
+	if intent := activity.Intent.SignTransactionIntent; intent != nil && intent.UnsignedTransaction != nil {
+		return *intent.UnsignedTransaction
+	}
+	return ""
+}
+
+func respondWithSpendingPolicyError(ctx *gin.Context, err error) {
+	if errors.Cause(err) == errUncheckableTransaction {
+		ctx.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	var violation *spending.Violation
+	if errors.As(err, &violation) {
+		ctx.JSON(http.StatusForbidden, map[string]interface{}{
+			"message": violation.Message,
+			"rule":    violation.Rule,
+		})
+		return
+	}
+	ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to check spending policy").Error())
+}
+
+// Validates spending policy parameters, converting limits to wei and checksumming addresses
+func spendingPolicyFromParams(user *models.User, params types.SpendingPolicyParams) (*models.SpendingPolicy, error) {
+	policy := models.SpendingPolicy{UserID: user.ID}
+	for _, limit := range []struct {
+		name   string
+		amount string
+		wei    *string
+	}{
+		{"maxTransaction", params.MaxTransaction, &policy.MaxTransaction},
+		{"dailyLimit", params.DailyLimit, &policy.DailyLimit},
+	} {
+		if limit.amount == "" {
+			continue
+		}
+		wei, err := ethereum.ParseEth(limit.amount)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s", limit.name)
+		}
+		*limit.wei = wei.String()
+	}
+	for _, list := range []struct {
+		name      string
+		addresses []string
+		stored    *string
+	}{
+		{"allowlist", params.Allowlist, &policy.Allowlist},
+		{"denylist", params.Denylist, &policy.Denylist},
+		{"allowedTokens", params.AllowedTokens, &policy.AllowedTokens},
+	} {
+		var addresses []common.Address
+		for _, a := range list.addresses {
+			address, err := ethereum.ParseAddress(strings.TrimSpace(a))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s address %q", list.name, a)
+			}
+			addresses = append(addresses, address)
+		}
+		*list.stored = spending.FormatAddressList(addresses)
+	}
+	return &policy, nil
+}
+
+// Looks up the batch named by the :id parameter. Responds with an error and returns false if the user has no such batch.
+func findPaymentBatch(ctx *gin.Context, user *models.User) (*models.PaymentBatch, bool) {
+	batchId, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "invalid batch ID")
+		return nil, false
+	}
+	batch, err := models.FindPaymentBatchForUser(user.ID, uint(batchId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ctx.String(http.StatusNotFound, "batch not found")
+		return nil, false
+	}
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+	return batch, true
+}
+
+// Checks a batch payment against the spending policy again (earlier payments of the batch now count towards the
+// daily limit), then has Turnkey sign it and broadcasts it. Returns the transaction hash.
+// The caller holds models.LockSpending.
+func submitBatchPayment(ctx context.Context, user *models.User, wallet *models.Wallet, signedRequest types.SignedTurnkeyRequest) (string, error) {
+	if err := checkUnsignedTransaction(user, gjson.Get(signedRequest.Body, "parameters.unsignedTransaction").String()); err != nil {
+		return "", err
+	}
+	responseBytes, err := turnkey.Client.ForwardSignedActivity(ctx, signedRequest.Url, signedRequest.Body, signedRequest.Stamp)
+	if err != nil {
+		return "", errors.Wrap(err, "error while forwarding signed transaction request")
+	}
+	signedTransaction := gjson.GetBytes(responseBytes, "activity.result.signTransactionResult.signedTransaction").String()
+	hash, err := ethereum.BroadcastTransaction(ctx, signedTransaction)
+	if err != nil {
+		return "", err
+	}
+	// The transaction is out: failing to track it shouldn't fail the payment
+	if err := recordBroadcastTransaction(user, wallet, signedTransaction, hash); err != nil {
+		log.Printf("unable to record broadcast transaction %s: %s", hash, err.Error())
+	}
+	return hash, nil
+}
+
+// Returns the calldata of a construct-tx request, along with its decoded summary. Both are nil for plain transfers.
+func constructTxCalldata(params types.ConstructTxParams) ([]byte, *ethereum.CallSummary, error) {
+	var contractAbi *abi.ABI
+	if len(params.Abi) > 0 {
+		parsed, err := ethereum.ParseAbiFragment(params.Abi)
+		if err != nil {
+			return nil, nil, err
+		}
+		contractAbi = parsed
+	}
+
+	var calldata []byte
+	switch {
+	case params.Data != "" && len(params.Args) > 0:
+		return nil, nil, fmt.Errorf("pass either data or args, not both")
+	case params.Data != "":
+		decoded, err := hex.DecodeString(strings.TrimPrefix(params.Data, "0x"))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid hex-encoded data")
+		}
+		if len(decoded) == 0 {
+			return nil, nil, nil
+		}
+		calldata = decoded
+	case contractAbi != nil:
+		method, err := ethereum.FindAbiMethod(contractAbi, params.FunctionName)
+		if err != nil {
+			return nil, nil, err
+		}
+		calldata, err = ethereum.EncodeCall(method, params.Args)
+		if err != nil {
+			return nil, nil, err
+		}
+	case len(params.Args) > 0 || params.FunctionName != "":
+		return nil, nil, fmt.Errorf("an ABI is required to encode a function call")
+	default:
+		return nil, nil, nil
+	}
+
+	summary, err := ethereum.DecodeCall(contractAbi, calldata)
+	if err != nil {
+		return nil, nil, err
+	}
+	return calldata, summary, nil
+}
+
+// Computes the digest to sign for a personal_sign or eth_signTypedData_v4 payload
+func signaturePayloadDigest(params types.SignPayloadParams) ([]byte, error) {
+	switch params.Kind {
+	case ethereum.SIGNATURE_KIND_PERSONAL_SIGN:
+		message := []byte(params.Message)
+		if strings.HasPrefix(params.Message, "0x") {
+			decoded, err := hex.DecodeString(params.Message[2:])
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid hex-encoded message")
+			}
+			message = decoded
+		}
+		return ethereum.PersonalMessageDigest(message), nil
+	case ethereum.SIGNATURE_KIND_TYPED_DATA:
+		var typedData apitypes.TypedData
+		if err := json.Unmarshal(params.TypedData, &typedData); err != nil {
+			return nil, errors.Wrap(err, "invalid typed data")
+		}
+		return ethereum.TypedDataDigest(typedData)
+	}
+	return nil, fmt.Errorf("unsupported signature kind %q", params.Kind)
+}
+
+// Checks that a signed SIGN_RAW_PAYLOAD activity signs the expected digest, as is, with the given wallet address
+func validateSignRawPayloadParameters(signedRequest types.SignedTurnkeyRequest, address string, digest []byte) error {
+	parameters := gjson.Get(signedRequest.Body, "parameters")
+	if !strings.EqualFold(parameters.Get("signWith").String(), address) {
+		return fmt.Errorf("signed request must sign with the wallet address %s", address)
+	}
+	if parameters.Get("encoding").String() != string(turnkeymodels.PayloadEncodingHexadecimal) {
+		return fmt.Errorf("signed request must use %s", turnkeymodels.PayloadEncodingHexadecimal)
+	}
+	if parameters.Get("hashFunction").String() != string(turnkeymodels.HashFunctionNoOp) {
+		return fmt.Errorf("signed request must use %s: the payload is already a digest", turnkeymodels.HashFunctionNoOp)
+	}
+	if !strings.EqualFold(strings.TrimPrefix(parameters.Get("payload").String(), "0x"), hex.EncodeToString(digest)) {
+		return fmt.Errorf("signed request doesn't sign the expected digest")
+	}
+	return nil
+}
+
+// Checks that a signed activity was created recently (Turnkey activities carry a timestampMs), so that it can't be a replay
+// of an old signature for sensitive operations
+func validateFreshSignedRequest(signedRequest types.SignedTurnkeyRequest, maxAge time.Duration) error {
+	timestampMs := gjson.Get(signedRequest.Body, "timestampMs").Int()
+	age := time.Since(time.UnixMilli(timestampMs))
+	if timestampMs == 0 || age > maxAge || age < -maxAge {
+		return fmt.Errorf("signed request is too old: sign a new one")
+	}
+	return nil
+}
+
+// Checks that a signed APPROVE_ACTIVITY or REJECT_ACTIVITY request votes on the activity we expect
+func validateVoteRequest(ctx context.Context, signedRequest types.SignedTurnkeyRequest, user *models.User, activityId string, voteType turnkeymodels.ActivityType) error {
+	if err := validateSignedRequest(signedRequest, user, voteType); err != nil {
+		return err
+	}
+
+	activity, err := turnkey.Client.GetActivity(ctx, user.SubOrganizationId.String, activityId)
+	if err != nil {
+		return errors.Wrap(err, "unable to get activity")
+	}
+	if gjson.Get(signedRequest.Body, "parameters.fingerprint").String() != *activity.Fingerprint {
+		return fmt.Errorf("signed request does not vote on activity %s", activityId)
+	}
+	return nil
+}
+
+// Responds with the status of an activity after an approval. Signed transactions are broadcast
+// once their activity completes, since send-tx couldn't do it while approvals were pending.
+func respondWithApprovedActivity(ctx *gin.Context, user *models.User, activityId string) {
+	activity, err := turnkey.Client.GetActivity(ctx.Request.Context(), user.SubOrganizationId.String, activityId)
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to get activity").Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":     activity.ID,
+		"status": activity.Status,
+	}
+
+	isSignTransaction := *activity.Type == turnkeymodels.ActivityTypeSignTransaction || *activity.Type == turnkeymodels.ActivityTypeSignTransactionV2
+	if *activity.Status == turnkeymodels.ActivityStatusCompleted && isSignTransaction && activity.Result.SignTransactionResult != nil {
+		wallet, err := models.GetWalletForUser(*user)
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, errors.Wrap(err, "unable to retrieve wallet for current user").Error())
+			return
+		}
+
+		signedTransaction := *activity.Result.SignTransactionResult.SignedTransaction
+		hash, err := ethereum.BroadcastTransaction(ctx.Request.Context(), signedTransaction)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, fmt.Sprintf("error while broadcasting signed transaction %q", signedTransaction))
+			return
+		}
+		if err := recordBroadcastTransaction(user, wallet, signedTransaction, hash); err != nil {
+			log.Printf("unable to record broadcast transaction %s: %s", hash, err.Error())
+		}
+		response["hash"] = hash
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Emits a recovery.completed webhook for a sub-organization where Turnkey completed a RECOVER_USER activity
+// Recovery can't require a step-up (users recover precisely because they lost their passkeys), but it's audited
+func emitRecoveryCompleted(ctx *gin.Context, subOrganizationId string) {
+	data := map[string]interface{}{
+		"subOrganizationId": subOrganizationId,
+	}
+	if user, err := models.FindUserBySubOrganizationId(subOrganizationId); err == nil && user.ID != 0 {
+		data["userId"] = user.ID
+		recordAuditEvent(ctx, user.ID, nil, models.AUDIT_ACTION_RECOVERY, models.AUDIT_OUTCOME_SUCCEEDED, "")
+	}
+	webhooks.Emit(webhooks.EVENT_RECOVERY_COMPLETED, data)
+}
+
+// Guards admin endpoints. Requests must carry "Authorization: Bearer $ADMIN_API_TOKEN".
+// Admin endpoints are disabled entirely when ADMIN_API_TOKEN isn't set.
+func adminAuthMiddleware(ctx *gin.Context) {
+	adminApiToken := os.Getenv("ADMIN_API_TOKEN")
+	if adminApiToken == "" {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, "admin endpoints are disabled")
+		return
+	}
+
+	providedToken := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(providedToken), []byte(adminApiToken)) != 1 {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, "invalid admin token")
+		return
+	}
+	ctx.Next()
+}
+
+func notificationPreferenceResponse(preference *models.NotificationPreference, includeSecret bool) map[string]interface{} {
+	response := map[string]interface{}{
+		"emailDeposits": preference.EmailDeposits,
+		"webhookUrl":    nil,
+	}
+	if preference.WebhookUrl.Valid {
+		response["webhookUrl"] = preference.WebhookUrl.String
+	}
+	if includeSecret {
+		response["webhookSecret"] = preference.WebhookSecret
+	}
+	return response
+}
+
+// Keeps track of a transaction sent on behalf of a user so we can report its status later
+func recordBroadcastTransaction(user *models.User, wallet *models.Wallet, signedTransaction, hash string) error {
+	tx, err := ethereum.DecodeSignedTransaction(signedTransaction)
+	if err != nil {
+		return err
+	}
+	var destination string
+	if tx.To() != nil {
+		destination = tx.To().Hex()
+	}
+	return models.RecordBroadcastTransaction(&models.Transaction{
+		UserID:      user.ID,
+		Hash:        hash,
+		Source:      wallet.EthereumAddress,
+		Destination: destination,
+		Amount:      tx.Value().String(),
+		Nonce:       tx.Nonce(),
+	})
 }
 
 func getCurrentUser(ctx *gin.Context) *models.User {
+	user, _ := getCurrentUserAndSession(ctx)
+	return user
+}
+
+// Like getCurrentUser, also returning the session. Both are nil when there is no current user.
+func getCurrentUserAndSession(ctx *gin.Context) (*models.User, *models.UserSession) {
+	userSession := getCurrentUserSession(ctx)
+	if userSession == nil {
+		return nil, nil
+	}
+
+	userId := userSession.UserID
+	user, err := models.FindUserById(userId)
+	// Deleted users aren't found anymore, which ends all their sessions
+	if err != nil {
+		log.Print(fmt.Errorf("error while getting current user \"%d\": %w", userId, err))
+		return nil, nil
+	}
+	return &user, userSession
+}
+
+// Responds with a 403 (flagged with stepUpRequired, so clients know to call /api/step-up) unless the session stepped up
+// recently. Denials are audited under the given action. Returns true if the request can proceed.
+func requireStepUp(ctx *gin.Context, user *models.User, userSession *models.UserSession, action string) bool {
+	if stepup.IsRecent(userSession) {
+		return true
+	}
+	recordAuditEvent(ctx, user.ID, userSession, action, models.AUDIT_OUTCOME_DENIED, "step-up required")
+	ctx.JSON(http.StatusForbidden, map[string]interface{}{
+		"message":        "confirm with your passkey to continue",
+		"stepUpRequired": true,
+	})
+	return false
+}
+
+// Failures are logged: a missing audit event shouldn't fail the request it describes
+func recordAuditEvent(ctx *gin.Context, userId uint, userSession *models.UserSession, action, outcome, details string) {
+	event := models.AuditEvent{
+		UserID:    userId,
+		Action:    action,
+		Outcome:   outcome,
+		IpAddress: ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		Details:   details,
+	}
+	if userSession != nil {
+		event.UserSessionID.Int64, event.UserSessionID.Valid = int64(userSession.ID), true
+	}
+	if err := models.RecordAuditEvent(&event); err != nil {
+		log.Printf("error while recording audit event: %+v", err)
+	}
+}
+
+// Returns the session behind the current request's cookie, if it's still valid (not expired, idle or revoked)
+func getCurrentUserSession(ctx *gin.Context) *models.UserSession {
 	session := sessions.Default(ctx)
 
 	// Session.Get returns nil if the session doesn't have a given key
 	userIdOrNil := session.Get(SESSION_USER_ID_KEY)
-	if userIdOrNil == nil {
+	tokenOrNil := session.Get(SESSION_TOKEN_KEY)
+	if userIdOrNil == nil || tokenOrNil == nil {
 		log.Println("session.Get returned nil; no session provided?")
 		return nil
 	}
 
-	userId := userIdOrNil.(uint)
-	user, err := models.FindUserById(userId)
+	userSession, err := usersessions.Validate(tokenOrNil.(string), ctx.ClientIP())
 	if err != nil {
-		log.Print(fmt.Errorf("error while getting current user \"%d\": %w", userId, err))
+		log.Printf("error while validating session of user %d: %s", userIdOrNil.(uint), err.Error())
 		return nil
 	}
-	return &user
+	if userSession.UserID != userIdOrNil.(uint) {
+		log.Printf("error: session token belongs to user %d, not %d", userSession.UserID, userIdOrNil.(uint))
+		return nil
+	}
+	return userSession
 }
 
 func startUserLoginSession(ctx *gin.Context, userId uint) {
 	log.Println("Starting user session...")
 
+	userSession, err := usersessions.Create(userId, ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		log.Printf("error while creating session for user %d: %+v", userId, err)
+		return
+	}
+
 	session := sessions.Default(ctx)
 
 	session.Set(SESSION_USER_ID_KEY, userId)
+	session.Set(SESSION_TOKEN_KEY, userSession.Token)
 
 	log.Printf("SESSION_USER_ID_KEY: %v\n", SESSION_USER_ID_KEY)
 	log.Printf("User ID: %v\n", userId)
 
-	err := session.Save()
+	err = session.Save()
 	if err != nil {
 		log.Printf("error while saving session for user %d: %+v", userId, err)
 	}
@@ -602,10 +3383,17 @@ func endUserSession(ctx *gin.Context) {
 		log.Printf("error: trying to end session but no user ID data")
 		return
 	}
-	session.Options(sessions.Options{
-		MaxAge: -1,
-		Path:   "/",
-	})
+	if tokenOrNil := session.Get(SESSION_TOKEN_KEY); tokenOrNil != nil {
+		if userSession, err := models.FindUserSessionByToken(tokenOrNil.(string)); err == nil {
+			if err := models.RevokeUserSession(userSession.UserID, userSession.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("error while revoking current session: %+v", err)
+			}
+		}
+	}
+	// The expiring cookie must carry the same attributes as the one it replaces
+	expiredOptions := sessionCookieOptions
+	expiredOptions.MaxAge = -1
+	session.Options(expiredOptions)
 	session.Clear()
 	err := session.Save()
 	if err != nil {
@@ -616,7 +3404,11 @@ func endUserSession(ctx *gin.Context) {
 
 func loadDatabase() {
 	db.Connect()
-	db.Database.AutoMigrate(&models.User{}, &models.Wallet{})
+	db.Database.AutoMigrate(&models.User{}, &models.Wallet{}, &models.Transaction{}, &models.NotificationPreference{}, &models.Authenticator{}, &models.Registration{}, &models.EmailVerification{}, &models.EmailClaim{}, &models.UserSession{}, &models.AuditEvent{}, &models.Contact{}, &models.SpendingPolicy{}, &models.TurnkeyPolicy{}, &models.PaymentBatch{}, &models.BatchPayment{},
+		&models.WebhookSubscription{}, &models.WebhookDelivery{}, &models.WebhookDeliveryAttempt{}, &models.WebhookDeadLetter{}, &models.RetiredSubOrganization{})
+	if err := models.MigrateEmailNormalization(); err != nil {
+		log.Fatalf("Unable to migrate user emails: %+v", err)
+	}
 }
 
 func loadEnv() {
@@ -0,0 +1,275 @@
+// Package siwe implements Sign-In With Ethereum (EIP-4361) messages: building and parsing them, and verifying
+// their signatures. It has no dependency on the rest of the wallet, so relying parties (dapps letting users log in
+// with their passkey wallet) can import it to check signatures and nonces themselves.
+package siwe
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The only version defined by EIP-4361
+const VERSION = "1"
+
+// Nonces must be at least this many alphanumeric characters
+const MIN_NONCE_LENGTH = 8
+
+const headerSuffix = " wants you to sign in with your Ethereum account:"
+
+const (
+	uriTag            = "URI: "
+	versionTag        = "Version: "
+	chainIdTag        = "Chain ID: "
+	nonceTag          = "Nonce: "
+	issuedAtTag       = "Issued At: "
+	expirationTimeTag = "Expiration Time: "
+	notBeforeTag      = "Not Before: "
+	requestIdTag      = "Request ID: "
+	resourcesTag      = "Resources:"
+	resourcePrefix    = "- "
+)
+
+// A SIWE message. Optional fields are left empty (or zero) when absent.
+type Message struct {
+	// RFC 3986 authority requesting the signing, optionally prefixed with a scheme (e.g. "https://example.com")
+	Domain string
+	// EIP-55 checksummed address of the signer
+	Address   string
+	Statement string
+	URI       string
+	Version   string
+	ChainID   int64
+	Nonce     string
+	IssuedAt  time.Time
+	// Optional
+	ExpirationTime time.Time
+	NotBefore      time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// Checks that the message is well-formed, as required before building or after parsing it
+func (m *Message) Validate() error {
+	if m.Domain == "" || strings.ContainsAny(m.Domain, " \n") {
+		return fmt.Errorf("invalid domain %q", m.Domain)
+	}
+	if !common.IsHexAddress(m.Address) || common.HexToAddress(m.Address).Hex() != m.Address {
+		return fmt.Errorf("address %q is not an EIP-55 checksummed address", m.Address)
+	}
+	if strings.Contains(m.Statement, "\n") {
+		return fmt.Errorf("statement must be a single line")
+	}
+	if _, err := url.ParseRequestURI(m.URI); err != nil {
+		return fmt.Errorf("invalid URI %q", m.URI)
+	}
+	if m.Version != VERSION {
+		return fmt.Errorf("unsupported version %q", m.Version)
+	}
+	if m.ChainID <= 0 {
+		return fmt.Errorf("invalid chain ID %d", m.ChainID)
+	}
+	if !isValidNonce(m.Nonce) {
+		return fmt.Errorf("nonce must be at least %d alphanumeric characters", MIN_NONCE_LENGTH)
+	}
+	if m.IssuedAt.IsZero() {
+		return fmt.Errorf("missing issued-at time")
+	}
+	if strings.Contains(m.RequestID, "\n") {
+		return fmt.Errorf("request ID must be a single line")
+	}
+	for _, resource := range m.Resources {
+		if _, err := url.ParseRequestURI(resource); err != nil {
+			return fmt.Errorf("invalid resource %q", resource)
+		}
+	}
+	return nil
+}
+
+// Returns the text to sign, formatted as specified by EIP-4361
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Domain + headerSuffix + "\n")
+	b.WriteString(m.Address + "\n")
+	b.WriteString("\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString(uriTag + m.URI + "\n")
+	b.WriteString(versionTag + m.Version + "\n")
+	b.WriteString(chainIdTag + strconv.FormatInt(m.ChainID, 10) + "\n")
+	b.WriteString(nonceTag + m.Nonce + "\n")
+	b.WriteString(issuedAtTag + formatTime(m.IssuedAt))
+	if !m.ExpirationTime.IsZero() {
+		b.WriteString("\n" + expirationTimeTag + formatTime(m.ExpirationTime))
+	}
+	if !m.NotBefore.IsZero() {
+		b.WriteString("\n" + notBeforeTag + formatTime(m.NotBefore))
+	}
+	if m.RequestID != "" {
+		b.WriteString("\n" + requestIdTag + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\n" + resourcesTag)
+		for _, resource := range m.Resources {
+			b.WriteString("\n" + resourcePrefix + resource)
+		}
+	}
+	return b.String()
+}
+
+// Parses a message formatted as specified by EIP-4361. Parsing is strict: fields must appear in order, exactly once.
+func ParseMessage(text string) (*Message, error) {
+	lines := strings.Split(text, "\n")
+	p := parser{lines: lines}
+	m := Message{}
+
+	header := p.next()
+	if !strings.HasSuffix(header, headerSuffix) {
+		return nil, fmt.Errorf("line 1: expected \"<domain>%s\"", headerSuffix)
+	}
+	m.Domain = strings.TrimSuffix(header, headerSuffix)
+	m.Address = p.next()
+	if err := p.expectEmpty(); err != nil {
+		return nil, err
+	}
+	// The statement is optional, but its surrounding empty lines aren't: no statement leaves two empty lines
+	if statement := p.next(); statement != "" {
+		m.Statement = statement
+		if err := p.expectEmpty(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if m.URI, err = p.required(uriTag); err != nil {
+		return nil, err
+	}
+	if m.Version, err = p.required(versionTag); err != nil {
+		return nil, err
+	}
+	chainId, err := p.required(chainIdTag)
+	if err != nil {
+		return nil, err
+	}
+	if m.ChainID, err = strconv.ParseInt(chainId, 10, 64); err != nil {
+		return nil, fmt.Errorf("line %d: invalid chain ID %q", p.position, chainId)
+	}
+	if m.Nonce, err = p.required(nonceTag); err != nil {
+		return nil, err
+	}
+	if m.IssuedAt, err = p.requiredTime(issuedAtTag); err != nil {
+		return nil, err
+	}
+	if m.ExpirationTime, err = p.optionalTime(expirationTimeTag); err != nil {
+		return nil, err
+	}
+	if m.NotBefore, err = p.optionalTime(notBeforeTag); err != nil {
+		return nil, err
+	}
+	m.RequestID, _ = p.optional(requestIdTag)
+	if p.peek() == resourcesTag {
+		p.next()
+		for p.hasNext() && strings.HasPrefix(p.peek(), resourcePrefix) {
+			m.Resources = append(m.Resources, strings.TrimPrefix(p.next(), resourcePrefix))
+		}
+	}
+	if p.hasNext() {
+		return nil, fmt.Errorf("line %d: unexpected content %q", p.position+1, p.peek())
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Walks through the lines of a message, tracking the position for error messages
+type parser struct {
+	lines    []string
+	position int
+}
+
+func (p *parser) hasNext() bool {
+	return p.position < len(p.lines)
+}
+
+func (p *parser) peek() string {
+	if !p.hasNext() {
+		return ""
+	}
+	return p.lines[p.position]
+}
+
+func (p *parser) next() string {
+	line := p.peek()
+	p.position++
+	return line
+}
+
+func (p *parser) expectEmpty() error {
+	if !p.hasNext() || p.next() != "" {
+		return fmt.Errorf("line %d: expected an empty line", p.position)
+	}
+	return nil
+}
+
+func (p *parser) required(tag string) (string, error) {
+	value, ok := p.optional(tag)
+	if !ok {
+		return "", fmt.Errorf("line %d: expected %q", p.position+1, strings.TrimSpace(tag))
+	}
+	return value, nil
+}
+
+func (p *parser) optional(tag string) (string, bool) {
+	if !p.hasNext() || !strings.HasPrefix(p.peek(), tag) {
+		return "", false
+	}
+	return strings.TrimPrefix(p.next(), tag), true
+}
+
+func (p *parser) requiredTime(tag string) (time.Time, error) {
+	value, err := p.required(tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return p.parseTime(value)
+}
+
+func (p *parser) optionalTime(tag string) (time.Time, error) {
+	value, ok := p.optional(tag)
+	if !ok {
+		return time.Time{}, nil
+	}
+	return p.parseTime(value)
+}
+
+func (p *parser) parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("line %d: invalid RFC 3339 time %q", p.position, value)
+	}
+	return t, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func isValidNonce(nonce string) bool {
+	if len(nonce) < MIN_NONCE_LENGTH {
+		return false
+	}
+	for _, c := range nonce {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package siwe
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The example message from EIP-4361, with an EIP-55 checksummed address
+const exampleMessage = `service.org wants you to sign in with your Ethereum account:
+0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266
+
+I accept the ServiceOrg Terms of Service: https://service.org/tos
+
+URI: https://service.org/login
+Version: 1
+Chain ID: 1
+Nonce: 32891757
+Issued At: 2021-09-30T16:25:24Z
+Resources:
+- ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/
+- https://example.com/my-web2-claim.json`
+
+func TestParseMessage(t *testing.T) {
+	message, err := ParseMessage(exampleMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Message{
+		Domain:    "service.org",
+		Address:   "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+		Statement: "I accept the ServiceOrg Terms of Service: https://service.org/tos",
+		URI:       "https://service.org/login",
+		Version:   "1",
+		ChainID:   1,
+		Nonce:     "32891757",
+		IssuedAt:  time.Date(2021, 9, 30, 16, 25, 24, 0, time.UTC),
+		Resources: []string{
+			"ipfs://bafybeiemxf5abjwjbikoz4mc3a3dla6ual3jsgpdr4cjr3oz3evfyavhwq/",
+			"https://example.com/my-web2-claim.json",
+		},
+	}
+	if !reflect.DeepEqual(message, expected) {
+		t.Errorf("unexpected message %+v", message)
+	}
+	if message.String() != exampleMessage {
+		t.Errorf("expected the message to format back to its text, got:\n%s", message.String())
+	}
+}
+
+func TestParseMessageRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+	}{
+		{"required fields only", Message{
+			Domain:   "example.com",
+			Address:  "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+			URI:      "https://example.com",
+			Version:  VERSION,
+			ChainID:  1,
+			Nonce:    "abcdefgh",
+			IssuedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{"all fields", Message{
+			Domain:         "https://example.com",
+			Address:        "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+			Statement:      "Sign in to Example",
+			URI:            "https://example.com/login",
+			Version:        VERSION,
+			ChainID:        11155111,
+			Nonce:          "Abc123Def456",
+			IssuedAt:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			ExpirationTime: time.Date(2024, 1, 1, 0, 10, 0, 0, time.UTC),
+			NotBefore:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			RequestID:      "request-1",
+			Resources:      []string{"https://example.com/terms"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.message.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := ParseMessage(test.message.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*parsed, test.message) {
+				t.Errorf("expected %+v, got %+v", test.message, *parsed)
+			}
+		})
+	}
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace string
+		with    string
+	}{
+		{"missing header", " wants you to sign in with your Ethereum account:", ""},
+		{"lowercase address", "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "0xf39fd6e51aad88f6f4ce6ab8827279cfffb92266"},
+		{"invalid address", "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", "0x1234"},
+		{"missing empty line after statement", "tos\n\nURI", "tos\nURI"},
+		{"unsupported version", "Version: 1", "Version: 2"},
+		{"invalid chain ID", "Chain ID: 1", "Chain ID: one"},
+		{"zero chain ID", "Chain ID: 1", "Chain ID: 0"},
+		{"short nonce", "Nonce: 32891757", "Nonce: 1234"},
+		{"non-alphanumeric nonce", "Nonce: 32891757", "Nonce: 3289-1757"},
+		{"invalid issued-at time", "2021-09-30T16:25:24Z", "2021-09-30 16:25:24"},
+		{"fields out of order", "Version: 1\nChain ID: 1", "Chain ID: 1\nVersion: 1"},
+		{"missing nonce", "Nonce: 32891757\n", ""},
+		{"invalid resource", "- https://example.com/my-web2-claim.json", "- not a uri"},
+		{"trailing content", "my-web2-claim.json", "my-web2-claim.json\nextra"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !strings.Contains(exampleMessage, test.replace) {
+				t.Fatalf("%q isn't part of the example message", test.replace)
+			}
+			text := strings.Replace(exampleMessage, test.replace, test.with, 1)
+			if _, err := ParseMessage(text); err == nil {
+				t.Errorf("expected an error parsing:\n%s", text)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package siwe
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Generated nonces are this long, well above the EIP-4361 minimum
+const NONCE_LENGTH = 17
+
+const nonceAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Issues nonces and accepts each of them once, so that signed messages can't be replayed
+type NonceStore interface {
+	Issue() (string, error)
+	// Returns true if the nonce was issued, hasn't expired, and wasn't consumed before
+	Consume(nonce string) bool
+}
+
+// Returns a random alphanumeric nonce
+func GenerateNonce() (string, error) {
+	nonce := make([]byte, NONCE_LENGTH)
+	max := big.NewInt(int64(len(nonceAlphabet)))
+	for i := range nonce {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", errors.Wrap(err, "unable to generate nonce")
+		}
+		nonce[i] = nonceAlphabet[n.Int64()]
+	}
+	return string(nonce), nil
+}
+
+// Keeps nonces in memory for TTL. Nonces apply per process, which is good enough for a single-instance deployment.
+type MemoryNonceStore struct {
+	TTL time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func NewMemoryNonceStore(ttl time.Duration) *MemoryNonceStore {
+	return &MemoryNonceStore{
+		TTL:    ttl,
+		nonces: map[string]time.Time{},
+	}
+}
+
+func (s *MemoryNonceStore) Issue() (string, error) {
+	nonce, err := GenerateNonce()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	// Expired nonces are dropped as new ones are issued
+	for n, expiresAt := range s.nonces {
+		if now.After(expiresAt) {
+			delete(s.nonces, n)
+		}
+	}
+	s.nonces[nonce] = now.Add(s.TTL)
+	return nonce, nil
+}
+
+func (s *MemoryNonceStore) Consume(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt, ok := s.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(s.nonces, nonce)
+	return time.Now().Before(expiresAt)
+}
@@ -0,0 +1,110 @@
+package siwe
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidSignature = errors.New("signature was not made by the message's address")
+var ErrDomainMismatch = errors.New("message was signed for another domain")
+var ErrChainMismatch = errors.New("message was signed for another chain")
+var ErrNonceMismatch = errors.New("message nonce is unknown or was already used")
+var ErrExpired = errors.New("message has expired")
+var ErrNotYetValid = errors.New("message is not valid yet")
+var ErrNonceCheckRequired = errors.New("an expected nonce or a nonce store is required to prevent replays")
+
+// What relying parties expect of a message. Empty fields aren't checked, except for the nonce: either Nonce or
+// Nonces must be set.
+type VerifyOptions struct {
+	// Usually the relying party's own domain: messages signed for other domains may be phishing attempts
+	Domain  string
+	ChainID int64
+	// Expected nonce, for relying parties keeping track of the nonce they issued to a given client
+	Nonce string
+	// Alternatively, nonces are consumed from a store: each nonce is only accepted once
+	Nonces NonceStore
+	// Defaults to the current time
+	Now time.Time
+}
+
+// Returns the digest signed for a message: the EIP-191 (personal_sign) hash of its text
+func Digest(text string) []byte {
+	return accounts.TextHash([]byte(text))
+}
+
+// Parses a signed message and checks it: signature (hex-encoded, 65 bytes), domain, chain, validity period and nonce.
+// The message's nonce is consumed from opts.Nonces last, so that invalid messages don't burn nonces.
+func Verify(text string, signature string, opts VerifyOptions) (*Message, error) {
+	if opts.Nonce == "" && opts.Nonces == nil {
+		return nil, ErrNonceCheckRequired
+	}
+	message, err := ParseMessage(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid SIWE message")
+	}
+
+	if err := verifySignature(Digest(text), signature, message.Address); err != nil {
+		return nil, err
+	}
+	if opts.Domain != "" && message.Domain != opts.Domain {
+		return nil, ErrDomainMismatch
+	}
+	if opts.ChainID != 0 && message.ChainID != opts.ChainID {
+		return nil, ErrChainMismatch
+	}
+
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if !message.ExpirationTime.IsZero() && !now.Before(message.ExpirationTime) {
+		return nil, ErrExpired
+	}
+	if !message.NotBefore.IsZero() && now.Before(message.NotBefore) {
+		return nil, ErrNotYetValid
+	}
+
+	if opts.Nonce != "" && message.Nonce != opts.Nonce {
+		return nil, ErrNonceMismatch
+	}
+	if opts.Nonces != nil && !opts.Nonces.Consume(message.Nonce) {
+		return nil, ErrNonceMismatch
+	}
+	return message, nil
+}
+
+// Returns true for errors meaning the message or its signature can't be trusted (as opposed to internal failures)
+func IsVerificationError(err error) bool {
+	switch err {
+	case ErrInvalidSignature, ErrDomainMismatch, ErrChainMismatch, ErrNonceMismatch, ErrExpired, ErrNotYetValid:
+		return true
+	}
+	return false
+}
+
+// Only externally owned accounts are supported: contract wallets (EIP-1271) would need an RPC call
+func verifySignature(digest []byte, signature string, address string) error {
+	signatureBytes, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(signatureBytes) != crypto.SignatureLength {
+		return fmt.Errorf("expected a hex-encoded %d-byte signature", crypto.SignatureLength)
+	}
+	// Signatures carry the recovery ID as 27 or 28, crypto.SigToPub expects 0 or 1
+	if signatureBytes[crypto.RecoveryIDOffset] >= 27 {
+		signatureBytes[crypto.RecoveryIDOffset] -= 27
+	}
+	publicKey, err := crypto.SigToPub(digest, signatureBytes)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if crypto.PubkeyToAddress(*publicKey) != common.HexToAddress(address) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
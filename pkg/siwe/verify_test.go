@@ -0,0 +1,135 @@
+package siwe
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Well-known development key (the first Hardhat account), never to be used for real funds
+const testPrivateKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+var issuedAt = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testMessage(t *testing.T) *Message {
+	key, err := crypto.HexToECDSA(testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Message{
+		Domain:         "example.com",
+		Address:        crypto.PubkeyToAddress(key.PublicKey).Hex(),
+		Statement:      "Sign in to Example",
+		URI:            "https://example.com/login",
+		Version:        VERSION,
+		ChainID:        1,
+		Nonce:          "abcdefgh1234",
+		IssuedAt:       issuedAt,
+		ExpirationTime: issuedAt.Add(10 * time.Minute),
+		NotBefore:      issuedAt,
+	}
+}
+
+// Signs like personal_sign does, with a recovery ID of 27 or 28
+func sign(t *testing.T, text string) string {
+	key, err := crypto.HexToECDSA(testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := crypto.Sign(Digest(text), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return "0x" + hex.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	text := testMessage(t).String()
+	signature := sign(t, text)
+
+	otherMessage := testMessage(t)
+	otherMessage.Nonce = "otherNonce123"
+	otherSignature := sign(t, otherMessage.String())
+
+	tests := []struct {
+		name      string
+		signature string
+		opts      VerifyOptions
+		err       error
+	}{
+		{"valid", signature, VerifyOptions{Domain: "example.com", ChainID: 1, Nonce: "abcdefgh1234", Now: issuedAt.Add(time.Minute)}, nil},
+		{"valid without 0x prefix", signature[2:], VerifyOptions{Nonce: "abcdefgh1234", Now: issuedAt}, nil},
+		{"signature of another message", otherSignature, VerifyOptions{Nonce: "abcdefgh1234", Now: issuedAt}, ErrInvalidSignature},
+		{"other domain", signature, VerifyOptions{Domain: "evil.com", Nonce: "abcdefgh1234", Now: issuedAt}, ErrDomainMismatch},
+		{"other chain", signature, VerifyOptions{ChainID: 11155111, Nonce: "abcdefgh1234", Now: issuedAt}, ErrChainMismatch},
+		{"other nonce", signature, VerifyOptions{Nonce: "somethingElse", Now: issuedAt}, ErrNonceMismatch},
+		{"expired", signature, VerifyOptions{Nonce: "abcdefgh1234", Now: issuedAt.Add(10 * time.Minute)}, ErrExpired},
+		{"not yet valid", signature, VerifyOptions{Nonce: "abcdefgh1234", Now: issuedAt.Add(-time.Second)}, ErrNotYetValid},
+		{"no nonce check", signature, VerifyOptions{Domain: "example.com", ChainID: 1, Now: issuedAt}, ErrNonceCheckRequired},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := Verify(text, test.signature, test.opts)
+			if err != test.err {
+				t.Fatalf("Verify() error = %v, expected %v", err, test.err)
+			}
+			if err == nil && message.Nonce != "abcdefgh1234" {
+				t.Errorf("unexpected message %+v", message)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	text := testMessage(t).String()
+	signature := sign(t, text)
+
+	tests := []struct {
+		name      string
+		text      string
+		signature string
+	}{
+		{"invalid message", "not a SIWE message", signature},
+		{"signature not hex", text, "0xzz"},
+		{"short signature", text, signature[:len(signature)-2]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Verify(test.text, test.signature, VerifyOptions{Nonce: "abcdefgh1234", Now: issuedAt})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if IsVerificationError(err) {
+				t.Errorf("expected a malformed input error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyConsumesNonce(t *testing.T) {
+	nonces := NewMemoryNonceStore(time.Minute)
+	nonce, err := nonces.Issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := testMessage(t)
+	message.Nonce = nonce
+	message.ExpirationTime, message.NotBefore = time.Time{}, time.Time{}
+	text := message.String()
+	signature := sign(t, text)
+
+	// Invalid messages don't burn the nonce
+	if _, err := Verify(text, signature, VerifyOptions{Domain: "evil.com", Nonces: nonces}); err != ErrDomainMismatch {
+		t.Fatalf("expected ErrDomainMismatch, got %v", err)
+	}
+	if _, err := Verify(text, signature, VerifyOptions{Nonces: nonces}); err != nil {
+		t.Fatal(err)
+	}
+	// Replays are rejected
+	if _, err := Verify(text, signature, VerifyOptions{Nonces: nonces}); err != ErrNonceMismatch {
+		t.Fatalf("expected ErrNonceMismatch on replay, got %v", err)
+	}
+}